@@ -0,0 +1,991 @@
+// packbuf-gen generates MarshalPackbuf and UnmarshalPackbuf methods for every packet
+// registered in a package, so that packbuf.Write and packbuf.Read don't need to use reflection.
+//
+// The generated code writes the exact same bytes as the reflection path, it's meant
+// to be run with go generate from the package that registers the packets, ie.
+//
+//	//go:generate go run github.com/silbinarywolf/toy-webrtc-mmo/cmd/packbuf-gen
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
+)
+
+const packbufImportPath = "github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
+
+var (
+	flagOutput   = flag.String("output", "packbuf_gen.go", "file name to write the generated code to")
+	flagRegister = flag.String("register", "register", "name of the function or method that packets are registered with, ie. register(&MyPacket{}) or MustRegister for packs.ClientToServer.MustRegister(&MyPacket{})")
+	flagTags     = flag.String("tags", "", "comma-separated list of build tags to use when finding files")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("packbuf-gen: ")
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	ctx := build.Default
+	if *flagTags != "" {
+		ctx.BuildTags = strings.Split(*flagTags, ",")
+	}
+	g := &generator{
+		ctx:      ctx,
+		fset:     token.NewFileSet(),
+		packages: make(map[string]*packageInfo),
+		resolved: make(map[string]*typeInfo),
+		imports:  make(map[string]string),
+	}
+	src, err := g.generate(dir, *flagOutput, *flagRegister)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, *flagOutput), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type packageInfo struct {
+	// path is the import path, this is empty for the package we're generating code for
+	path  string
+	name  string
+	dir   string
+	types map[string]typeDecl
+	// methods maps the name of each type to its methods
+	methods map[string]map[string]bool
+}
+
+type typeDecl struct {
+	spec *ast.TypeSpec
+	file *ast.File
+}
+
+type typeKind int
+
+const (
+	kindBasic typeKind = iota
+	kindStruct
+	kindSlice
+	kindArray
+	kindMap
+	kindPointer
+	// kindCustom is a type that has its own Marshal and Unmarshal methods
+	kindCustom
+)
+
+type hookKind int
+
+const (
+	hookNone hookKind = iota
+	// hookPackbuf is a MarshalPackbuf or UnmarshalPackbuf method
+	hookPackbuf
+	// hookBinary is a MarshalBinary or UnmarshalBinary method
+	hookBinary
+)
+
+type hookInfo struct {
+	kind hookKind
+	// ptr is true if the method has a pointer receiver
+	ptr bool
+}
+
+type typeInfo struct {
+	kind typeKind
+	// expr is the type as written in the generated file, ie. "[]PlayerState" or "ent.PlayerInput"
+	expr string
+	// pkgPath is the import path needed to use expr, if any
+	pkgPath string
+	// basic is the name of the type for kindBasic, ie. "uint16"
+	basic string
+	// elem is the element type of a slice, array or map or what a pointer points to
+	elem *typeInfo
+	// key is the key type of a map
+	key    *typeInfo
+	fields []fieldInfo
+	// methods maps the name of each method of a named type to true if it has a pointer receiver
+	methods map[string]bool
+	// marshal and unmarshal are the methods packbuf uses instead of reflection, if any
+	marshal   hookInfo
+	unmarshal hookInfo
+}
+
+// method returns if the type has the method, including methods promoted from
+// embedded fields, and if it needs a pointer to call it
+func (t *typeInfo) method(name string) (ptr bool, ok bool) {
+	if ptr, ok := t.methods[name]; ok {
+		return ptr, true
+	}
+	if t.kind != kindStruct {
+		return false, false
+	}
+	found := 0
+	for _, f := range t.fields {
+		if !f.embedded || f.typ == nil {
+			continue
+		}
+		embedded := f.typ
+		isPtr := embedded.kind == kindPointer
+		if isPtr {
+			embedded = embedded.elem
+		}
+		if embeddedPtr, ok := embedded.method(name); ok {
+			// methods of embedded pointers can be called without a pointer
+			ptr = embeddedPtr && !isPtr
+			found++
+		}
+	}
+	// if more than one embedded field has the method, Go doesn't promote it
+	return ptr, found == 1
+}
+
+// hook returns the method that packbuf will use instead of reflection, if any
+func (t *typeInfo) hook(packbufMethod, binaryMethod string) hookInfo {
+	if ptr, ok := t.method(packbufMethod); ok {
+		return hookInfo{kind: hookPackbuf, ptr: ptr}
+	}
+	if ptr, ok := t.method(binaryMethod); ok {
+		return hookInfo{kind: hookBinary, ptr: ptr}
+	}
+	return hookInfo{}
+}
+
+func (t *typeInfo) hasHook() bool {
+	return t.marshal.kind != hookNone || t.unmarshal.kind != hookNone
+}
+
+type fieldInfo struct {
+	name     string
+	typ      *typeInfo
+	embedded bool
+	options  packbuf.FieldOptions
+}
+
+type generator struct {
+	ctx      build.Context
+	fset     *token.FileSet
+	packages map[string]*packageInfo
+	// resolved are the named types we've already resolved, nil while they're being resolved
+	resolved map[string]*typeInfo
+	// imports maps the import path to the package name of every package the generated code uses
+	imports map[string]string
+
+	buf   bytes.Buffer
+	depth int
+}
+
+func (g *generator) generate(dir, output, registerFunc string) ([]byte, error) {
+	bp, err := g.ctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	pkg, files, err := g.parsePackage("", bp, output)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find every packet registered with register(&MyPacket{}), or with -register=MustRegister,
+	// packs.ClientToServer.MustRegister(&MyPacket{})
+	var packets []string
+	isRegistered := make(map[string]bool)
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			switch fn := call.Fun.(type) {
+			case *ast.Ident:
+				if fn.Name != registerFunc {
+					return true
+				}
+			case *ast.SelectorExpr:
+				if fn.Sel.Name != registerFunc {
+					return true
+				}
+			default:
+				return true
+			}
+			addr, ok := call.Args[0].(*ast.UnaryExpr)
+			if !ok || addr.Op != token.AND {
+				return true
+			}
+			lit, ok := addr.X.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+			// the same packet can be registered with more than one registry
+			if name, ok := lit.Type.(*ast.Ident); ok && !isRegistered[name.Name] {
+				isRegistered[name.Name] = true
+				packets = append(packets, name.Name)
+			}
+			return true
+		})
+	}
+	if len(packets) == 0 {
+		return nil, errors.New("no packets registered with " + registerFunc + "(&MyPacket{}) in " + bp.Dir)
+	}
+
+	g.imports[packbufImportPath] = "packbuf"
+	var body bytes.Buffer
+	for _, name := range packets {
+		t, err := g.resolveNamed(pkg, name)
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != kindStruct && t.kind != kindCustom {
+			return nil, errors.New(name + " must be a struct")
+		}
+		// packets with their own Marshal or Unmarshal methods are already
+		// written and read without reflection
+		g.buf.Reset()
+		if t.marshal.kind == hookNone {
+			g.printf("// MarshalPackbuf writes %s without using reflection\n", name)
+			g.printf("func (packet *%s) MarshalPackbuf(e *packbuf.Encoder) error {\n", name)
+			g.writeStruct(t, "packet")
+			g.printf("return nil\n}\n\n")
+		}
+		if t.unmarshal.kind == hookNone {
+			g.printf("// UnmarshalPackbuf reads %s without using reflection\n", name)
+			g.printf("func (packet *%s) UnmarshalPackbuf(d *packbuf.Decoder) error {\n", name)
+			g.readStruct(t, "packet")
+			g.printf("return nil\n}\n\n")
+		}
+		body.Write(g.buf.Bytes())
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by packbuf-gen. DO NOT EDIT.\n\npackage %s\n\n", pkg.name)
+	var stdImports, otherImports []string
+	for path := range g.imports {
+		if strings.Contains(path, ".") {
+			otherImports = append(otherImports, path)
+		} else {
+			stdImports = append(stdImports, path)
+		}
+	}
+	sort.Strings(stdImports)
+	sort.Strings(otherImports)
+	out.WriteString("import (\n")
+	for _, path := range stdImports {
+		fmt.Fprintf(&out, "%q\n", path)
+	}
+	out.WriteString("\n")
+	for _, path := range otherImports {
+		fmt.Fprintf(&out, "%q\n", path)
+	}
+	out.WriteString(")\n\n")
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("unable to format generated code: %w\n%s", err, out.Bytes())
+	}
+	return src, nil
+}
+
+// parsePackage parses the Go files of a package, skipping the file we're generating
+func (g *generator) parsePackage(path string, bp *build.Package, skipFile string) (*packageInfo, []*ast.File, error) {
+	pkg := &packageInfo{
+		path:    path,
+		name:    bp.Name,
+		dir:     bp.Dir,
+		types:   make(map[string]typeDecl),
+		methods: make(map[string]map[string]bool),
+	}
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		if name == skipFile {
+			continue
+		}
+		file, err := parser.ParseFile(g.fset, filepath.Join(bp.Dir, name), nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+		for _, decl := range file.Decls {
+			if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv != nil && len(funcDecl.Recv.List) == 1 {
+				recv := funcDecl.Recv.List[0].Type
+				star, isPtr := recv.(*ast.StarExpr)
+				if isPtr {
+					recv = star.X
+				}
+				if recvName, ok := recv.(*ast.Ident); ok {
+					methods := pkg.methods[recvName.Name]
+					if methods == nil {
+						methods = make(map[string]bool)
+						pkg.methods[recvName.Name] = methods
+					}
+					methods[funcDecl.Name.Name] = isPtr
+				}
+				continue
+			}
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				pkg.types[spec.Name.Name] = typeDecl{
+					spec: spec,
+					file: file,
+				}
+			}
+		}
+	}
+	return pkg, files, nil
+}
+
+// importPackage loads the package imported by file with the given name, ie. "ent"
+func (g *generator) importPackage(from *packageInfo, file *ast.File, name string) (*packageInfo, error) {
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Name != nil && spec.Name.Name != name {
+			continue
+		}
+		pkg, ok := g.packages[path]
+		if !ok {
+			bp, err := g.ctx.Import(path, from.dir, 0)
+			if err != nil {
+				return nil, err
+			}
+			pkg, _, err = g.parsePackage(path, bp, "")
+			if err != nil {
+				return nil, err
+			}
+			g.packages[path] = pkg
+		}
+		if spec.Name != nil || pkg.name == name {
+			return pkg, nil
+		}
+	}
+	return nil, errors.New("unable to find import for " + name)
+}
+
+func (g *generator) resolve(pkg *packageInfo, file *ast.File, expr ast.Expr) (*typeInfo, error) {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if _, ok := pkg.types[expr.Name]; ok {
+			return g.resolveNamed(pkg, expr.Name)
+		}
+		switch name := expr.Name; name {
+		case "bool", "string", "float32", "float64",
+			"int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64":
+			return &typeInfo{kind: kindBasic, expr: name, basic: name}, nil
+		case "byte":
+			return &typeInfo{kind: kindBasic, expr: name, basic: "uint8"}, nil
+		}
+		return nil, errors.New("unsupported type: " + expr.Name)
+	case *ast.SelectorExpr:
+		pkgName, ok := expr.X.(*ast.Ident)
+		if !ok {
+			return nil, fmt.Errorf("unsupported type: %T", expr.X)
+		}
+		importPkg, err := g.importPackage(pkg, file, pkgName.Name)
+		if err != nil {
+			return nil, err
+		}
+		return g.resolveNamed(importPkg, expr.Sel.Name)
+	case *ast.ArrayType:
+		elem, err := g.resolve(pkg, file, expr.Elt)
+		if err != nil {
+			return nil, err
+		}
+		if expr.Len == nil {
+			return &typeInfo{kind: kindSlice, expr: "[]" + elem.expr, pkgPath: elem.pkgPath, elem: elem}, nil
+		}
+		length, ok := expr.Len.(*ast.BasicLit)
+		if !ok || length.Kind != token.INT {
+			return nil, errors.New("array lengths must be a number")
+		}
+		return &typeInfo{kind: kindArray, expr: "[" + length.Value + "]" + elem.expr, pkgPath: elem.pkgPath, elem: elem}, nil
+	case *ast.MapType:
+		key, err := g.resolve(pkg, file, expr.Key)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := g.resolve(pkg, file, expr.Value)
+		if err != nil {
+			return nil, err
+		}
+		if key.pkgPath != "" && elem.pkgPath != "" && key.pkgPath != elem.pkgPath {
+			return nil, errors.New("maps with keys and values from different packages are not supported")
+		}
+		pkgPath := key.pkgPath
+		if pkgPath == "" {
+			pkgPath = elem.pkgPath
+		}
+		return &typeInfo{kind: kindMap, expr: "map[" + key.expr + "]" + elem.expr, pkgPath: pkgPath, key: key, elem: elem}, nil
+	case *ast.StarExpr:
+		elem, err := g.resolve(pkg, file, expr.X)
+		if err != nil {
+			return nil, err
+		}
+		return &typeInfo{kind: kindPointer, expr: "*" + elem.expr, pkgPath: elem.pkgPath, elem: elem}, nil
+	case *ast.ParenExpr:
+		return g.resolve(pkg, file, expr.X)
+	}
+	return nil, fmt.Errorf("unsupported type: %T", expr)
+}
+
+func (g *generator) resolveNamed(pkg *packageInfo, name string) (*typeInfo, error) {
+	qualifiedName := name
+	if pkg.path != "" {
+		qualifiedName = pkg.name + "." + name
+	}
+	if t, ok := g.resolved[pkg.path+"."+name]; ok {
+		if t == nil {
+			return nil, errors.New("recursive types are not supported: " + qualifiedName)
+		}
+		return t, nil
+	}
+	decl, ok := pkg.types[name]
+	if !ok {
+		return nil, errors.New("unable to find type: " + qualifiedName)
+	}
+	if !ast.IsExported(name) && pkg.path != "" {
+		return nil, errors.New("cannot use unexported type: " + qualifiedName)
+	}
+	if decl.spec.Assign.IsValid() {
+		// type alias
+		return g.resolve(pkg, decl.file, decl.spec.Type)
+	}
+	g.resolved[pkg.path+"."+name] = nil
+
+	methods := pkg.methods[name]
+	var t *typeInfo
+	switch underlying := decl.spec.Type.(type) {
+	case *ast.StructType:
+		if own := (&typeInfo{methods: methods}); own.hook("MarshalPackbuf", "MarshalBinary").kind != hookNone &&
+			own.hook("UnmarshalPackbuf", "UnmarshalBinary").kind != hookNone {
+			// the fields don't matter if the type reads and writes itself, ie. time.Time
+			t = &typeInfo{kind: kindCustom, expr: qualifiedName, pkgPath: pkg.path}
+			break
+		}
+		t = &typeInfo{kind: kindStruct, expr: qualifiedName, pkgPath: pkg.path}
+		for _, field := range underlying.Fields.List {
+			var tag string
+			if field.Tag != nil {
+				structTag, err := strconv.Unquote(field.Tag.Value)
+				if err != nil {
+					return nil, err
+				}
+				tag = reflect.StructTag(structTag).Get("packbuf")
+			}
+			names := field.Names
+			if len(names) == 0 {
+				// embedded field, ie. "ent.PlayerInput"
+				embedded := field.Type
+				if star, ok := embedded.(*ast.StarExpr); ok {
+					embedded = star.X
+				}
+				switch embedded := embedded.(type) {
+				case *ast.Ident:
+					names = []*ast.Ident{embedded}
+				case *ast.SelectorExpr:
+					names = []*ast.Ident{embedded.Sel}
+				}
+			}
+			for _, fieldName := range names {
+				f, err := g.resolveField(pkg, decl.file, qualifiedName, fieldName.Name, field.Type, tag)
+				if err != nil {
+					return nil, err
+				}
+				f.embedded = len(field.Names) == 0
+				t.fields = append(t.fields, f)
+			}
+		}
+	default:
+		// named slices, maps, numbers, etc, ie. "type PacketID uint8"
+		underlyingType, err := g.resolve(pkg, decl.file, underlying)
+		if err != nil {
+			return nil, err
+		}
+		if underlyingType.kind == kindStruct {
+			return nil, errors.New("named types of other structs are not supported: " + qualifiedName)
+		}
+		named := *underlyingType
+		named.expr = qualifiedName
+		named.pkgPath = pkg.path
+		t = &named
+	}
+	// named types don't have the methods of their underlying type
+	t.methods = methods
+	t.marshal = t.hook("MarshalPackbuf", "MarshalBinary")
+	t.unmarshal = t.hook("UnmarshalPackbuf", "UnmarshalBinary")
+	g.resolved[pkg.path+"."+name] = t
+	return t, nil
+}
+
+func (g *generator) resolveField(pkg *packageInfo, file *ast.File, structName, name string, expr ast.Expr, tag string) (fieldInfo, error) {
+	f := fieldInfo{
+		name: name,
+	}
+	fieldErr := func(err error) error {
+		return errors.New(structName + "." + name + ": " + err.Error())
+	}
+	options, err := packbuf.ParseTag(tag)
+	if err != nil {
+		return f, fieldErr(err)
+	}
+	f.options = options
+	if options.Skip {
+		return f, nil
+	}
+	if !ast.IsExported(name) {
+		// packbuf.Write can't serialize unexported fields
+		return f, fieldErr(errors.New("cannot serialize unexported field, use `packbuf:\"-\"` to skip it"))
+	}
+	t, err := g.resolve(pkg, file, expr)
+	if err != nil {
+		return f, fieldErr(err)
+	}
+	f.typ = t
+	if err := checkField(&f); err != nil {
+		return f, fieldErr(err)
+	}
+	return f, nil
+}
+
+// checkField returns an error if the reflection path of packbuf wouldn't be able
+// to read or write the field
+func checkField(f *fieldInfo) error {
+	opts := &f.options
+	// options apply to what a pointer points to
+	t := f.typ
+	for t.kind == kindPointer {
+		t = t.elem
+	}
+	// range and quantize apply to each number in a slice, array or map
+	elemType := t
+	for elemType.elem != nil {
+		elemType = elemType.elem
+	}
+	if t.hasHook() && (opts.HasMaxSize || opts.HasRange || opts.HasQuantize) {
+		return errors.New("cannot be used on " + t.expr + " as it has its own Marshal or Unmarshal method")
+	}
+	if elemType.hasHook() && (opts.HasRange || opts.HasQuantize) {
+		return errors.New("cannot be used on " + elemType.expr + " as it has its own Marshal or Unmarshal method")
+	}
+	if opts.HasMaxSize {
+		if t.kind != kindSlice && t.kind != kindMap && t.basic != "string" {
+			return errors.New("maxsize can only be used on slices, maps or strings")
+		}
+		if t.basic == "string" && opts.MaxSize > math.MaxUint16 {
+			return errors.New("maxsize cannot be larger than 65535 for strings")
+		}
+	}
+	if opts.HasRange {
+		min, max, ok := intBounds(elemType)
+		if !ok {
+			return errors.New("range can only be used on integers")
+		}
+		// it's a lot more helpful to find out now than when a packet fails to be read
+		if opts.RangeMin < min || (opts.RangeMax >= 0 && uint64(opts.RangeMax) > max) {
+			return fmt.Errorf("range of %d to %d doesn't fit in %s", opts.RangeMin, opts.RangeMax, elemType.basic)
+		}
+	}
+	if opts.HasQuantize && (elemType.kind != kindBasic || (elemType.basic != "float32" && elemType.basic != "float64")) {
+		return errors.New("quantize can only be used on floats")
+	}
+	return checkType(f.typ)
+}
+
+// checkType returns an error if the type can't be read or written
+func checkType(t *typeInfo) error {
+	switch t.kind {
+	case kindBasic, kindStruct, kindCustom:
+		// struct fields are checked when they're resolved
+		return nil
+	case kindMap:
+		// the reflection path can only sort numbers and strings
+		if t.key.kind != kindBasic || t.key.basic == "bool" {
+			return errors.New("unsupported map key type: " + t.key.expr + ", map keys must be numbers or strings")
+		}
+		return checkType(t.elem)
+	}
+	return checkType(t.elem)
+}
+
+// intBounds returns the smallest and largest value an integer type can hold
+func intBounds(t *typeInfo) (int64, uint64, bool) {
+	if t.kind != kindBasic {
+		return 0, 0, false
+	}
+	switch t.basic {
+	case "int8":
+		return math.MinInt8, math.MaxInt8, true
+	case "int16":
+		return math.MinInt16, math.MaxInt16, true
+	case "int32":
+		return math.MinInt32, math.MaxInt32, true
+	case "int", "int64":
+		// "int" can be 32-bit or 64-bit in Golang spec, so assuming int64 (largest)
+		return math.MinInt64, math.MaxInt64, true
+	case "uint8":
+		return 0, math.MaxUint8, true
+	case "uint16":
+		return 0, math.MaxUint16, true
+	case "uint32":
+		return 0, math.MaxUint32, true
+	case "uint", "uint64":
+		return 0, math.MaxUint64, true
+	}
+	return 0, 0, false
+}
+
+func isUnsigned(t *typeInfo) bool {
+	return strings.HasPrefix(t.basic, "uint")
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// use records that the generated code references the type
+func (g *generator) use(t *typeInfo) string {
+	if t.pkgPath != "" {
+		g.imports[t.pkgPath] = g.packages[t.pkgPath].name
+	}
+	return t.expr
+}
+
+// wrapErr returns the code to add the struct and field name to err, the same as the reflection path
+func (g *generator) wrapErr(owner *typeInfo, f *fieldInfo) string {
+	return g.wrap(owner, f, "err")
+}
+
+func (g *generator) wrap(owner *typeInfo, f *fieldInfo, err string) string {
+	g.imports["reflect"] = "reflect"
+	return fmt.Sprintf("return &packbuf.FieldError{Type: reflect.TypeOf(%s{}), Field: %q, Err: %s}", g.use(owner), f.name, err)
+}
+
+// convert returns expr converted to the type t if it isn't already that type
+func (g *generator) convert(t *typeInfo, expr string, exprType string) string {
+	if t.expr == exprType {
+		return expr
+	}
+	return g.use(t) + "(" + expr + ")"
+}
+
+// name returns a variable name that won't collide with variables in outer loops
+func (g *generator) name(prefix string) string {
+	return prefix + strconv.Itoa(g.depth)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var basicMethodNames = map[string]string{
+	"bool":    "Bool",
+	"int8":    "Int8",
+	"int16":   "Int16",
+	"int32":   "Int32",
+	"int":     "Int64",
+	"int64":   "Int64",
+	"uint8":   "Uint8",
+	"uint16":  "Uint16",
+	"uint32":  "Uint32",
+	"uint":    "Uint64",
+	"uint64":  "Uint64",
+	"float32": "Float32",
+	"float64": "Float64",
+}
+
+// basicMethodTypes is the type that each Encoder and Decoder method uses
+var basicMethodTypes = map[string]string{
+	"int":  "int64",
+	"uint": "uint64",
+}
+
+func basicMethodType(t *typeInfo) string {
+	if methodType, ok := basicMethodTypes[t.basic]; ok {
+		return methodType
+	}
+	return t.basic
+}
+
+func (g *generator) writeStruct(t *typeInfo, expr string) {
+	for i := range t.fields {
+		f := &t.fields[i]
+		if f.options.Skip {
+			continue
+		}
+		g.writeValue(t, f, f.typ, expr+"."+f.name, true)
+	}
+}
+
+// writeValue writes the code to write a field, or an element of a slice, array or map
+//
+// maxsize only applies to the field itself, while range and quantize apply to
+// every number in the field, ie. each number in a slice
+func (g *generator) writeValue(owner *typeInfo, f *fieldInfo, t *typeInfo, expr string, isField bool) {
+	opts := &f.options
+	hasMaxSize := isField && opts.HasMaxSize
+	if t.kind == kindPointer {
+		// pointers are optional, so write if they're set or not
+		g.printf("if err := e.WriteBool(%s != nil); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+		g.printf("if %s != nil {\n", expr)
+		g.writeValue(owner, f, t.elem, "(*"+expr+")", isField)
+		g.printf("}\n")
+		return
+	}
+	if t.marshal.kind != hookNone {
+		g.writeHook(owner, f, t, expr)
+		return
+	}
+	switch t.kind {
+	case kindStruct:
+		g.writeStruct(t, expr)
+	case kindSlice, kindMap:
+		if hasMaxSize {
+			g.printf("if err := e.WriteSize(len(%s), %d); err != nil {\n%s\n}\n", expr, opts.MaxSize, g.wrapErr(owner, f))
+		} else {
+			g.printf("if err := e.WriteLength(len(%s)); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+		}
+		if t.kind == kindSlice && t.elem.basic == "uint8" && t.elem.kind == kindBasic && !opts.HasRange {
+			g.printf("if err := e.WriteBytes(%s); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+			return
+		}
+		if t.kind == kindSlice {
+			index := g.name("i")
+			g.depth++
+			g.printf("for %s := range %s {\n", index, expr)
+			g.writeValue(owner, f, t.elem, expr+"["+index+"]", false)
+			g.printf("}\n")
+			g.depth--
+			return
+		}
+		// maps are written in order of their keys so the same map is
+		// always written as the same bytes
+		keys, key, value := g.name("keys"), g.name("key"), g.name("value")
+		g.depth++
+		g.imports["sort"] = "sort"
+		g.printf("{\n")
+		g.printf("%s := make([]%s, 0, len(%s))\n", keys, g.use(t.key), expr)
+		g.printf("for %s := range %s {\n%s = append(%s, %s)\n}\n", key, expr, keys, keys, key)
+		g.printf("sort.Slice(%s, func(i, j int) bool {\nreturn %s[i] < %s[j]\n})\n", keys, keys, keys)
+		g.printf("for _, %s := range %s {\n", key, keys)
+		g.writeValue(owner, &fieldInfo{name: f.name}, t.key, key, false)
+		// copy the value so that methods with pointer receivers can be called on it
+		g.printf("%s := %s[%s]\n", value, expr, key)
+		g.writeValue(owner, f, t.elem, value, false)
+		g.printf("}\n}\n")
+		g.depth--
+	case kindArray:
+		index := g.name("i")
+		g.depth++
+		g.printf("for %s := range %s {\n", index, expr)
+		g.writeValue(owner, f, t.elem, expr+"["+index+"]", false)
+		g.printf("}\n")
+		g.depth--
+	default:
+		g.writeBasic(owner, f, t, expr, hasMaxSize)
+	}
+}
+
+// writeHook writes the code to call the MarshalPackbuf or MarshalBinary method of a value
+func (g *generator) writeHook(owner *typeInfo, f *fieldInfo, t *typeInfo, expr string) {
+	switch t.marshal.kind {
+	case hookPackbuf:
+		g.printf("if err := %s.MarshalPackbuf(e); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+	case hookBinary:
+		if t.marshal.ptr {
+			expr = "&" + expr
+		}
+		g.printf("if err := e.WriteBinary(%s); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+	}
+}
+
+func (g *generator) writeBasic(owner *typeInfo, f *fieldInfo, t *typeInfo, expr string, hasMaxSize bool) {
+	opts := &f.options
+	var call string
+	switch {
+	case opts.HasQuantize:
+		call = fmt.Sprintf("e.WriteQuantized(float64(%s), %s, %s, %s)", expr, formatFloat(opts.QuantizeMin), formatFloat(opts.QuantizeMax), formatFloat(opts.QuantizePrecision))
+	case opts.HasRange && isUnsigned(t):
+		call = fmt.Sprintf("e.WriteRangedUint(uint64(%s), %d, %d)", expr, opts.RangeMin, opts.RangeMax)
+	case opts.HasRange:
+		call = fmt.Sprintf("e.WriteRanged(int64(%s), %d, %d)", expr, opts.RangeMin, opts.RangeMax)
+	case t.basic == "string" && hasMaxSize:
+		g.printf("if err := e.WriteSize(len(%s), %d); err != nil {\n%s\n}\n", expr, opts.MaxSize, g.wrapErr(owner, f))
+		call = fmt.Sprintf("e.WriteStringData(%s)", g.convertTo("string", t, expr))
+	case t.basic == "string":
+		call = fmt.Sprintf("e.WriteString(%s)", g.convertTo("string", t, expr))
+	default:
+		call = fmt.Sprintf("e.Write%s(%s)", basicMethodNames[t.basic], g.convertTo(basicMethodType(t), t, expr))
+	}
+	g.printf("if err := %s; err != nil {\n%s\n}\n", call, g.wrapErr(owner, f))
+}
+
+// convertTo returns expr of type t converted to the basic type typeName if it isn't already that type
+func (g *generator) convertTo(typeName string, t *typeInfo, expr string) string {
+	if t.expr == typeName {
+		return expr
+	}
+	return typeName + "(" + expr + ")"
+}
+
+func (g *generator) readStruct(t *typeInfo, expr string) {
+	for i := range t.fields {
+		f := &t.fields[i]
+		if f.options.Skip {
+			continue
+		}
+		g.readValue(t, f, f.typ, expr+"."+f.name, true)
+	}
+}
+
+// readValue writes the code to read a value written by writeValue into expr
+func (g *generator) readValue(owner *typeInfo, f *fieldInfo, t *typeInfo, expr string, isField bool) {
+	opts := &f.options
+	hasMaxSize := isField && opts.HasMaxSize
+	if t.kind == kindPointer {
+		isSet := g.name("isSet")
+		g.printf("{\n%s, err := d.ReadBool()\n", isSet)
+		g.printf("if err != nil {\n%s\n}\n", g.wrapErr(owner, f))
+		g.printf("if !%s {\n%s = nil\n} else {\n", isSet, expr)
+		g.imports["unsafe"] = "unsafe"
+		g.printf("if err := d.Allocate(1, unsafe.Sizeof(*%s)); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+		// re-use what the pointer points to if it's already set
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", expr, expr, g.use(t.elem))
+		g.depth++
+		g.readValue(owner, f, t.elem, "(*"+expr+")", isField)
+		g.depth--
+		g.printf("}\n}\n")
+		return
+	}
+	if t.unmarshal.kind != hookNone {
+		g.readHook(owner, f, t, expr)
+		return
+	}
+	switch t.kind {
+	case kindStruct:
+		g.readStruct(t, expr)
+	case kindSlice, kindMap:
+		length := g.name("n")
+		g.printf("{\n")
+		if hasMaxSize {
+			g.printf("%s, err := d.ReadSize(%d)\n", length, opts.MaxSize)
+		} else {
+			g.printf("%s, err := d.ReadLength()\n", length)
+		}
+		g.printf("if err != nil {\n%s\n}\n", g.wrapErr(owner, f))
+		// Check the length before allocating so false packets can't make
+		// us allocate huge amounts of memory
+		g.imports["unsafe"] = "unsafe"
+		if t.kind == kindSlice {
+			g.printf("if err := d.Allocate(%s, unsafe.Sizeof(%s[0])); err != nil {\n%s\n}\n", length, expr, g.wrapErr(owner, f))
+			// re-use the slice, if it's nil and there's no data it stays as "nil"
+			g.printf("if cap(%s) >= %s {\n%s = %s[:%s]\n} else {\n", expr, length, expr, expr, length)
+			g.printf("%s = make(%s, %s)\n}\n", expr, g.use(t), length)
+			if t.elem.basic == "uint8" && t.elem.kind == kindBasic && !opts.HasRange {
+				g.printf("if err := d.ReadBytes(%s); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+			} else {
+				index := g.name("i")
+				g.depth++
+				g.printf("for %s := range %s {\n", index, expr)
+				g.readValue(owner, f, t.elem, expr+"["+index+"]", false)
+				g.printf("}\n")
+				g.depth--
+			}
+			g.printf("}\n")
+			return
+		}
+		index, key, value := g.name("i"), g.name("key"), g.name("value")
+		g.printf("if err := d.Allocate(%s, unsafe.Sizeof(*new(%s))+unsafe.Sizeof(*new(%s))); err != nil {\n%s\n}\n", length, g.use(t.key), g.use(t.elem), g.wrapErr(owner, f))
+		g.printf("if %s != nil {\n", expr)
+		// re-use the map
+		g.printf("for %s := range %s {\ndelete(%s, %s)\n}\n", key, expr, expr, key)
+		// Ignore setting if no data, this ensures the data stays as "nil"
+		g.printf("} else if %s > 0 {\n", length)
+		g.printf("%s = make(%s, %s)\n}\n", expr, g.use(t), length)
+		g.depth++
+		g.printf("for %s := 0; %s < %s; %s++ {\n", index, index, length, index)
+		g.printf("var %s %s\n", key, g.use(t.key))
+		g.readValue(owner, &fieldInfo{name: f.name}, t.key, key, false)
+		g.printf("if _, ok := %s[%s]; ok {\n%s\n}\n", expr, key, g.wrap(owner, f, "packbuf.ErrDuplicateMapKey"))
+		g.printf("var %s %s\n", value, g.use(t.elem))
+		g.readValue(owner, f, t.elem, value, false)
+		g.printf("%s[%s] = %s\n", expr, key, value)
+		g.printf("}\n")
+		g.depth--
+		g.printf("}\n")
+	case kindArray:
+		index := g.name("i")
+		g.depth++
+		g.printf("for %s := range %s {\n", index, expr)
+		g.readValue(owner, f, t.elem, expr+"["+index+"]", false)
+		g.printf("}\n")
+		g.depth--
+	default:
+		g.readBasic(owner, f, t, expr, hasMaxSize)
+	}
+}
+
+// readHook writes the code to call the UnmarshalPackbuf or UnmarshalBinary method of a value
+func (g *generator) readHook(owner *typeInfo, f *fieldInfo, t *typeInfo, expr string) {
+	switch t.unmarshal.kind {
+	case hookPackbuf:
+		g.printf("if err := %s.UnmarshalPackbuf(d); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+	case hookBinary:
+		if t.unmarshal.ptr {
+			expr = "&" + expr
+		}
+		g.printf("if err := d.ReadBinary(%s); err != nil {\n%s\n}\n", expr, g.wrapErr(owner, f))
+	}
+}
+
+func (g *generator) readBasic(owner *typeInfo, f *fieldInfo, t *typeInfo, expr string, hasMaxSize bool) {
+	opts := &f.options
+	switch {
+	case opts.HasQuantize:
+		g.printf("{\nvalue, err := d.ReadQuantized(%s, %s, %s)\n", formatFloat(opts.QuantizeMin), formatFloat(opts.QuantizeMax), formatFloat(opts.QuantizePrecision))
+		g.printf("if err != nil {\n%s\n}\n", g.wrapErr(owner, f))
+		g.printf("%s = %s\n}\n", expr, g.convert(t, "value", "float64"))
+	case opts.HasRange:
+		g.printf("{\nvalue, err := d.ReadRanged(%d, %d)\n", opts.RangeMin, opts.RangeMax)
+		g.printf("if err != nil {\n%s\n}\n", g.wrapErr(owner, f))
+		g.printf("%s = %s\n}\n", expr, g.convert(t, "value", "int64"))
+	case t.basic == "string":
+		g.printf("{\n")
+		if hasMaxSize {
+			g.printf("size, err := d.ReadSize(%d)\n", opts.MaxSize)
+		} else {
+			g.printf("size, err := d.ReadUint16()\n")
+		}
+		g.printf("if err != nil {\n%s\n}\n", g.wrapErr(owner, f))
+		g.printf("value, err := d.ReadStringData(int(size))\n")
+		g.printf("if err != nil {\n%s\n}\n", g.wrapErr(owner, f))
+		g.printf("%s = %s\n}\n", expr, g.convert(t, "value", "string"))
+	default:
+		g.printf("{\nvalue, err := d.Read%s()\n", basicMethodNames[t.basic])
+		g.printf("if err != nil {\n%s\n}\n", g.wrapErr(owner, f))
+		g.printf("%s = %s\n}\n", expr, g.convert(t, "value", basicMethodType(t)))
+	}
+}
@@ -0,0 +1,182 @@
+// packdump prints the packets in a capture file recorded by the client or server, see
+// the capture package.
+//
+//	PACKET_CAPTURE=server.cap ./server
+//	go run -tags headless ./cmd/packdump -type ClientPlayerPacket -conn 1 server.cap
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/capture"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+var (
+	flagJSON       = flag.Bool("json", false, "print each packet as a line of JSON")
+	flagType       = flag.String("type", "", "comma-separated list of packet type names or IDs to print, ie. AckPacket,3")
+	flagConn       = flag.Int("conn", -1, "only print packets from this connection ID")
+	flagDirection  = flag.String("direction", "", "only print packets that were \"sent\" or \"received\"")
+	flagProtocolID = flag.Uint("protocol", uint(netconst.ProtocolID), "protocol ID the capture was recorded with")
+)
+
+// filter decides which packets are printed
+type filter struct {
+	types     map[string]bool
+	conn      int
+	direction string
+}
+
+func (f *filter) matchRecord(record capture.Record) bool {
+	if f.conn >= 0 && int(record.ConnID) != f.conn {
+		return false
+	}
+	if f.direction != "" && record.Direction.String() != f.direction {
+		return false
+	}
+	return true
+}
+
+func (f *filter) matchPacket(packet packs.Packet) bool {
+	if len(f.types) == 0 {
+		return true
+	}
+	return f.types[packetName(packet)] ||
+		f.types[strconv.Itoa(int(packet.ID()))]
+}
+
+func packetName(packet packs.Packet) string {
+	return reflect.TypeOf(packet).Elem().Name()
+}
+
+// jsonPacket is a packet printed with -json
+type jsonPacket struct {
+	Time       time.Time      `json:"time"`
+	Direction  string         `json:"direction"`
+	ConnID     uint16         `json:"conn"`
+	SequenceID uint16         `json:"seq"`
+	ID         packs.PacketID `json:"id,omitempty"`
+	Type       string         `json:"type,omitempty"`
+	Packet     packs.Packet   `json:"packet,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("packdump: ")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("expected path to a capture file, ie. packdump server.cap")
+	}
+
+	f := &filter{
+		conn:      *flagConn,
+		direction: *flagDirection,
+	}
+	if f.direction != "" && f.direction != capture.Sent.String() && f.direction != capture.Received.String() {
+		log.Fatalf("invalid direction %q, expected \"sent\" or \"received\"", f.direction)
+	}
+	if *flagType != "" {
+		f.types = make(map[string]bool)
+		for _, name := range strings.Split(*flagType, ",") {
+			f.types[strings.TrimSpace(name)] = true
+		}
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if err := dump(out, file, f, packs.NewChecksum(uint32(*flagProtocolID)), *flagJSON); err != nil {
+		out.Flush()
+		log.Fatal(err)
+	}
+}
+
+func dump(out io.Writer, r io.Reader, f *filter, checksum packs.Checksum, isJSON bool) error {
+	captureReader, err := capture.NewReader(r)
+	if err != nil {
+		return err
+	}
+	compressor := packs.NewCompressor()
+	enc := json.NewEncoder(out)
+	for {
+		record, err := captureReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !f.matchRecord(record) {
+			continue
+		}
+		printError := func(err error) error {
+			if isJSON {
+				return enc.Encode(jsonPacket{
+					Time:      record.Time,
+					Direction: record.Direction.String(),
+					ConnID:    record.ConnID,
+					Error:     err.Error(),
+				})
+			}
+			_, err = fmt.Fprintf(out, "%s %-8s conn=%d error: %v\n", record.Time.Format(time.StampMicro), record.Direction, record.ConnID, err)
+			return err
+		}
+		payload, err := checksum.Open(record.Datagram)
+		if err == nil {
+			payload, err = compressor.Decompress(payload)
+		}
+		if err != nil {
+			if err := printError(err); err != nil {
+				return err
+			}
+			continue
+		}
+		packetReader := bytes.NewReader(payload)
+		for packetReader.Len() > 0 {
+			seqID, packet, err := packs.Read(packetReader)
+			if err != nil {
+				// the rest of the datagram can't be read if we failed to read a packet
+				if err := printError(err); err != nil {
+					return err
+				}
+				break
+			}
+			if !f.matchPacket(packet) {
+				continue
+			}
+			if isJSON {
+				err = enc.Encode(jsonPacket{
+					Time:       record.Time,
+					Direction:  record.Direction.String(),
+					ConnID:     record.ConnID,
+					SequenceID: seqID,
+					ID:         packet.ID(),
+					Type:       packetName(packet),
+					Packet:     packet,
+				})
+			} else {
+				_, err = fmt.Fprintf(out, "%s %-8s conn=%d seq=%d %s %+v\n", record.Time.Format(time.StampMicro), record.Direction, record.ConnID, seqID, packetName(packet), packet)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
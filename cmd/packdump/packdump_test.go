@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/capture"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+// newTestCapture records a datagram from a client, an acknowledgement from the server and
+// a datagram that fails its checksum
+func newTestCapture(t *testing.T, checksum packs.Checksum, start time.Time) []byte {
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressor := packs.NewCompressor()
+	datagram := func(packets ...packs.Packet) []byte {
+		var tracker rtt.RoundTripTracking
+		data := compressor.AppendHeader(checksum.AppendHeader(nil))
+		for _, packet := range packets {
+			var err error
+			data, _, err = packs.AppendPacket(data, &tracker, packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		checksum.Seal(data)
+		return data
+	}
+	records := []struct {
+		dir      capture.Direction
+		connID   uint16
+		datagram []byte
+	}{
+		{
+			dir:    capture.Received,
+			connID: 1,
+			datagram: datagram(&packs.ClientPlayerPacket{
+				InputBuffer: []packs.ClientFrameInput{{Frame: 7, PlayerInput: ent.PlayerInput{IsHoldingRight: true}}},
+			}),
+		},
+		{
+			dir:      capture.Sent,
+			connID:   1,
+			datagram: datagram(&packs.AckPacket{SequenceIDList: []uint16{0}}),
+		},
+		{
+			dir:      capture.Received,
+			connID:   2,
+			datagram: []byte{1, 2, 3, 4, 5, 6},
+		},
+	}
+	for i, record := range records {
+		if err := w.Record(start.Add(time.Duration(i)*time.Millisecond), record.dir, record.connID, record.datagram); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDump(t *testing.T) {
+	checksum := packs.NewChecksum(1)
+	start := time.Unix(1000, 0)
+	data := newTestCapture(t, checksum, start)
+	stamp := func(ms int) string {
+		return start.Add(time.Duration(ms) * time.Millisecond).Format(time.StampMicro)
+	}
+
+	var out bytes.Buffer
+	if err := dump(&out, bytes.NewReader(data), &filter{conn: -1}, checksum, false); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	expected := stamp(0) + " received conn=1 seq=0 ClientPlayerPacket &{InputBuffer:[{Frame:7 PlayerInput:{IsHoldingLeft:false IsHoldingRight:true IsHoldingJump:false}}]}\n" +
+		stamp(1) + " sent     conn=1 seq=0 AckPacket &{SequenceIDList:[0]}\n" +
+		stamp(2) + " received conn=2 error: " + checksumError(t, checksum) + "\n"
+	if out.String() != expected {
+		t.Errorf("expected output:\n%s\nbut got:\n%s", expected, out.String())
+	}
+
+	// only print the acknowledgement
+	out.Reset()
+	f := &filter{conn: 1, direction: "sent", types: map[string]bool{"AckPacket": true}}
+	if err := dump(&out, bytes.NewReader(data), f, checksum, true); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 packet to be printed but got:\n%s", out.String())
+	}
+	var packet struct {
+		Direction  string `json:"direction"`
+		ConnID     uint16 `json:"conn"`
+		SequenceID uint16 `json:"seq"`
+		Type       string `json:"type"`
+		Packet     packs.AckPacket
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &packet); err != nil {
+		t.Fatalf("failed to read JSON: %v", err)
+	}
+	if packet.Direction != "sent" || packet.ConnID != 1 || packet.SequenceID != 0 ||
+		packet.Type != "AckPacket" || len(packet.Packet.SequenceIDList) != 1 {
+		t.Errorf("unexpected packet: %s", lines[0])
+	}
+}
+
+// checksumError is the error a datagram that fails its checksum is printed with
+func checksumError(t *testing.T, checksum packs.Checksum) string {
+	_, err := checksum.Open([]byte{1, 2, 3, 4, 5, 6})
+	if err == nil {
+		t.Fatal("expected datagram to fail its checksum")
+	}
+	return err.Error()
+}
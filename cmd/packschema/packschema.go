@@ -0,0 +1,51 @@
+// packschema writes a JSON schema of every packet registered with the packs package and
+// how packbuf encodes it, as well as a TypeScript module that can read and write those packets.
+//
+// This lets tooling outside of Go inspect and build traffic, ie.
+//
+//	go run -tags headless ./cmd/packschema -json packets.json -ts packets.ts
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+var (
+	flagJSON       = flag.String("json", "packets.json", "file name to write the JSON schema to, empty to skip")
+	flagTypeScript = flag.String("ts", "packets.ts", "file name to write the TypeScript encoder and decoder to, empty to skip")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("packschema: ")
+	flag.Parse()
+
+	schema, err := packs.NewSchema(netconst.ProtocolID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *flagJSON != "" {
+		data, err := json.MarshalIndent(schema, "", "\t")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*flagJSON, append(data, '\n'), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *flagTypeScript != "" {
+		src, err := generateTypeScript(schema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*flagTypeScript, src, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+var flagUpdate = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares output to the golden file, or updates the golden file if -update is set
+func checkGolden(t *testing.T, name string, output []byte) {
+	path := filepath.Join("testdata", name)
+	if *flagUpdate {
+		if err := os.WriteFile(path, output, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Errorf("output doesn't match %s, if the packets changed run: go test -tags headless ./cmd/packschema -update", path)
+	}
+}
+
+// TestGolden tests the JSON schema and TypeScript generated for the registered packets
+func TestGolden(t *testing.T) {
+	schema, err := packs.NewSchema(netconst.ProtocolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(schema, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "packets.json.golden", append(data, '\n'))
+	src, err := generateTypeScript(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "packets.ts.golden", src)
+}
+
+// testDatagrams returns datagrams with every packet type, written by the Go encoder
+func testDatagrams(t *testing.T) [][]byte {
+	worldState := &packs.ServerWorldStatePacket{
+		MyNetID:                 1,
+		LastSimulatedInputFrame: 65535,
+		ServerTick:              300,
+	}
+	worldState.DeltaEncode(true, 7, []packs.PlayerState{
+		{NetID: 1, X: 180, Y: 528},
+		{NetID: 2, X: -20.5, Y: 528, Hspeed: 4},
+	}, []packs.PlayerState{
+		{NetID: 1, X: 180.0625, Y: 512.5, Vspeed: -11.55, DirLeft: true},
+		{NetID: 2, X: -20.5, Y: 528, Hspeed: 3.2},
+		{NetID: 3, X: 5000, Y: 100, Hspeed: -1},
+	})
+	datagrams := [][]packs.Packet{
+		{
+			&packs.AckPacket{SequenceIDList: []uint16{0, 1, 65535}},
+			&packs.ClientPlayerPacket{InputBuffer: []packs.ClientFrameInput{
+				{Frame: 65535, PlayerInput: ent.PlayerInput{IsHoldingLeft: true}},
+				{Frame: 0, PlayerInput: ent.PlayerInput{IsHoldingRight: true, IsHoldingJump: true}},
+			}},
+			&packs.ClockSyncRequestPacket{},
+		},
+		{
+			&packs.AckPacket{},
+			worldState,
+			&packs.ClockSyncResponsePacket{RequestSequenceID: 3, ServerTick: 301, HeldMicroseconds: 3500},
+		},
+	}
+	checksum := packs.NewChecksum(netconst.ProtocolID)
+	var result [][]byte
+	for _, packets := range datagrams {
+		var tracker rtt.RoundTripTracking
+		data := packs.NewCompressor().AppendHeader(checksum.AppendHeader(nil))
+		for _, packet := range packets {
+			var err error
+			data, _, err = packs.AppendPacket(data, &tracker, packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		checksum.Seal(data)
+		result = append(result, data)
+	}
+	return result
+}
+
+// TestTypeScriptRoundTrip tests that the generated TypeScript reads datagrams written by Go
+// and writes them back out byte-for-byte, this is skipped if tsc and node aren't installed.
+func TestTypeScriptRoundTrip(t *testing.T) {
+	tsc, err := exec.LookPath("tsc")
+	if err != nil {
+		t.Skip("tsc isn't installed, the TypeScript is only checked against the golden file")
+	}
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node isn't installed, the TypeScript is only checked against the golden file")
+	}
+	schema, err := packs.NewSchema(netconst.ProtocolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generateTypeScript(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip, err := os.ReadFile(filepath.Join("testdata", "roundtrip.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "packets.ts"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "roundtrip.ts"), roundTrip, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(tsc, "--strict", "--target", "es2020", "--module", "commonjs", "packets.ts", "roundtrip.ts")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile TypeScript: %v\n%s", err, output)
+	}
+
+	datagrams := testDatagrams(t)
+	args := []string{"roundtrip.js"}
+	for _, datagram := range datagrams {
+		args = append(args, hex.EncodeToString(datagram))
+	}
+	cmd = exec.Command(node, args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("failed to run TypeScript: %v\n%s", err, output)
+	}
+	lines := strings.Fields(string(output))
+	if len(lines) != len(datagrams) {
+		t.Fatalf("expected %d datagrams from TypeScript but got:\n%s", len(datagrams), output)
+	}
+	for i, datagram := range datagrams {
+		if expected := hex.EncodeToString(datagram); lines[i] != expected {
+			t.Errorf("datagram %d: TypeScript wrote:\n%s\nbut Go wrote:\n%s", i, lines[i], expected)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+// tsRuntime is written at the start of the generated TypeScript, it reads and writes
+// bit-packed values the same way as packbuf.BitWriter and packbuf.BitReader
+const tsRuntime = `const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder();
+
+// BitWriter packs values starting from the least significant bit of each byte
+export class BitWriter {
+  private out: number[] = [];
+  private scratch = 0;
+  private scratchBits = 0;
+
+  // writeBits writes the lowest bits of value, value must be a positive integer and bits at most 32
+  writeBits(value: number, bits: number): void {
+    for (let i = 0; i < bits; ) {
+      const n = Math.min(8 - this.scratchBits, bits - i);
+      const chunk = Math.floor(value / 2 ** i) & ((1 << n) - 1);
+      this.scratch |= chunk << this.scratchBits;
+      this.scratchBits += n;
+      i += n;
+      if (this.scratchBits === 8) {
+        this.out.push(this.scratch);
+        this.scratch = 0;
+        this.scratchBits = 0;
+      }
+    }
+  }
+
+  writeBigBits(value: bigint, bits: number): void {
+    this.writeBits(Number(value & 0xffffffffn), Math.min(bits, 32));
+    if (bits > 32) {
+      this.writeBits(Number((value >> 32n) & 0xffffffffn), bits - 32);
+    }
+  }
+
+  writeBool(v: boolean): void {
+    this.writeBits(v ? 1 : 0, 1);
+  }
+
+  // writeInt writes a signed or unsigned integer of the given bit size
+  writeInt(v: number, bits: number): void {
+    if (!Number.isInteger(v)) {
+      throw new Error("cannot write non-integer: " + v);
+    }
+    this.writeBits(bits === 32 ? v >>> 0 : v & ((1 << bits) - 1), bits);
+  }
+
+  writeFloat32(v: number): void {
+    const view = new DataView(new ArrayBuffer(4));
+    view.setFloat32(0, v, true);
+    this.writeBits(view.getUint32(0, true), 32);
+  }
+
+  writeFloat64(v: number): void {
+    const view = new DataView(new ArrayBuffer(8));
+    view.setFloat64(0, v, true);
+    this.writeBits(view.getUint32(0, true), 32);
+    this.writeBits(view.getUint32(4, true), 32);
+  }
+
+  writeBytes(v: Uint8Array): void {
+    for (const b of v) {
+      this.writeBits(b, 8);
+    }
+  }
+
+  // writeLength writes the length of a slice or map, maxSize and bits are set if it has a maxsize tag
+  writeLength(n: number, maxSize?: number, bits?: number): void {
+    if (maxSize === undefined || bits === undefined) {
+      if (n > 0x7fffffff) {
+        throw new Error("length of " + n + " is too large");
+      }
+      this.writeBits(n, 32);
+      return;
+    }
+    if (n > maxSize) {
+      throw new Error("size of " + n + " exceeds maxsize of " + maxSize);
+    }
+    this.writeBits(n, bits);
+  }
+
+  writeString(v: string, maxSize?: number, bits?: number): void {
+    const data = textEncoder.encode(v);
+    if (maxSize === undefined || bits === undefined) {
+      if (data.length > 65535) {
+        throw new Error("cannot write string larger than 65535");
+      }
+      this.writeBits(data.length, 16);
+    } else {
+      this.writeLength(data.length, maxSize, bits);
+    }
+    this.writeBytes(data);
+  }
+
+  writeBinary(v: Uint8Array): void {
+    this.writeLength(v.length);
+    this.writeBytes(v);
+  }
+
+  writeRanged(v: number, min: number, max: number, bits: number): void {
+    if (!Number.isInteger(v) || v < min || v > max) {
+      throw new Error(v + " is outside of " + min + " to " + max);
+    }
+    this.writeBits(v - min, bits);
+  }
+
+  writeBigRanged(v: bigint, min: bigint, max: bigint, bits: number): void {
+    if (v < min || v > max) {
+      throw new Error(v + " is outside of " + min + " to " + max);
+    }
+    this.writeBigBits(v - min, bits);
+  }
+
+  // writeQuantized writes a float as a fixed point number, values outside of min/max are an error
+  writeQuantized(v: number, min: number, max: number, precision: number, bits: number): void {
+    if (Number.isNaN(v)) {
+      throw new Error("cannot quantize NaN");
+    }
+    if (v < min || v > max) {
+      throw new Error(v + " is outside of " + min + " to " + max);
+    }
+    const steps = Math.round((max - min) / precision);
+    this.writeBits(Math.min(Math.round((v - min) / precision), steps), bits);
+  }
+
+  // align pads any remaining bits to the next byte boundary with zeroes
+  align(): void {
+    if (this.scratchBits > 0) {
+      this.out.push(this.scratch);
+      this.scratch = 0;
+      this.scratchBits = 0;
+    }
+  }
+
+  // bytes returns everything written, padded to the next byte boundary
+  bytes(): Uint8Array {
+    this.align();
+    return Uint8Array.from(this.out);
+  }
+}
+
+// BitReader unpacks values written by a BitWriter
+export class BitReader {
+  private data: Uint8Array;
+  private offset = 0;
+  private scratch = 0;
+  private scratchBits = 0;
+
+  constructor(data: Uint8Array) {
+    this.data = data;
+  }
+
+  readBits(bits: number): number {
+    let value = 0;
+    for (let i = 0; i < bits; ) {
+      if (this.scratchBits === 0) {
+        if (this.offset >= this.data.length) {
+          throw new Error("unexpected end of data");
+        }
+        this.scratch = this.data[this.offset++];
+        this.scratchBits = 8;
+      }
+      const n = Math.min(this.scratchBits, bits - i);
+      value += (this.scratch & ((1 << n) - 1)) * 2 ** i;
+      this.scratch >>= n;
+      this.scratchBits -= n;
+      i += n;
+    }
+    return value;
+  }
+
+  readBigBits(bits: number): bigint {
+    let value = BigInt(this.readBits(Math.min(bits, 32)));
+    if (bits > 32) {
+      value |= BigInt(this.readBits(bits - 32)) << 32n;
+    }
+    return value;
+  }
+
+  readBool(): boolean {
+    return this.readBits(1) !== 0;
+  }
+
+  // readInt reads a signed integer of the given bit size
+  readInt(bits: number): number {
+    const v = this.readBits(bits);
+    return v >= 2 ** (bits - 1) ? v - 2 ** bits : v;
+  }
+
+  readFloat32(): number {
+    const view = new DataView(new ArrayBuffer(4));
+    view.setUint32(0, this.readBits(32), true);
+    return view.getFloat32(0, true);
+  }
+
+  readFloat64(): number {
+    const view = new DataView(new ArrayBuffer(8));
+    view.setUint32(0, this.readBits(32), true);
+    view.setUint32(4, this.readBits(32), true);
+    return view.getFloat64(0, true);
+  }
+
+  readBytes(n: number): Uint8Array {
+    if (n > (this.data.length - this.offset) + this.scratchBits / 8) {
+      throw new Error("unexpected end of data");
+    }
+    const v = new Uint8Array(n);
+    for (let i = 0; i < n; i++) {
+      v[i] = this.readBits(8);
+    }
+    return v;
+  }
+
+  // readLength reads the length of a slice or map, maxSize and bits are set if it has a maxsize tag
+  readLength(maxSize?: number, bits?: number): number {
+    if (maxSize === undefined || bits === undefined) {
+      const n = this.readInt(32);
+      if (n < 0) {
+        throw new Error("negative length");
+      }
+      return n;
+    }
+    const n = this.readBits(bits);
+    if (n > maxSize) {
+      throw new Error("size of " + n + " exceeds maxsize of " + maxSize);
+    }
+    return n;
+  }
+
+  readString(maxSize?: number, bits?: number): string {
+    const n = maxSize === undefined || bits === undefined ? this.readBits(16) : this.readLength(maxSize, bits);
+    return textDecoder.decode(this.readBytes(n));
+  }
+
+  readBinary(): Uint8Array {
+    return this.readBytes(this.readLength());
+  }
+
+  readRanged(min: number, max: number, bits: number): number {
+    const v = min + this.readBits(bits);
+    if (v > max) {
+      throw new Error(v + " is outside of " + min + " to " + max);
+    }
+    return v;
+  }
+
+  readBigRanged(min: bigint, max: bigint, bits: number): bigint {
+    const v = min + this.readBigBits(bits);
+    if (v > max) {
+      throw new Error(v + " is outside of " + min + " to " + max);
+    }
+    return v;
+  }
+
+  readQuantized(min: number, max: number, precision: number, bits: number): number {
+    const q = this.readBits(bits);
+    if (q > Math.round((max - min) / precision)) {
+      throw new Error("quantized value " + q + " is out of range");
+    }
+    return min + q * precision;
+  }
+
+  // align discards any bits left over from the last byte read
+  align(): void {
+    this.scratch = 0;
+    this.scratchBits = 0;
+  }
+
+  // done returns true if every byte has been read
+  done(): boolean {
+    return this.offset >= this.data.length;
+  }
+}
+
+// compareKeys sorts map keys from lowest to highest, the same as packbuf
+function compareKeys<T extends number | bigint | string>(a: T, b: T): number {
+  return a < b ? -1 : a > b ? 1 : 0;
+}
+
+const crcTable = new Uint32Array(256);
+for (let i = 0; i < 256; i++) {
+  let c = i;
+  for (let j = 0; j < 8; j++) {
+    c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+  }
+  crcTable[i] = c >>> 0;
+}
+
+// crc32Update continues an IEEE CRC32 with more data, the same as Go's crc32.Update
+function crc32Update(crc: number, data: Uint8Array): number {
+  crc = ~crc >>> 0;
+  for (const b of data) {
+    crc = crcTable[(crc ^ b) & 0xff] ^ (crc >>> 8);
+  }
+  return ~crc >>> 0;
+}
+
+function checksum(payload: Uint8Array): number {
+  const salt = new Uint8Array(4);
+  new DataView(salt.buffer).setUint32(0, protocolId, true);
+  return crc32Update(crc32Update(0, salt), payload);
+}
+
+// checksumSize and compressionHeaderSize are the headers at the start of every datagram
+const checksumSize = 4;
+const compressionHeaderSize = 1;
+
+// sealDatagram adds the checksum and compression headers to packets written by writePacket,
+// the packets are sent uncompressed
+export function sealDatagram(packets: Uint8Array): Uint8Array {
+  const datagram = new Uint8Array(checksumSize + compressionHeaderSize + packets.length);
+  datagram.set(packets, checksumSize + compressionHeaderSize);
+  const sum = checksum(datagram.subarray(checksumSize));
+  new DataView(datagram.buffer).setUint32(0, sum, true);
+  return datagram;
+}
+
+// openDatagram checks the checksum of a datagram and returns the packets in it, an error
+// is thrown if the packets are compressed as decompressing isn't supported
+export function openDatagram(datagram: Uint8Array): Uint8Array {
+  if (datagram.length < checksumSize + compressionHeaderSize) {
+    throw new Error("datagram is too small");
+  }
+  const view = new DataView(datagram.buffer, datagram.byteOffset, datagram.byteLength);
+  if (view.getUint32(0, true) !== checksum(datagram.subarray(checksumSize))) {
+    throw new Error("checksum mismatch");
+  }
+  if (datagram[checksumSize] & 1) {
+    throw new Error("compressed datagrams aren't supported");
+  }
+  return datagram.subarray(checksumSize + compressionHeaderSize);
+}
+
+`
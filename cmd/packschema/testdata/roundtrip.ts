@@ -0,0 +1,29 @@
+// roundtrip reads each datagram given as a hex argument with the generated codec, writes
+// its packets back out and prints the new datagram as hex. See TestTypeScriptRoundTrip.
+import { BitWriter, openDatagram, readPackets, sealDatagram, writePacket } from "./packets";
+
+declare const process: { argv: string[] };
+
+function fromHex(s: string): Uint8Array {
+  const data = new Uint8Array(s.length / 2);
+  for (let i = 0; i < data.length; i++) {
+    data[i] = parseInt(s.substr(i * 2, 2), 16);
+  }
+  return data;
+}
+
+function toHex(data: Uint8Array): string {
+  let s = "";
+  for (const b of data) {
+    s += b.toString(16).padStart(2, "0");
+  }
+  return s;
+}
+
+for (const arg of process.argv.slice(2)) {
+  const w = new BitWriter();
+  for (const p of readPackets(openDatagram(fromHex(arg)))) {
+    writePacket(w, p.sequenceId, p);
+  }
+  console.log(toHex(sealDatagram(w.bytes())));
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+// tsGenerator generates a TypeScript interface, read function and write function for
+// every struct in the schema, as well as functions to read and write packets.
+type tsGenerator struct {
+	schema *packs.Schema
+	buf    bytes.Buffer
+	indent int
+	// names maps the Go name of each struct to its TypeScript name
+	names map[string]string
+}
+
+func generateTypeScript(schema *packs.Schema) ([]byte, error) {
+	g := &tsGenerator{
+		schema: schema,
+		names:  make(map[string]string),
+	}
+	if err := g.nameStructs(); err != nil {
+		return nil, err
+	}
+	g.printf("// Code generated by packschema. DO NOT EDIT.\n\n")
+	g.printf("export const protocolId = 0x%08x;\n\n", schema.ProtocolID)
+	g.buf.WriteString(tsRuntime)
+	for _, structSchema := range schema.Structs {
+		if err := g.writeStruct(structSchema); err != nil {
+			return nil, err
+		}
+	}
+	g.writePackets()
+	return g.buf.Bytes(), nil
+}
+
+// nameStructs uses the Go type name without its package, unless two structs have the
+// same name in which case the package name is used as a prefix
+func (g *tsGenerator) nameStructs() error {
+	count := make(map[string]int)
+	for _, structSchema := range g.schema.Structs {
+		count[shortName(structSchema.Name)]++
+	}
+	used := make(map[string]bool)
+	for _, structSchema := range g.schema.Structs {
+		name := shortName(structSchema.Name)
+		if count[name] > 1 {
+			name = ""
+			for _, part := range strings.Split(structSchema.Name, ".") {
+				name += strings.ToUpper(part[:1]) + part[1:]
+			}
+		}
+		if used[name] {
+			return errors.New("cannot name " + structSchema.Name + " in TypeScript, " + name + " is already used")
+		}
+		used[name] = true
+		g.names[structSchema.Name] = name
+	}
+	return nil
+}
+
+func shortName(name string) string {
+	if i := strings.LastIndex(name, "."); i != -1 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// printf writes a line of code at the current indentation
+func (g *tsGenerator) printf(format string, args ...interface{}) {
+	if format != "\n" {
+		g.buf.WriteString(strings.Repeat("  ", g.indent))
+	}
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *tsGenerator) writeStruct(structSchema *packbuf.StructSchema) error {
+	name := g.names[structSchema.Name]
+	g.printf("export interface %s {\n", name)
+	g.indent++
+	for _, field := range structSchema.Fields {
+		typ, err := g.tsType(field.Type)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", structSchema.Name, field.Name, err)
+		}
+		g.printf("%s: %s;\n", field.Name, typ)
+	}
+	g.indent--
+	g.printf("}\n\n")
+
+	g.printf("export function write%s(w: BitWriter, v: %s): void {\n", name, name)
+	g.indent++
+	for _, field := range structSchema.Fields {
+		g.writeValue(field.Type, "v."+field.Name, 0)
+	}
+	g.indent--
+	g.printf("}\n\n")
+
+	g.printf("export function read%s(r: BitReader): %s {\n", name, name)
+	g.indent++
+	g.printf("const v = {} as %s;\n", name)
+	for _, field := range structSchema.Fields {
+		if err := g.readValue(field.Type, "v."+field.Name, 0); err != nil {
+			return fmt.Errorf("%s.%s: %w", structSchema.Name, field.Name, err)
+		}
+	}
+	g.printf("return v;\n")
+	g.indent--
+	g.printf("}\n\n")
+	return nil
+}
+
+// tsType returns the TypeScript type of values described by t
+func (g *tsGenerator) tsType(t *packbuf.TypeSchema) (string, error) {
+	switch t.Kind {
+	case packbuf.KindBool:
+		return "boolean", nil
+	case packbuf.KindInt64, packbuf.KindUint64:
+		return "bigint", nil
+	case packbuf.KindString:
+		return "string", nil
+	case packbuf.KindBinary:
+		return "Uint8Array", nil
+	case packbuf.KindStruct:
+		return g.names[t.Struct], nil
+	case packbuf.KindSlice, packbuf.KindArray:
+		elem, err := g.tsType(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(elem, " ") {
+			return "Array<" + elem + ">", nil
+		}
+		return elem + "[]", nil
+	case packbuf.KindMap:
+		key, err := g.tsType(t.Key)
+		if err != nil {
+			return "", err
+		}
+		elem, err := g.tsType(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		return "Map<" + key + ", " + elem + ">", nil
+	case packbuf.KindPointer:
+		elem, err := g.tsType(t.Elem)
+		if err != nil {
+			return "", err
+		}
+		return elem + " | null", nil
+	case packbuf.KindCustom:
+		return "", errors.New("cannot generate TypeScript for " + t.GoType + " as it has its own MarshalPackbuf method")
+	}
+	if _, ok := bitSize(t.Kind); !ok {
+		return "", errors.New("unsupported kind: " + t.Kind)
+	}
+	return "number", nil
+}
+
+// bitSize is the size of the kinds that are written as a single number
+func bitSize(kind string) (int, bool) {
+	switch kind {
+	case packbuf.KindInt8, packbuf.KindUint8:
+		return 8, true
+	case packbuf.KindInt16, packbuf.KindUint16:
+		return 16, true
+	case packbuf.KindInt32, packbuf.KindUint32, packbuf.KindFloat32:
+		return 32, true
+	case packbuf.KindInt64, packbuf.KindUint64, packbuf.KindFloat64:
+		return 64, true
+	}
+	return 0, false
+}
+
+// lengthArgs are the arguments passed to writeLength or readLength
+func lengthArgs(t *packbuf.TypeSchema) string {
+	if t.MaxSize == nil {
+		return ""
+	}
+	return ", " + strconv.Itoa(t.MaxSize.Max) + ", " + strconv.Itoa(t.MaxSize.Bits)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeValue writes the TypeScript that writes expr
+func (g *tsGenerator) writeValue(t *packbuf.TypeSchema, expr string, depth int) {
+	suffix := strconv.Itoa(depth)
+	switch t.Kind {
+	case packbuf.KindBool:
+		g.printf("w.writeBool(%s);\n", expr)
+	case packbuf.KindString:
+		g.printf("w.writeString(%s%s);\n", expr, lengthArgs(t))
+	case packbuf.KindBinary:
+		g.printf("w.writeBinary(%s);\n", expr)
+	case packbuf.KindStruct:
+		g.printf("write%s(w, %s);\n", g.names[t.Struct], expr)
+	case packbuf.KindPointer:
+		g.printf("{\n")
+		g.indent++
+		g.printf("const p%s = %s;\n", suffix, expr)
+		g.printf("w.writeBool(p%s !== null);\n", suffix)
+		g.printf("if (p%s !== null) {\n", suffix)
+		g.indent++
+		g.writeValue(t.Elem, "p"+suffix, depth+1)
+		g.indent--
+		g.printf("}\n")
+		g.indent--
+		g.printf("}\n")
+	case packbuf.KindSlice:
+		g.printf("w.writeLength(%s.length%s);\n", expr, lengthArgs(t))
+		g.printf("for (const e%s of %s) {\n", suffix, expr)
+		g.indent++
+		g.writeValue(t.Elem, "e"+suffix, depth+1)
+		g.indent--
+		g.printf("}\n")
+	case packbuf.KindArray:
+		g.printf("for (let i%s = 0; i%s < %d; i%s++) {\n", suffix, suffix, t.Len, suffix)
+		g.indent++
+		g.writeValue(t.Elem, expr+"[i"+suffix+"]", depth+1)
+		g.indent--
+		g.printf("}\n")
+	case packbuf.KindMap:
+		g.printf("w.writeLength(%s.size%s);\n", expr, lengthArgs(t))
+		// maps are written in order of their keys
+		g.printf("for (const k%s of Array.from(%s.keys()).sort(compareKeys)) {\n", suffix, expr)
+		g.indent++
+		g.writeValue(t.Key, "k"+suffix, depth+1)
+		g.writeValue(t.Elem, expr+".get(k"+suffix+")!", depth+1)
+		g.indent--
+		g.printf("}\n")
+	default:
+		g.writeNumber(t, expr)
+	}
+}
+
+func (g *tsGenerator) writeNumber(t *packbuf.TypeSchema, expr string) {
+	if q := t.Quantize; q != nil {
+		g.printf("w.writeQuantized(%s, %s, %s, %s, %d);\n", expr, formatFloat(q.Min), formatFloat(q.Max), formatFloat(q.Precision), q.Bits)
+		return
+	}
+	size, _ := bitSize(t.Kind)
+	if r := t.Range; r != nil {
+		if size == 64 {
+			g.printf("w.writeBigRanged(%s, %dn, %dn, %d);\n", expr, r.Min, r.Max, r.Bits)
+			return
+		}
+		g.printf("w.writeRanged(%s, %d, %d, %d);\n", expr, r.Min, r.Max, r.Bits)
+		return
+	}
+	switch t.Kind {
+	case packbuf.KindFloat32:
+		g.printf("w.writeFloat32(%s);\n", expr)
+	case packbuf.KindFloat64:
+		g.printf("w.writeFloat64(%s);\n", expr)
+	case packbuf.KindInt64, packbuf.KindUint64:
+		g.printf("w.writeBigBits(BigInt.asUintN(64, %s), 64);\n", expr)
+	default:
+		g.printf("w.writeInt(%s, %d);\n", expr, size)
+	}
+}
+
+// readValue writes the TypeScript that reads a value into target
+func (g *tsGenerator) readValue(t *packbuf.TypeSchema, target string, depth int) error {
+	suffix := strconv.Itoa(depth)
+	switch t.Kind {
+	case packbuf.KindBool:
+		g.printf("%s = r.readBool();\n", target)
+	case packbuf.KindString:
+		g.printf("%s = r.readString(%s);\n", target, strings.TrimPrefix(lengthArgs(t), ", "))
+	case packbuf.KindBinary:
+		g.printf("%s = r.readBinary();\n", target)
+	case packbuf.KindStruct:
+		g.printf("%s = read%s(r);\n", target, g.names[t.Struct])
+	case packbuf.KindPointer:
+		elemType, err := g.tsType(t.Elem)
+		if err != nil {
+			return err
+		}
+		g.printf("if (r.readBool()) {\n")
+		g.indent++
+		g.printf("let p%s: %s;\n", suffix, elemType)
+		if err := g.readValue(t.Elem, "p"+suffix, depth+1); err != nil {
+			return err
+		}
+		g.printf("%s = p%s;\n", target, suffix)
+		g.indent--
+		g.printf("} else {\n")
+		g.printf("  %s = null;\n", target)
+		g.printf("}\n")
+	case packbuf.KindSlice, packbuf.KindArray:
+		elemType, err := g.tsType(t.Elem)
+		if err != nil {
+			return err
+		}
+		g.printf("{\n")
+		g.indent++
+		if t.Kind == packbuf.KindArray {
+			g.printf("const n%s = %d;\n", suffix, t.Len)
+		} else {
+			g.printf("const n%s = r.readLength(%s);\n", suffix, strings.TrimPrefix(lengthArgs(t), ", "))
+		}
+		g.printf("const a%s: %s[] = [];\n", suffix, wrapType(elemType))
+		g.printf("for (let i%s = 0; i%s < n%s; i%s++) {\n", suffix, suffix, suffix, suffix)
+		g.indent++
+		g.printf("let e%s: %s;\n", suffix, elemType)
+		if err := g.readValue(t.Elem, "e"+suffix, depth+1); err != nil {
+			return err
+		}
+		g.printf("a%s.push(e%s);\n", suffix, suffix)
+		g.indent--
+		g.printf("}\n")
+		g.printf("%s = a%s;\n", target, suffix)
+		g.indent--
+		g.printf("}\n")
+	case packbuf.KindMap:
+		keyType, err := g.tsType(t.Key)
+		if err != nil {
+			return err
+		}
+		elemType, err := g.tsType(t.Elem)
+		if err != nil {
+			return err
+		}
+		g.printf("{\n")
+		g.indent++
+		g.printf("const n%s = r.readLength(%s);\n", suffix, strings.TrimPrefix(lengthArgs(t), ", "))
+		g.printf("const m%s = new Map<%s, %s>();\n", suffix, keyType, elemType)
+		g.printf("for (let i%s = 0; i%s < n%s; i%s++) {\n", suffix, suffix, suffix, suffix)
+		g.indent++
+		g.printf("let k%s: %s;\n", suffix, keyType)
+		if err := g.readValue(t.Key, "k"+suffix, depth+1); err != nil {
+			return err
+		}
+		g.printf("if (m%s.has(k%s)) {\n", suffix, suffix)
+		g.printf("  throw new Error(\"duplicate map key\");\n")
+		g.printf("}\n")
+		g.printf("let e%s: %s;\n", suffix, elemType)
+		if err := g.readValue(t.Elem, "e"+suffix, depth+1); err != nil {
+			return err
+		}
+		g.printf("m%s.set(k%s, e%s);\n", suffix, suffix, suffix)
+		g.indent--
+		g.printf("}\n")
+		g.printf("%s = m%s;\n", target, suffix)
+		g.indent--
+		g.printf("}\n")
+	case packbuf.KindCustom:
+		return errors.New("cannot generate TypeScript for " + t.GoType + " as it has its own MarshalPackbuf method")
+	default:
+		g.readNumber(t, target)
+	}
+	return nil
+}
+
+func (g *tsGenerator) readNumber(t *packbuf.TypeSchema, target string) {
+	if q := t.Quantize; q != nil {
+		g.printf("%s = r.readQuantized(%s, %s, %s, %d);\n", target, formatFloat(q.Min), formatFloat(q.Max), formatFloat(q.Precision), q.Bits)
+		return
+	}
+	size, _ := bitSize(t.Kind)
+	if r := t.Range; r != nil {
+		if size == 64 {
+			g.printf("%s = r.readBigRanged(%dn, %dn, %d);\n", target, r.Min, r.Max, r.Bits)
+			return
+		}
+		g.printf("%s = r.readRanged(%d, %d, %d);\n", target, r.Min, r.Max, r.Bits)
+		return
+	}
+	switch t.Kind {
+	case packbuf.KindFloat32:
+		g.printf("%s = r.readFloat32();\n", target)
+	case packbuf.KindFloat64:
+		g.printf("%s = r.readFloat64();\n", target)
+	case packbuf.KindInt64:
+		g.printf("%s = BigInt.asIntN(64, r.readBigBits(64));\n", target)
+	case packbuf.KindUint64:
+		g.printf("%s = r.readBigBits(64);\n", target)
+	case packbuf.KindInt8, packbuf.KindInt16, packbuf.KindInt32:
+		g.printf("%s = r.readInt(%d);\n", target, size)
+	default:
+		g.printf("%s = r.readBits(%d);\n", target, size)
+	}
+}
+
+// wrapType wraps union types in brackets so they can be used as an array element
+func wrapType(typ string) string {
+	if strings.Contains(typ, " ") && !strings.HasPrefix(typ, "Map<") {
+		return "(" + typ + ")"
+	}
+	return typ
+}
+
+// writePackets writes the packet IDs and functions to read and write any packet
+func (g *tsGenerator) writePackets() {
+	packets := append([]packs.PacketSchema(nil), g.schema.Packets...)
+	sort.Slice(packets, func(i, j int) bool {
+		return packets[i].ID < packets[j].ID
+	})
+	for _, packet := range packets {
+		g.printf("export const %sID = %d;\n", g.names[packet.Struct], packet.ID)
+	}
+	g.printf("\n")
+
+	g.printf("export type Packet =")
+	for _, packet := range packets {
+		name := g.names[packet.Struct]
+		g.printf("\n  | { id: typeof %sID; packet: %s }", name, name)
+	}
+	g.printf(";\n\n")
+
+	for _, direction := range []struct {
+		Name  string
+		Match func(packet packs.PacketSchema) bool
+	}{
+		{"clientToServer", func(packet packs.PacketSchema) bool { return packet.ClientToServer }},
+		{"serverToClient", func(packet packs.PacketSchema) bool { return packet.ServerToClient }},
+	} {
+		var ids []string
+		for _, packet := range packets {
+			if direction.Match(packet) {
+				ids = append(ids, g.names[packet.Struct]+"ID")
+			}
+		}
+		g.printf("// %sPacketIDs are the packets that can be sent in that direction\n", direction.Name)
+		g.printf("export const %sPacketIDs: ReadonlySet<number> = new Set([%s]);\n\n", direction.Name, strings.Join(ids, ", "))
+	}
+
+	g.printf("// writePacket writes the packet ID, sequence ID and packet, padded to the next byte\n")
+	g.printf("export function writePacket(w: BitWriter, sequenceId: number, p: Packet): void {\n")
+	g.printf("  w.writeInt(p.id, 8);\n")
+	g.printf("  w.writeInt(sequenceId, 16);\n")
+	g.printf("  switch (p.id) {\n")
+	for _, packet := range packets {
+		name := g.names[packet.Struct]
+		g.printf("    case %sID:\n", name)
+		g.printf("      write%s(w, p.packet);\n", name)
+		g.printf("      break;\n")
+	}
+	g.printf("  }\n")
+	g.printf("  w.align();\n")
+	g.printf("}\n\n")
+
+	g.printf("// readPacket reads a packet written by writePacket, an error is thrown if the\n")
+	g.printf("// packet ID isn't in allowedIds\n")
+	g.printf("export function readPacket(r: BitReader, allowedIds?: ReadonlySet<number>): Packet & { sequenceId: number } {\n")
+	g.printf("  const id = r.readBits(8);\n")
+	g.printf("  if (allowedIds !== undefined && !allowedIds.has(id)) {\n")
+	g.printf("    throw new Error(\"invalid packet id: \" + id);\n")
+	g.printf("  }\n")
+	g.printf("  const sequenceId = r.readBits(16);\n")
+	g.printf("  let p: Packet;\n")
+	g.printf("  switch (id) {\n")
+	for _, packet := range packets {
+		name := g.names[packet.Struct]
+		g.printf("    case %sID:\n", name)
+		g.printf("      p = { id: %sID, packet: read%s(r) };\n", name, name)
+		g.printf("      break;\n")
+	}
+	g.printf("    default:\n")
+	g.printf("      throw new Error(\"invalid packet id: \" + id);\n")
+	g.printf("  }\n")
+	g.printf("  r.align();\n")
+	g.printf("  return { ...p, sequenceId };\n")
+	g.printf("}\n\n")
+
+	g.printf("// readPackets reads every packet in the payload of a datagram, see openDatagram\n")
+	g.printf("export function readPackets(payload: Uint8Array, allowedIds?: ReadonlySet<number>): Array<Packet & { sequenceId: number }> {\n")
+	g.printf("  const r = new BitReader(payload);\n")
+	g.printf("  const packets: Array<Packet & { sequenceId: number }> = [];\n")
+	g.printf("  while (!r.done()) {\n")
+	g.printf("    packets.push(readPacket(r, allowedIds));\n")
+	g.printf("  }\n")
+	g.printf("  return packets;\n")
+	g.printf("}\n")
+}
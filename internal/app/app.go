@@ -2,7 +2,10 @@ package app
 
 import (
 	"image"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/asset"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
@@ -10,6 +13,7 @@ import (
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/client_or_server"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconf"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/renderer"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/world"
 )
@@ -38,14 +42,61 @@ func (app *App) Init() {
 	}
 
 	app.SetRunnableOnUnfocused(true)
+	// the client and server need to simulate at the same speed
+	app.SetTickRate(netconst.TickRate)
 
 	// Load client/server
-	app.clientOrServer = client_or_server.NewClientOrServer(netconf.Options{
+	options := netconf.Options{
 		// note(jae): 2021-04-17
 		// if hosting non-locally, this should be your servers remote IP
 		// ie. PublicIP: "220.240.114.91",
 		PublicIP: "127.0.0.1",
-	})
+	}
+	// Set PACKET_CAPTURE to a file path to record every datagram, these can be
+	// viewed with cmd/packdump
+	if path := os.Getenv("PACKET_CAPTURE"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			panic(err)
+		}
+		options.Capture = f
+	}
+	// Set COMPRESS to "true" to deflate the datagrams we send when it makes them smaller
+	if compress := os.Getenv("COMPRESS"); compress != "" {
+		v, err := strconv.ParseBool(compress)
+		if err != nil {
+			panic(err)
+		}
+		options.Compress = v
+	}
+	// Set NET_STATS_INTERVAL to a duration, ie. "5s", to log the latency, packet loss
+	// and bandwidth of every connection
+	if interval := os.Getenv("NET_STATS_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			panic(err)
+		}
+		options.StatsLogInterval = d
+	}
+	// Set SNAPSHOT_RATE to how many world states per second the server sends each client,
+	// ie. "20", by default it sends one every tick
+	if snapshotRate := os.Getenv("SNAPSHOT_RATE"); snapshotRate != "" {
+		rate, err := strconv.Atoi(snapshotRate)
+		if err != nil {
+			panic(err)
+		}
+		options.SnapshotRate = rate
+	}
+	// Set MAX_REWIND to a duration, ie. "100ms", to change how far back the server will
+	// rewind the world to simulate inputs on the tick they were pressed
+	if maxRewind := os.Getenv("MAX_REWIND"); maxRewind != "" {
+		d, err := time.ParseDuration(maxRewind)
+		if err != nil {
+			panic(err)
+		}
+		options.MaxRewind = d
+	}
+	app.clientOrServer = client_or_server.NewClientOrServer(options)
 }
 
 func (app *App) Update() error {
@@ -0,0 +1,78 @@
+// clock lets code that depends on the current time be given a clock that is controlled
+// manually, so that latency and timeouts can be tested deterministically
+package clock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/monotime"
+)
+
+// Clock tells the time and waits for time to pass
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// compile-time assert we implement this interface
+var _ Clock = Real{}
+var _ Clock = new(Manual)
+
+// Real is the system clock, it's measured with monotime so that it's precise on Web and Windows
+type Real struct{}
+
+var (
+	realStartTime     = time.Now()
+	realStartMonotime = monotime.Now()
+)
+
+func (Real) Now() time.Time {
+	return realStartTime.Add(monotime.Now() - realStartMonotime)
+}
+
+func (Real) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// Manual is a clock that only moves when told to
+type Manual struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManual returns a clock that starts at the given time
+func NewManual(start time.Time) *Manual {
+	return &Manual{
+		now: start,
+	}
+}
+
+func (c *Manual) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep moves the clock forward by d straight away
+func (c *Manual) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the clock forward by d, negative durations are ignored
+func (c *Manual) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// OrReal returns the clock given or the real clock if it's nil
+func OrReal(clock Clock) Clock {
+	if clock == nil {
+		return Real{}
+	}
+	return clock
+}
@@ -0,0 +1,49 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManual(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewManual(start)
+	if now := clk.Now(); !now.Equal(start) {
+		t.Errorf("expected clock to start at %v but got %v", start, now)
+	}
+	clk.Advance(50 * time.Millisecond)
+	if now := clk.Now(); !now.Equal(start.Add(50 * time.Millisecond)) {
+		t.Errorf("expected clock to advance by 50ms but got %v", now.Sub(start))
+	}
+	clk.Sleep(time.Second)
+	if now := clk.Now(); !now.Equal(start.Add(1050 * time.Millisecond)) {
+		t.Errorf("expected sleep to advance the clock to 1.05s but got %v", now.Sub(start))
+	}
+	// time doesn't go backwards
+	clk.Advance(-time.Second)
+	if now := clk.Now(); !now.Equal(start.Add(1050 * time.Millisecond)) {
+		t.Errorf("expected negative duration to be ignored but clock is at %v", now.Sub(start))
+	}
+}
+
+func TestReal(t *testing.T) {
+	var clk Clock = Real{}
+	before := clk.Now()
+	clk.Sleep(10 * time.Millisecond)
+	if elapsed := clk.Now().Sub(before); elapsed < 10*time.Millisecond {
+		t.Errorf("expected at least 10ms to pass but only %v did", elapsed)
+	}
+	if diff := clk.Now().Sub(time.Now()); diff < -time.Second || diff > time.Second {
+		t.Errorf("expected real clock to be close to the system time but was %v off", diff)
+	}
+}
+
+func TestOrReal(t *testing.T) {
+	if _, ok := OrReal(nil).(Real); !ok {
+		t.Errorf("expected the real clock if none is given")
+	}
+	clk := NewManual(time.Unix(1000, 0))
+	if OrReal(clk) != Clock(clk) {
+		t.Errorf("expected the given clock")
+	}
+}
@@ -37,6 +37,12 @@ type Player struct {
 	Width, Height  float32
 	Hspeed, Vspeed float32
 	DirLeft        bool
+	// IsInterpolated is true if the client moves this player between world states from the
+	// server, these players aren't simulated
+	IsInterpolated bool
+	// DrawOffsetX and DrawOffsetY are added to the position when drawing, the client uses
+	// this to blend out corrections to where it predicted its own player
+	DrawOffsetX, DrawOffsetY float32
 }
 
 type PlayerInput struct {
@@ -129,7 +135,7 @@ func (self *Player) Draw(screen renderer.Screen) {
 	}
 
 	screen.DrawImage(s, renderer.ImageOptions{
-		X: self.X,
-		Y: self.Y,
+		X: self.X + self.DrawOffsetX,
+		Y: self.Y + self.DrawOffsetY,
 	})
 }
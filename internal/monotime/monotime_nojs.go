@@ -6,6 +6,10 @@ import (
 	"time"
 )
 
+// start is when the program started, time.Since uses the monotonic clock reading
+// from it so the result isn't affected by changes to the wall clock
+var start = time.Now()
+
 func now() time.Duration {
-	return time.Now().UnixNano()
+	return time.Since(start)
 }
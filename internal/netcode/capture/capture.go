@@ -0,0 +1,197 @@
+// capture records the datagrams sent and received by the client or server to a compact
+// file, so they can be decoded later with cmd/packdump.
+//
+// DataChannels are encrypted with DTLS so tools like Wireshark can't see our packets,
+// recording them before they're encrypted is the easiest way to debug what was sent.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// magic is written at the start of every capture file
+const magic = "PKCP"
+
+// version is the version of the capture format, this should be incremented if it changes
+const version = 1
+
+// headerSize is the size of the magic, version and start time at the start of a capture file
+const headerSize = len(magic) + 1 + 8
+
+// maxDatagramSize is the largest datagram that can be recorded
+const maxDatagramSize = 65536
+
+var (
+	ErrInvalidMagic       = errors.New("not a capture file")
+	ErrDatagramTooLarge   = errors.New("datagram is too large to record")
+	ErrUnsupportedVersion = errors.New("unsupported capture version")
+)
+
+// Direction is whether a datagram was sent or received
+type Direction uint8
+
+const (
+	Sent Direction = iota
+	Received
+)
+
+func (dir Direction) String() string {
+	switch dir {
+	case Sent:
+		return "sent"
+	case Received:
+		return "received"
+	}
+	return "direction(" + strconv.Itoa(int(dir)) + ")"
+}
+
+// Record is a single datagram that was sent or received
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	// ConnID is the ID of the connection on the server, this is always 0 for the client
+	// as it only has a connection to the server
+	ConnID   uint16
+	Datagram []byte
+}
+
+// Writer writes records to a capture file
+//
+// Each record is written with a single Write call so that a capture is still readable
+// if the program exits without closing the file. A Writer isn't safe to use from
+// multiple goroutines.
+type Writer struct {
+	w        io.Writer
+	lastTime time.Time
+	buf      []byte
+}
+
+// NewWriter writes the capture file header to w and returns a Writer that writes
+// records after it
+func NewWriter(w io.Writer, start time.Time) (*Writer, error) {
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, version)
+	header = appendUint64(header, uint64(start.UnixNano()))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:        w,
+		lastTime: start,
+	}, nil
+}
+
+// Record writes the datagram to the capture file
+//
+// Times are stored as microseconds since the last record, so they should be taken
+// from time.Now so that they're monotonic.
+func (cw *Writer) Record(t time.Time, dir Direction, connID uint16, datagram []byte) error {
+	if len(datagram) > maxDatagramSize {
+		return ErrDatagramTooLarge
+	}
+	delta := t.Sub(cw.lastTime) / time.Microsecond
+	var scratch [binary.MaxVarintLen64]byte
+	buf := cw.buf[:0]
+	buf = append(buf, scratch[:binary.PutVarint(scratch[:], int64(delta))]...)
+	buf = append(buf, byte(dir))
+	buf = append(buf, scratch[:binary.PutUvarint(scratch[:], uint64(connID))]...)
+	buf = append(buf, scratch[:binary.PutUvarint(scratch[:], uint64(len(datagram)))]...)
+	buf = append(buf, datagram...)
+	cw.buf = buf
+	if _, err := cw.w.Write(buf); err != nil {
+		return err
+	}
+	// only move forward by what was written, so rounding errors don't add up
+	cw.lastTime = cw.lastTime.Add(delta * time.Microsecond)
+	return nil
+}
+
+// Reader reads records from a capture file
+type Reader struct {
+	r        *bufio.Reader
+	lastTime time.Time
+	// Start is the time the capture was started
+	Start time.Time
+}
+
+// NewReader reads the capture file header from r
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	var header [headerSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidMagic
+		}
+		return nil, err
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrInvalidMagic
+	}
+	if v := header[len(magic)]; v != version {
+		return nil, ErrUnsupportedVersion
+	}
+	start := time.Unix(0, int64(binary.LittleEndian.Uint64(header[len(magic)+1:])))
+	return &Reader{
+		r:        br,
+		lastTime: start,
+		Start:    start,
+	}, nil
+}
+
+// Next reads the next record, io.EOF is returned once every record has been read
+func (cr *Reader) Next() (Record, error) {
+	delta, err := binary.ReadVarint(cr.r)
+	if err != nil {
+		// io.EOF here means there are no more records
+		return Record{}, err
+	}
+	dir, err := cr.r.ReadByte()
+	if err != nil {
+		return Record{}, unexpectedEOF(err)
+	}
+	connID, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return Record{}, unexpectedEOF(err)
+	}
+	if connID > 65535 {
+		return Record{}, errors.New("invalid connection id: " + strconv.FormatUint(connID, 10))
+	}
+	n, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return Record{}, unexpectedEOF(err)
+	}
+	if n > maxDatagramSize {
+		return Record{}, ErrDatagramTooLarge
+	}
+	datagram := make([]byte, n)
+	if _, err := io.ReadFull(cr.r, datagram); err != nil {
+		return Record{}, unexpectedEOF(err)
+	}
+	cr.lastTime = cr.lastTime.Add(time.Duration(delta) * time.Microsecond)
+	return Record{
+		Time:      cr.lastTime,
+		Direction: Direction(dir),
+		ConnID:    uint16(connID),
+		Datagram:  datagram,
+	}, nil
+}
+
+// unexpectedEOF is used when a record was only partly read
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(dst, b[:]...)
+}
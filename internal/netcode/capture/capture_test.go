@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	start := time.Unix(1600000000, 123456789)
+	records := []Record{
+		{
+			Time:      start.Add(16 * time.Millisecond),
+			Direction: Sent,
+			ConnID:    1,
+			Datagram:  []byte{1, 2, 3},
+		},
+		{
+			Time:      start.Add(16*time.Millisecond + 1500*time.Microsecond),
+			Direction: Received,
+			ConnID:    65535,
+			Datagram:  []byte{},
+		},
+		{
+			Time:      start.Add(time.Hour),
+			Direction: Sent,
+			ConnID:    0,
+			Datagram:  bytes.Repeat([]byte{0xFF}, 1200),
+		},
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := w.Record(record.Time, record.Direction, record.ConnID, record.Datagram); err != nil {
+			t.Fatalf("unable to write record: %v", err)
+		}
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unable to read capture: %v", err)
+	}
+	if !r.Start.Equal(start) {
+		t.Errorf("expected start time %v but got %v", start, r.Start)
+	}
+	for i, expected := range records {
+		record, err := r.Next()
+		if err != nil {
+			t.Fatalf("unable to read record %d: %v", i, err)
+		}
+		if !record.Time.Equal(expected.Time) ||
+			record.Direction != expected.Direction ||
+			record.ConnID != expected.ConnID ||
+			!bytes.Equal(record.Datagram, expected.Datagram) {
+			t.Errorf("record %d doesn't match\nexpected: %+v\ngot:      %+v", i, expected, record)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF after last record but got: %v", err)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Record(time.Now(), Sent, 1, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for truncated record but got: %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture file"))); !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic but got: %v", err)
+	}
+}
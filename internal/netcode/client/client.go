@@ -6,21 +6,46 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"time"
 
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/capture"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/clocksync"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/correction"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/interpolation"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconf"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/worldstate"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netdriver/webrtcdriver/webrtcclient"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/world"
 )
 
+// clockSyncInterval is how many frames we wait between asking the server for its tick
+const clockSyncInterval = netconst.TickRate / 4
+
 // compile-time assert we implement this interface
 var _ netcode.Controller = new(Controller)
 
 func New(options netconf.Options) *Controller {
 	net := &Controller{}
+	net.clock = clock.OrReal(options.Clock)
+	net.rtt.Init(rtt.Options{Clock: net.clock})
+	net.checksum = packs.NewChecksum(netconst.ProtocolID)
+	net.compressor = packs.NewCompressor()
+	net.compress = options.Compress
+	net.statsLogInterval = options.StatsLogInterval
+	if options.Capture != nil {
+		captureWriter, err := capture.NewWriter(options.Capture, net.clock.Now())
+		if err != nil {
+			log.Printf("unable to start capture: %v", err)
+		}
+		net.capture = captureWriter
+	}
 	net.client = webrtcclient.New(webrtcclient.Options{
 		IPAddress:     options.PublicIP + ":50000",
 		ICEServerURLs: []string{"stun:" + options.PublicIP + ":3478"},
@@ -35,10 +60,36 @@ type Controller struct {
 	backingBuf [65536]byte
 	rtt        rtt.RoundTripTracking
 	ackPacket  packs.AckPacket
+	checksum   packs.Checksum
+	compressor *packs.Compressor
+	// compress is true if we should compress datagrams we send when it makes them smaller
+	compress bool
+	// capture records every datagram sent and received, if set
+	capture *capture.Writer
+	// clock is used for round trip times, captures and logging stats
+	clock clock.Clock
+	// statsLogInterval is how often we log the stats of our connection, 0 if disabled
+	statsLogInterval time.Duration
+	lastStatsLogTime time.Time
+
+	// receivedSequences is used to ignore packets we've already received
+	// or that arrived after a newer packet of the same type
+	receivedSequences packs.PacketSequences
 
 	frameCounter     uint16
 	frameInputBuffer []packs.ClientFrameInput
 
+	// worldStates are the world states we've received from the server, so we can
+	// decode world states that were delta-encoded against them
+	worldStates worldstate.History
+	// interpolation is used to move other players smoothly between world states
+	interpolation interpolation.Buffer
+	// clockSync estimates the servers tick, framesUntilClockSync counts down to when we next ask for it
+	clockSync            clocksync.Estimator
+	framesUntilClockSync int
+	// correction blends out the difference between where we predicted our player and where the server put them
+	correction correction.Smoother
+
 	hasStarted   bool
 	hasConnected bool
 }
@@ -51,6 +102,49 @@ func (net *Controller) HasStartedOrConnected() bool {
 	return net.client.IsConnected()
 }
 
+// record adds the datagram to the capture, if capturing
+func (net *Controller) record(dir capture.Direction, datagram []byte) {
+	if net.capture == nil {
+		return
+	}
+	// we only have a connection to the server, so the connection ID is always 0
+	if err := net.capture.Record(net.clock.Now(), dir, 0, datagram); err != nil {
+		log.Printf("stopping capture, unable to record datagram: %v", err)
+		net.capture = nil
+	}
+}
+
+func setPlayerState(entity *ent.Player, state packs.PlayerState) {
+	entity.X = state.X
+	entity.Y = state.Y
+	entity.Hspeed = state.Hspeed
+	entity.Vspeed = state.Vspeed
+	entity.DirLeft = state.DirLeft
+}
+
+// ServerTick estimates what tick the server is on right now, ok is false until the server
+// has replied to one of our clock sync requests
+func (net *Controller) ServerTick() (tick uint16, ok bool) {
+	estimate, ok := net.clockSync.ServerTick(net.clock.Now())
+	if !ok {
+		return 0, false
+	}
+	return uint16(int64(math.Floor(estimate))), true
+}
+
+// Stats returns the network statistics of our connection to the server
+func (net *Controller) Stats() []netcode.ConnectionStats {
+	if !net.client.IsConnected() {
+		return nil
+	}
+	return []netcode.ConnectionStats{
+		{
+			Stats:      net.rtt.Stats(),
+			Prediction: net.correction.Stats(),
+		},
+	}
+}
+
 func (net *Controller) init() {
 	net.buf = bytes.NewBuffer(net.backingBuf[:])
 	net.client.Start()
@@ -111,49 +205,95 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 			// If no more packet data
 			break
 		}
+		net.record(capture.Received, byteData)
+		net.rtt.Received(len(byteData))
+		payload, err := net.checksum.Open(byteData)
+		if err == nil {
+			payload, err = net.compressor.Decompress(payload)
+		}
+		if err != nil {
+			log.Printf("Dropping datagram from server: %v", err)
+			continue
+		}
 		var buf bytes.Reader
-		buf.Reset(byteData)
+		buf.Reset(payload)
 		for {
-			sequenceID, packet, err := packs.Read(&buf)
+			sequenceID, packet, err := packs.ServerToClient.Read(&buf)
 			if err != nil {
 				if errors.Is(err, io.EOF) {
 					break
 				}
-				panic(err)
+				// the rest of the datagram can't be trusted if we failed to read a packet
+				log.Printf("Dropping the rest of datagram from server, unable to read packet: %v", err)
+				break
 			}
-			if _, ok := packet.(*packs.AckPacket); !ok {
-				// To avoid recursion, we don't acknowledge acknowledgement packets
-				net.ackPacket.SequenceIDList = append(net.ackPacket.SequenceIDList, sequenceID)
+			if err := packs.Validate(packet); err != nil {
+				log.Printf("Bad packet from server, ignoring: %v", err)
+				continue
+			}
+			seqStatus := net.receivedSequences.Receive(packet.ID(), sequenceID)
+			if seqStatus == packs.SequenceDuplicate {
+				continue
 			}
+			// To avoid recursion, we don't acknowledge acknowledgement packets
+			_, isAckPacket := packet.(*packs.AckPacket)
+			shouldAck := !isAckPacket
 			switch packet := packet.(type) {
 			case *packs.AckPacket:
 				for _, seqID := range packet.SequenceIDList {
 					net.rtt.Ack(seqID)
 				}
 			case *packs.ServerWorldStatePacket:
-				if packet.MyNetID == 0 {
-					log.Printf("Bad packet from server, has ID of 0: %+v", packet)
-					continue
+				if seqStatus == packs.SequenceStale {
+					// Never apply a world state older than one we've already received,
+					// don't acknowledge it either so the server never uses it as a baseline
+					shouldAck = false
+					break
 				}
-				// We only want to use the latest up to date world state
-				if lastWorldStatePacket != nil {
-					if rtt.IsWrappedUInt16GreaterThan(packet.LastSimulatedInputFrame, lastWorldStatePacket.LastSimulatedInputFrame) {
-						lastWorldStatePacket = packet
+				if packet.HasBaseline {
+					baseline, ok := net.worldStates.Get(packet.BaselineSequenceID)
+					if !ok {
+						// If we can't decode the world state, don't acknowledge it so the server
+						// never uses it as a baseline
+						log.Printf("Missing baseline world state %d for world state %d, ignoring", packet.BaselineSequenceID, sequenceID)
+						shouldAck = false
+						break
+					}
+					if err := packet.DeltaDecode(baseline); err != nil {
+						log.Printf("Unable to decode world state %d: %v", sequenceID, err)
+						shouldAck = false
+						break
 					}
-				} else {
-					lastWorldStatePacket = packet
 				}
+				net.worldStates.Put(sequenceID, packet.Players)
+				net.interpolation.Add(packet.ServerTick, packet.Players)
+				// Stale world states are skipped above, so this is always the latest one
+				lastWorldStatePacket = packet
+			case *packs.ClockSyncResponsePacket:
+				sentTime, ok := net.rtt.SentTime(packet.RequestSequenceID)
+				if !ok {
+					// too old to be useful
+					break
+				}
+				held := time.Duration(packet.HeldMicroseconds) * time.Microsecond
+				net.clockSync.Add(sentTime, net.clock.Now(), packet.ServerTick, held)
 			default:
-				panic(fmt.Sprintf("unhandled packet type: %T", packet))
+				log.Printf("Unhandled packet type from server, ignoring: %T", packet)
+				continue
+			}
+			if shouldAck {
+				net.ackPacket.SequenceIDList = append(net.ackPacket.SequenceIDList, sequenceID)
 			}
 		}
 	}
 
-	// Use the latest up-to-date world state and snap to it
+	// Use the latest up-to-date world state and snap our player to it
 	// (also replay inputs the server hasn't simulated yet)
 	if packet := lastWorldStatePacket; packet != nil {
+		var predictedX, predictedY float32
 		if world.MyPlayer != nil {
 			world.MyPlayer.NetID = packet.MyNetID
+			predictedX, predictedY = world.MyPlayer.X, world.MyPlayer.Y
 		}
 		for _, state := range packet.Players {
 			hasFound := false
@@ -161,21 +301,17 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 				if entity.NetID != state.NetID {
 					continue
 				}
-				entity.X = state.X
-				entity.Y = state.Y
-				entity.Hspeed = state.Hspeed
-				entity.Vspeed = state.Vspeed
-				entity.DirLeft = state.DirLeft
+				if entity == world.MyPlayer {
+					setPlayerState(entity, state)
+				}
 				hasFound = true
 			}
 			if !hasFound {
+				// other players are moved by interpolation below
 				entity := world.CreatePlayer()
 				entity.NetID = state.NetID
-				entity.X = state.X
-				entity.Y = state.Y
-				entity.Hspeed = state.Hspeed
-				entity.Vspeed = state.Vspeed
-				entity.DirLeft = state.DirLeft
+				entity.IsInterpolated = true
+				setPlayerState(entity, state)
 			}
 		}
 
@@ -198,6 +334,10 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 				if player := world.MyPlayer; player != nil {
 					prevInput := player.Inputs
 					for _, inputFrame := range unprocessedFrameInputBuffer {
+						if inputFrame.Frame == net.frameCounter {
+							// this frames input is simulated when the world updates
+							break
+						}
 						player.Inputs = inputFrame.PlayerInput
 						player.Update()
 					}
@@ -205,11 +345,35 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 				}
 			}
 		}
+
+		// If we mispredicted, keep drawing our player where we predicted and blend to where they are
+		if player := world.MyPlayer; player != nil {
+			net.correction.Correct(predictedX, predictedY, player.X, player.Y)
+		}
+	}
+	net.correction.Advance()
+	if player := world.MyPlayer; player != nil {
+		player.DrawOffsetX, player.DrawOffsetY = net.correction.Offset()
+	}
+
+	// Draw other players slightly in the past, between the world states either side of that time,
+	// so they move smoothly even if world states arrive unevenly
+	net.interpolation.Advance()
+	for _, entity := range world.Players {
+		if !entity.IsInterpolated {
+			continue
+		}
+		if state, ok := net.interpolation.Sample(entity.NetID); ok {
+			setPlayerState(entity, state)
+		}
 	}
 
 	// Send player input and acks to server every frame
 	{
 		net.buf.Reset()
+		// reserve space for the checksum and compression flags, these are filled in before sending
+		var header [packs.ChecksumSize + packs.CompressionHeaderSize]byte
+		net.buf.Write(header[:])
 		if len(net.ackPacket.SequenceIDList) > 0 {
 			if err := packs.Write(net.buf, &net.rtt, &net.ackPacket); err != nil {
 				panic(err)
@@ -227,6 +391,20 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 				panic(err)
 			}
 		}
+		net.framesUntilClockSync--
+		if net.framesUntilClockSync <= 0 {
+			if err := packs.Write(net.buf, &net.rtt, &packs.ClockSyncRequestPacket{}); err != nil {
+				panic(err)
+			}
+			net.framesUntilClockSync = clockSyncInterval
+		}
+		if net.compress {
+			datagram, err := net.compressor.Compress(net.buf.Bytes(), packs.ChecksumSize)
+			if err != nil {
+				panic(err)
+			}
+			net.buf.Truncate(len(datagram))
+		}
 		// Upper limit of packets in gamedev are generally: "something like 1000 to 1200 bytes of payload data"
 		// source: https://www.gafferongames.com/post/packet_fragmentation_and_reassembly/
 		if net.buf.Len() > 1000 {
@@ -238,9 +416,19 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 		}
 		// DEBUG: uncomment to debug packet size
 		//log.Printf("note: client sending size of packet is %d (rtt latency: %v)", net.buf.Len(), net.rtt.Latency())
+		net.checksum.Seal(net.buf.Bytes())
+		net.record(capture.Sent, net.buf.Bytes())
 		if err := net.client.Send(net.buf.Bytes()); err != nil {
 			panic(err)
 		}
+		net.rtt.Sent(net.buf.Len())
+	}
+
+	if net.statsLogInterval > 0 &&
+		net.clock.Now().Sub(net.lastStatsLogTime) >= net.statsLogInterval {
+		net.lastStatsLogTime = net.clock.Now()
+		serverTick, _ := net.ServerTick()
+		log.Printf("server connection: %v, %v, server tick: %d", net.rtt.Stats(), net.correction.Stats(), serverTick)
 	}
 
 	//fmt.Printf("Net RTT: %v\n", net.rtt.Latency())
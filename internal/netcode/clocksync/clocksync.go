@@ -0,0 +1,107 @@
+// clocksync estimates the servers current tick on the client.
+//
+// This works like NTP, the client times a request to the server and the server replies with its tick.
+// Assuming the reply took half of the round trip to get back, the server was on that tick half a round
+// trip ago. Round trips that took longer than usual were most likely held up in one direction more than the
+// other, so we use the quickest of the latest few round trips.
+//
+// Source: https://en.wikipedia.org/wiki/Network_Time_Protocol#Clock_synchronization_algorithm
+package clocksync
+
+import (
+	"math"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+)
+
+const (
+	// sampleWindow is how many of the latest round trips we pick the quickest from
+	sampleWindow = 8
+
+	// maxDrift is how many ticks our estimate can be off by before we jump straight
+	// to the new estimate rather than moving towards it
+	maxDrift = 3
+
+	// smoothingFactor is how much a new sample moves the estimate
+	smoothingFactor = 0.10
+)
+
+type sample struct {
+	// offset is how many ticks the server is ahead of our clock
+	offset    float64
+	roundTrip time.Duration
+}
+
+// Estimator estimates the servers tick from replies to clock sync requests
+type Estimator struct {
+	// start is when our clock started counting ticks
+	start time.Time
+
+	// samples are the latest round trips, sampleIndex is the next one to overwrite
+	samples     [sampleWindow]sample
+	sampleCount int
+	sampleIndex int
+
+	// lastServerTick is the newest server tick as the server sent it and serverTick is that tick
+	// unwrapped so it can keep counting up past the uint16 the server sends
+	lastServerTick uint16
+	serverTick     int64
+
+	// offset is the smoothed number of ticks the server is ahead of our clock
+	offset float64
+}
+
+// localTick is how many ticks our clock has counted at the given time
+func (e *Estimator) localTick(t time.Time) float64 {
+	return t.Sub(e.start).Seconds() * netconst.TickRate
+}
+
+// Add is called with a reply from the server, sentAt and receivedAt are when we sent the request and got
+// the reply, held is how long the server held onto the request before replying.
+func (e *Estimator) Add(sentAt, receivedAt time.Time, serverTick uint16, held time.Duration) {
+	if e.sampleCount == 0 {
+		e.start = sentAt
+		e.lastServerTick = serverTick
+		e.serverTick = int64(serverTick)
+	}
+	tick := e.serverTick + int64(int16(serverTick-e.lastServerTick))
+	if tick > e.serverTick {
+		e.lastServerTick = serverTick
+		e.serverTick = tick
+	}
+	roundTrip := receivedAt.Sub(sentAt) - held
+	if roundTrip < 0 {
+		roundTrip = 0
+	}
+	e.samples[e.sampleIndex] = sample{
+		offset:    float64(tick) + roundTrip.Seconds()/2*netconst.TickRate - e.localTick(receivedAt),
+		roundTrip: roundTrip,
+	}
+	e.sampleIndex = (e.sampleIndex + 1) % len(e.samples)
+	isFirst := e.sampleCount == 0
+	if e.sampleCount < len(e.samples) {
+		e.sampleCount++
+	}
+
+	best := e.samples[0]
+	for _, s := range e.samples[1:e.sampleCount] {
+		if s.roundTrip < best.roundTrip {
+			best = s
+		}
+	}
+	if isFirst || math.Abs(best.offset-e.offset) > maxDrift {
+		e.offset = best.offset
+		return
+	}
+	e.offset += smoothingFactor * (best.offset - e.offset)
+}
+
+// ServerTick estimates what tick the server is on at the given time, this keeps counting up
+// past the uint16 ticks the server sends. ok is false until we've got a reply from the server.
+func (e *Estimator) ServerTick(now time.Time) (tick float64, ok bool) {
+	if e.sampleCount == 0 {
+		return 0, false
+	}
+	return e.localTick(now) + e.offset, true
+}
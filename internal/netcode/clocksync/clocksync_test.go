@@ -0,0 +1,84 @@
+package clocksync
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+)
+
+const tickDuration = time.Second / netconst.TickRate
+
+// testServer replies to clock sync requests, its tick counts up from startTick
+type testServer struct {
+	start     time.Time
+	startTick uint16
+}
+
+// tick is the tick the server is on at the given time, unwrapped
+func (server testServer) tick(t time.Time) float64 {
+	return float64(server.startTick) + t.Sub(server.start).Seconds()*netconst.TickRate
+}
+
+// exchange sends a request at sentAt that takes up and down to get to the server and back,
+// the server holds it for heldTicks before replying
+func (server testServer) exchange(e *Estimator, sentAt time.Time, up, down time.Duration, heldTicks int) time.Time {
+	held := time.Duration(heldTicks) * tickDuration
+	replyAt := sentAt.Add(up + held)
+	receivedAt := replyAt.Add(down)
+	e.Add(sentAt, receivedAt, uint16(int64(math.Floor(server.tick(replyAt)))), held)
+	return receivedAt
+}
+
+func TestEstimatorServerTick(t *testing.T) {
+	for _, startTick := range []uint16{0, 65500} {
+		start := time.Unix(1000, 0)
+		server := testServer{start: start, startTick: startTick}
+		var e Estimator
+		if _, ok := e.ServerTick(start); ok {
+			t.Fatalf("expected no estimate before any replies")
+		}
+		now := start.Add(time.Second)
+		for i := 0; i < 40; i++ {
+			now = server.exchange(&e, now, 40*time.Millisecond, 40*time.Millisecond, i%3)
+			now = now.Add(250 * time.Millisecond)
+		}
+		tick, ok := e.ServerTick(now)
+		if !ok {
+			t.Fatalf("expected an estimate")
+		}
+		// ticks are whole numbers when sent, so we can be up to a tick behind, the server
+		// tick wraps around so we only compare the uint16 part
+		expected := server.tick(now)
+		diff := math.Mod(tick-expected, 65536)
+		if diff > 32768 {
+			diff -= 65536
+		} else if diff < -32768 {
+			diff += 65536
+		}
+		if diff > 0 || diff < -1 {
+			t.Errorf("start tick %d: expected server tick of %v but got %v", startTick, expected, tick)
+		}
+	}
+}
+
+func TestEstimatorIgnoresSlowRoundTrips(t *testing.T) {
+	start := time.Unix(1000, 0)
+	server := testServer{start: start, startTick: 100}
+	var e Estimator
+	now := start
+	for i := 0; i < 20; i++ {
+		now = server.exchange(&e, now, 30*time.Millisecond, 30*time.Millisecond, 0)
+		now = now.Add(100 * time.Millisecond)
+	}
+	// replies held up on the way back would put us ~14 ticks ahead of the server
+	// if we used them
+	for i := 0; i < 4; i++ {
+		now = server.exchange(&e, now, 30*time.Millisecond, 500*time.Millisecond, 0)
+	}
+	estimate, _ := e.ServerTick(now)
+	if diff := estimate - server.tick(now); math.Abs(diff) > 1 {
+		t.Errorf("expected slow round trips to be ignored, but estimate is %v ticks off", diff)
+	}
+}
@@ -0,0 +1,102 @@
+// correction smooths out the client snapping its own player to where the server says they are.
+//
+// When a world state arrives, the client moves its player to the server's position and replays the
+// inputs the server hasn't simulated yet. If the client mispredicted, ie. it didn't know it was
+// going to be bumped or it jumped a frame late on the server, that replay puts the player somewhere
+// else and they'd visibly snap there. Instead we draw the player where they were and blend that
+// error out over several frames, unless it's so large that snapping looks better.
+package correction
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+const (
+	// snapDistance is how many pixels off our prediction can be before we snap straight
+	// to the corrected position rather than blending to it
+	snapDistance = 64
+
+	// blendRate is how much of the error is removed each frame
+	blendRate = 0.15
+
+	// minError is how many pixels of error we ignore, below this there's nothing to see.
+	//
+	// Positions in world state deltas are rounded to packs.PositionPrecision so the server's position
+	// is usually off by up to half of that, this needs to be at least that or we'd count a misprediction
+	// every world state.
+	minError = packs.PositionPrecision
+)
+
+// Stats are how often and how badly our prediction was wrong
+type Stats struct {
+	// Mispredictions is how many times the server put our player somewhere other than we predicted
+	Mispredictions int
+	// Snaps is how many of those were further than we'll blend, so we snapped to the corrected position
+	Snaps int
+	// AverageError and MaxError are how many pixels off our prediction was
+	AverageError float64
+	MaxError     float64
+}
+
+func (stats Stats) String() string {
+	return fmt.Sprintf("mispredictions: %d (snapped: %d), error: %.2fpx (max: %.2fpx)",
+		stats.Mispredictions,
+		stats.Snaps,
+		stats.AverageError,
+		stats.MaxError,
+	)
+}
+
+// Smoother holds the error between where we draw our player and where they are
+type Smoother struct {
+	// x and y are the offset from where the player is to where we draw them
+	x, y float32
+
+	stats      Stats
+	totalError float64
+}
+
+// Correct is called after the players position is corrected, with where they were before and
+// where they are now. The player is drawn where they were, then blended to where they are.
+func (s *Smoother) Correct(fromX, fromY, toX, toY float32) {
+	dist := math.Hypot(float64(fromX-toX), float64(fromY-toY))
+	if dist < minError {
+		return
+	}
+	s.stats.Mispredictions++
+	s.totalError += dist
+	s.stats.AverageError = s.totalError / float64(s.stats.Mispredictions)
+	if dist > s.stats.MaxError {
+		s.stats.MaxError = dist
+	}
+
+	// keep drawing the player where we already were, including any error we're still blending out
+	s.x += fromX - toX
+	s.y += fromY - toY
+	if math.Hypot(float64(s.x), float64(s.y)) > snapDistance {
+		s.x, s.y = 0, 0
+		s.stats.Snaps++
+	}
+}
+
+// Advance blends out some of the error, this should be called once per frame
+func (s *Smoother) Advance() {
+	s.x -= s.x * blendRate
+	s.y -= s.y * blendRate
+	if math.Hypot(float64(s.x), float64(s.y)) < minError {
+		s.x, s.y = 0, 0
+	}
+}
+
+// Offset is how far from the players position they should be drawn
+func (s *Smoother) Offset() (x, y float32) {
+	return s.x, s.y
+}
+
+// Stats returns how often and how badly our prediction was wrong
+func (s *Smoother) Stats() Stats {
+	return s.stats
+}
@@ -0,0 +1,60 @@
+package correction
+
+import (
+	"testing"
+)
+
+func TestSmootherBlendsError(t *testing.T) {
+	var s Smoother
+	// we predicted x of 110 but the server put us at 100
+	s.Correct(110, 50, 100, 50)
+	if x, y := s.Offset(); x != 10 || y != 0 {
+		t.Fatalf("expected to still be drawn where we predicted, but got offset %v, %v", x, y)
+	}
+	prevX := float32(10)
+	frames := 0
+	for ; frames < 120; frames++ {
+		s.Advance()
+		x, _ := s.Offset()
+		if x >= prevX && x != 0 {
+			t.Fatalf("frame %d: expected error to shrink but went from %v to %v", frames, prevX, x)
+		}
+		if x == 0 {
+			break
+		}
+		prevX = x
+	}
+	if frames < 5 {
+		t.Errorf("expected error to be blended out over several frames, but took %d", frames)
+	}
+	if frames == 120 {
+		t.Errorf("expected error to be blended out but still had %v", prevX)
+	}
+}
+
+func TestSmootherSnaps(t *testing.T) {
+	var s Smoother
+	s.Correct(0, 0, 40, 0)
+	// another correction while blending that puts us too far out
+	s.Correct(0, 0, 40, 0)
+	if x, y := s.Offset(); x != 0 || y != 0 {
+		t.Errorf("expected large error to snap, but got offset %v, %v", x, y)
+	}
+	stats := s.Stats()
+	if stats.Mispredictions != 2 || stats.Snaps != 1 {
+		t.Errorf("expected 2 mispredictions and 1 snap but got %v", stats)
+	}
+	if stats.AverageError != 40 || stats.MaxError != 40 {
+		t.Errorf("expected error of 40 but got %v", stats)
+	}
+}
+
+func TestSmootherIgnoresCorrectPrediction(t *testing.T) {
+	var s Smoother
+	s.Correct(100, 100, 100, 100)
+	// positions from the server are rounded to a 1/16th of a pixel
+	s.Correct(100.03, 99.97, 100, 100)
+	if stats := s.Stats(); stats.Mispredictions != 0 {
+		t.Errorf("expected no mispredictions but got %v", stats)
+	}
+}
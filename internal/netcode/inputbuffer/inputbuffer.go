@@ -0,0 +1,128 @@
+// inputbuffer holds onto inputs sent by a client so that the server can simulate them at a
+// steady rate of one per tick, even if the packets carrying them arrive unevenly.
+//
+// Because packets don't necessarily arrive together, a jitter buffer ensures that groups of inputs
+// are processed together such as a combo in a fighting game, or a precise platforming manuver.
+// Without this, a gap in the inputs being processed could lead to a combo/manuver being broken.
+package inputbuffer
+
+import (
+	"math"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+const (
+	// minTargetDepth and maxTargetDepth limit how many inputs we hold onto before
+	// simulating them, every input held adds a tick of latency
+	minTargetDepth = 1
+	maxTargetDepth = 6
+
+	// skipAheadDepth is how many inputs past the target depth we allow before dropping
+	// the oldest ones, so a client that sent a burst of inputs doesn't stay behind
+	skipAheadDepth = 4
+
+	// smoothingFactor is how much a new input moves the smoothed jitter
+	smoothingFactor = 0.10
+)
+
+// Buffer holds the inputs of a single client
+type Buffer struct {
+	// inputs are waiting to be simulated, ordered from oldest to newest
+	inputs []packs.ClientFrameInput
+	// newestFrame is the unwrapped frame number of the newest input we've received
+	newestFrame int64
+	// lastFrame is the newest frame number as the client sent it
+	lastFrame   uint16
+	hasReceived bool
+	// isPlaying is false while we wait for the buffer to fill up to the target depth
+	isPlaying  bool
+	lastPlayed packs.ClientFrameInput
+
+	// tick counts calls to Next
+	tick int64
+	// frameOffset is the smoothed difference between the frame of an input and the tick it arrived on
+	frameOffset float64
+	// jitter is the smoothed amount packets arrive earlier or later than frameOffset says
+	jitter float64
+}
+
+// Add stores the inputs from a packet, inputs older than ones already received are ignored
+func (buf *Buffer) Add(inputs []packs.ClientFrameInput) {
+	isFirstPacket := !buf.hasReceived
+	for _, input := range inputs {
+		var frame int64
+		if !buf.hasReceived {
+			frame = int64(input.Frame)
+			buf.hasReceived = true
+		} else {
+			frame = buf.newestFrame + int64(int16(input.Frame-buf.lastFrame))
+			if frame <= buf.newestFrame {
+				// we've already got this input, clients send their last few inputs in every packet
+				continue
+			}
+		}
+		buf.newestFrame = frame
+		buf.lastFrame = input.Frame
+		buf.inputs = append(buf.inputs, input)
+
+		if isFirstPacket {
+			continue
+		}
+		// if inputs arrived at a steady rate, the difference between their frame and
+		// the tick they arrived on would always be the same
+		offset := float64(frame - buf.tick)
+		buf.jitter += smoothingFactor * (math.Abs(offset-buf.frameOffset) - buf.jitter)
+		buf.frameOffset += smoothingFactor * (offset - buf.frameOffset)
+	}
+	if isFirstPacket && buf.hasReceived {
+		// the first packet can hold inputs from before we were listening, so they
+		// all arrive together and shouldn't count as jitter
+		buf.frameOffset = float64(buf.newestFrame - buf.tick)
+	}
+}
+
+// TargetDepth is how many inputs we want waiting before we simulate them, this
+// grows with how unevenly inputs arrive
+func (buf *Buffer) TargetDepth() int {
+	depth := int(math.Round(1 + 2*buf.jitter))
+	if depth < minTargetDepth {
+		return minTargetDepth
+	}
+	if depth > maxTargetDepth {
+		return maxTargetDepth
+	}
+	return depth
+}
+
+// Depth is how many inputs are waiting to be simulated
+func (buf *Buffer) Depth() int {
+	return len(buf.inputs)
+}
+
+// Next returns the input to simulate this tick and should be called once per tick.
+//
+// If we've run out of inputs, isNew is false and this returns the last input so the player
+// keeps doing what they were doing, and we wait for the buffer to fill back up to the target
+// depth before simulating new inputs again.
+func (buf *Buffer) Next() (input packs.ClientFrameInput, isNew bool) {
+	buf.tick++
+	targetDepth := buf.TargetDepth()
+	if !buf.isPlaying {
+		if len(buf.inputs) < targetDepth {
+			return buf.lastPlayed, false
+		}
+		buf.isPlaying = true
+	}
+	if len(buf.inputs) > targetDepth+skipAheadDepth {
+		skipCount := len(buf.inputs) - targetDepth
+		buf.inputs = append(buf.inputs[:0], buf.inputs[skipCount:]...)
+	}
+	if len(buf.inputs) == 0 {
+		buf.isPlaying = false
+		return buf.lastPlayed, false
+	}
+	buf.lastPlayed = buf.inputs[0]
+	buf.inputs = append(buf.inputs[:0], buf.inputs[1:]...)
+	return buf.lastPlayed, true
+}
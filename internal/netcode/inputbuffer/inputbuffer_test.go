@@ -0,0 +1,131 @@
+package inputbuffer
+
+import (
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+// testClient builds packets the way the client does, sending its last few inputs every frame
+type testClient struct {
+	inputs []packs.ClientFrameInput
+}
+
+func (client *testClient) packet(frame uint16) []packs.ClientFrameInput {
+	client.inputs = append(client.inputs, packs.ClientFrameInput{
+		Frame: frame,
+		PlayerInput: ent.PlayerInput{
+			// alternate so we can tell inputs apart
+			IsHoldingLeft: frame%2 == 0,
+		},
+	})
+	if len(client.inputs) > netconst.MaxServerInputBuffer {
+		client.inputs = client.inputs[len(client.inputs)-netconst.MaxServerInputBuffer:]
+	}
+	return client.inputs
+}
+
+func TestBufferSteady(t *testing.T) {
+	var client testClient
+	var buf Buffer
+	// start near the wrap to check frames keep their order
+	frame := uint16(65500)
+	var lastFrame uint16
+	for i := 0; i < 200; i++ {
+		frame++
+		if frame == 0 {
+			// the client never uses frame 0
+			frame++
+		}
+		buf.Add(client.packet(frame))
+		input, isNew := buf.Next()
+		if i == 0 {
+			lastFrame = input.Frame
+			continue
+		}
+		if !isNew {
+			t.Fatalf("tick %d: expected a new input every tick when inputs arrive steadily", i)
+		}
+		if input.Frame == lastFrame {
+			t.Fatalf("tick %d: expected frame %d to only be simulated once", i, input.Frame)
+		}
+		lastFrame = input.Frame
+	}
+	if depth := buf.TargetDepth(); depth != minTargetDepth {
+		t.Errorf("expected target depth of %d without jitter but got %d", minTargetDepth, depth)
+	}
+}
+
+func TestBufferBunchedPackets(t *testing.T) {
+	var client testClient
+	var buf Buffer
+	var played []uint16
+	repeated := 0
+	for frame := uint16(1); frame < 300; frame++ {
+		packet := client.packet(frame)
+		// packets arrive 3 at a time
+		if frame%3 == 0 {
+			buf.Add(packet)
+		}
+		input, isNew := buf.Next()
+		if !isNew {
+			repeated++
+			continue
+		}
+		if len(played) > 0 && input.Frame != played[len(played)-1]+1 {
+			t.Fatalf("expected frame %d after %d", played[len(played)-1]+1, input.Frame)
+		}
+		played = append(played, input.Frame)
+	}
+	if depth := buf.TargetDepth(); depth < 2 {
+		t.Errorf("expected target depth to grow with jitter but got %d", depth)
+	}
+	// once the buffer adapts, every tick should have an input to simulate
+	if repeated > 30 {
+		t.Errorf("expected the buffer to adapt to bunched packets, but repeated the last input %d times", repeated)
+	}
+}
+
+func TestBufferRepeatsLastInputOnGap(t *testing.T) {
+	var client testClient
+	var buf Buffer
+	var frame uint16
+	for ; frame < 20; frame++ {
+		buf.Add(client.packet(frame + 1))
+		buf.Next()
+	}
+	last, _ := buf.Next()
+	for i := 0; i < 5; i++ {
+		input, isNew := buf.Next()
+		if isNew || input != last {
+			t.Fatalf("expected last input %v to be repeated but got %v (new: %v)", last, input, isNew)
+		}
+	}
+}
+
+func TestBufferSkipsAhead(t *testing.T) {
+	var buf Buffer
+	var inputs []packs.ClientFrameInput
+	for frame := uint16(1); frame <= 20; frame++ {
+		inputs = append(inputs, packs.ClientFrameInput{Frame: frame})
+	}
+	buf.Add(inputs)
+	input, isNew := buf.Next()
+	if !isNew {
+		t.Fatalf("expected new input")
+	}
+	if input.Frame != 20 {
+		t.Errorf("expected to skip ahead to the newest input but got frame %d", input.Frame)
+	}
+	if depth := buf.Depth(); depth != 0 {
+		t.Errorf("expected empty buffer but had %d inputs", depth)
+	}
+
+	// old inputs are ignored
+	buf.Add(inputs[:10])
+	if depth := buf.Depth(); depth != 0 {
+		t.Errorf("expected old inputs to be ignored but had %d inputs", depth)
+	}
+}
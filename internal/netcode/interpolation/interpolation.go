@@ -0,0 +1,219 @@
+// interpolation buffers world states from the server so that other players can be
+// drawn slightly in the past, moving smoothly between the two world states either
+// side of that time rather than snapping whenever a world state arrives.
+//
+// Source: https://gafferongames.com/post/snapshot_interpolation/
+package interpolation
+
+import (
+	"math"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+const (
+	// bufferSize is how many world states we hold onto, this needs to cover the
+	// maximum delay at the highest snapshot rate
+	bufferSize = 32
+
+	// minDelay and maxDelay limit how many ticks behind the server we draw other players
+	minDelay = 2
+	maxDelay = 24
+
+	// maxExtrapolation is how many ticks we keep moving players past the newest world
+	// state if the next one is late, after that they stop where they were heading
+	maxExtrapolation = 6
+
+	// maxDrift is how many ticks playback can be from where it should be before
+	// we jump straight there rather than speeding up or slowing down
+	maxDrift = 10
+
+	// catchUpRate is how much of the difference between where playback is and where it
+	// should be is made up each tick, maxCatchUp limits how much faster or slower than
+	// real-time playback can be so that corrections aren't noticeable
+	catchUpRate = 0.05
+	maxCatchUp  = 0.2
+
+	// smoothingFactor is how much a new world state moves the smoothed interval and jitter
+	smoothingFactor = 0.10
+)
+
+type snapshot struct {
+	// tick is the server tick of the world state, unwrapped so it can keep counting
+	// up past the uint16 the server sends
+	tick    int64
+	players []packs.PlayerState
+}
+
+// Buffer holds the latest world states and works out where other players should be drawn
+type Buffer struct {
+	// snapshots is a ring buffer ordered from oldest to newest, starting at snapshotStart
+	snapshots     [bufferSize]snapshot
+	snapshotStart int
+	snapshotCount int
+
+	// lastTick is the tick of the newest world state as the server sent it
+	lastTick uint16
+
+	// frame counts calls to Advance, this is the client's clock
+	frame int64
+	// playback is the server tick we're drawing other players at
+	playback float64
+
+	// interval is the smoothed number of ticks between world states
+	interval float64
+	// tickOffset is the smoothed difference between the server tick of a world state and
+	// the frame it arrived on, adding it to frame estimates the current server tick
+	tickOffset float64
+	// jitter is the smoothed amount world states arrive earlier or later than tickOffset says
+	jitter float64
+}
+
+// Add stores a world state, world states that are older than the newest one are ignored
+func (buf *Buffer) Add(serverTick uint16, players []packs.PlayerState) {
+	var tick int64
+	if buf.snapshotCount == 0 {
+		tick = int64(serverTick)
+	} else {
+		newest := buf.at(buf.snapshotCount - 1)
+		tick = newest.tick + int64(int16(serverTick-buf.lastTick))
+		if tick <= newest.tick {
+			return
+		}
+		interval := float64(tick - newest.tick)
+		if buf.snapshotCount == 1 {
+			buf.interval = interval
+		} else {
+			buf.interval += smoothingFactor * (interval - buf.interval)
+		}
+	}
+	buf.lastTick = serverTick
+
+	offset := float64(tick) - float64(buf.frame)
+	if buf.snapshotCount == 0 {
+		buf.tickOffset = offset
+		buf.playback = float64(tick) - buf.Delay()
+	} else {
+		buf.jitter += smoothingFactor * (math.Abs(offset-buf.tickOffset) - buf.jitter)
+		buf.tickOffset += smoothingFactor * (offset - buf.tickOffset)
+	}
+
+	// re-use the slot of the oldest world state if we're full
+	var s *snapshot
+	if buf.snapshotCount < len(buf.snapshots) {
+		s = buf.at(buf.snapshotCount)
+		buf.snapshotCount++
+	} else {
+		s = buf.at(0)
+		buf.snapshotStart = (buf.snapshotStart + 1) % len(buf.snapshots)
+	}
+	s.tick = tick
+	s.players = append(s.players[:0], players...)
+}
+
+func (buf *Buffer) at(i int) *snapshot {
+	return &buf.snapshots[(buf.snapshotStart+i)%len(buf.snapshots)]
+}
+
+// Delay is how many ticks behind the server other players are drawn. This is long enough
+// to always have the world state after the playback tick, plus some extra for world states
+// that arrive late, so it grows with a lower snapshot rate or a less stable connection.
+func (buf *Buffer) Delay() float64 {
+	delay := buf.interval + 2*buf.jitter + 1
+	if delay < minDelay {
+		return minDelay
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// Advance moves playback forward by a tick, this should be called once per frame
+func (buf *Buffer) Advance() {
+	buf.frame++
+	if buf.snapshotCount == 0 {
+		return
+	}
+	buf.playback++
+	target := float64(buf.frame) + buf.tickOffset - buf.Delay()
+	diff := target - buf.playback
+	if math.Abs(diff) > maxDrift {
+		buf.playback = target
+		return
+	}
+	buf.playback += math.Max(-maxCatchUp, math.Min(maxCatchUp, diff*catchUpRate))
+}
+
+// Playback returns the server tick other players are being drawn at
+func (buf *Buffer) Playback() float64 {
+	return buf.playback
+}
+
+// Sample returns the state of the player at the playback tick, ok is false if no
+// world state we have holds the player
+func (buf *Buffer) Sample(netID uint16) (state packs.PlayerState, ok bool) {
+	if buf.snapshotCount == 0 {
+		return packs.PlayerState{}, false
+	}
+	// find the world states either side of playback
+	next := 0
+	for next < buf.snapshotCount && float64(buf.at(next).tick) <= buf.playback {
+		next++
+	}
+	if next == 0 {
+		// playback is before every world state we have, use the oldest
+		return findPlayer(buf.at(0).players, netID)
+	}
+	from := buf.at(next - 1)
+	fromState, fromOk := findPlayer(from.players, netID)
+	if next == buf.snapshotCount {
+		// the next world state is late, keep moving the player the way they were going
+		if !fromOk || next < 2 {
+			return fromState, fromOk
+		}
+		prev := buf.at(next - 2)
+		prevState, ok := findPlayer(prev.players, netID)
+		if !ok {
+			return fromState, true
+		}
+		elapsed := math.Min(buf.playback-float64(from.tick), maxExtrapolation)
+		t := elapsed / float64(from.tick-prev.tick)
+		state = fromState
+		state.X = lerp(fromState.X, fromState.X+(fromState.X-prevState.X), t)
+		state.Y = lerp(fromState.Y, fromState.Y+(fromState.Y-prevState.Y), t)
+		return state, true
+	}
+	to := buf.at(next)
+	toState, toOk := findPlayer(to.players, netID)
+	if !fromOk || !toOk {
+		// the player joined or left between these world states
+		if fromOk {
+			return fromState, true
+		}
+		return toState, toOk
+	}
+	t := (buf.playback - float64(from.tick)) / float64(to.tick-from.tick)
+	state = fromState
+	state.X = lerp(fromState.X, toState.X, t)
+	state.Y = lerp(fromState.Y, toState.Y, t)
+	state.Hspeed = lerp(fromState.Hspeed, toState.Hspeed, t)
+	state.Vspeed = lerp(fromState.Vspeed, toState.Vspeed, t)
+	if t >= 0.5 {
+		state.DirLeft = toState.DirLeft
+	}
+	return state, true
+}
+
+func findPlayer(players []packs.PlayerState, netID uint16) (packs.PlayerState, bool) {
+	for _, state := range players {
+		if state.NetID == netID {
+			return state, true
+		}
+	}
+	return packs.PlayerState{}, false
+}
+
+func lerp(a, b float32, t float64) float32 {
+	return a + (b-a)*float32(t)
+}
@@ -0,0 +1,125 @@
+package interpolation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+// testServer sends a world state every snapshotInterval frames with a single player
+// moving right by 2 pixels every tick
+type testServer struct {
+	startTick        uint16
+	snapshotInterval int
+}
+
+func (server testServer) x(frame int) float32 {
+	return float32(frame) * 2
+}
+
+func (server testServer) send(buf *Buffer, frame int) {
+	buf.Add(server.startTick+uint16(frame), []packs.PlayerState{
+		{NetID: 1, X: server.x(frame), Y: 100},
+	})
+}
+
+func TestBufferInterpolates(t *testing.T) {
+	for _, startTick := range []uint16{0, 65500} {
+		server := testServer{startTick: startTick, snapshotInterval: 3}
+		var buf Buffer
+		prevX := float32(-1)
+		for frame := 0; frame < 300; frame++ {
+			if frame%server.snapshotInterval == 0 {
+				server.send(&buf, frame)
+			}
+			buf.Advance()
+			state, ok := buf.Sample(1)
+			if !ok {
+				t.Fatalf("start tick %d, frame %d: expected player", startTick, frame)
+			}
+			if state.X < prevX {
+				t.Fatalf("start tick %d, frame %d: expected player to keep moving right, went from %v to %v", startTick, frame, prevX, state.X)
+			}
+			prevX = state.X
+			if frame < 100 {
+				// give the delay time to settle
+				continue
+			}
+			// the player moves at a constant speed so interpolating should put them exactly where
+			// they were on the server at the playback tick
+			playbackFrame := buf.Playback() - float64(startTick)
+			if playbackFrame < 0 {
+				playbackFrame += 65536
+			}
+			if expected := playbackFrame * 2; math.Abs(float64(state.X)-expected) > 0.01 {
+				t.Fatalf("start tick %d, frame %d: expected x of %v but got %v", startTick, frame, expected, state.X)
+			}
+			lastSentFrame := frame - frame%server.snapshotInterval
+			if playbackFrame > float64(lastSentFrame) {
+				t.Fatalf("start tick %d, frame %d: expected to be drawing before the newest world state on frame %d, but was drawing frame %v", startTick, frame, lastSentFrame, playbackFrame)
+			}
+		}
+	}
+}
+
+func TestBufferExtrapolatesWhenLate(t *testing.T) {
+	server := testServer{snapshotInterval: 3}
+	var buf Buffer
+	frame := 0
+	for ; frame < 120; frame++ {
+		if frame%server.snapshotInterval == 0 {
+			server.send(&buf, frame)
+		}
+		buf.Advance()
+	}
+	lastSentFrame := frame - 1 - (frame-1)%server.snapshotInterval
+
+	// stop sending world states
+	var state packs.PlayerState
+	for i := 0; i < 60; i++ {
+		buf.Advance()
+		state, _ = buf.Sample(1)
+	}
+	expected := server.x(lastSentFrame + maxExtrapolation)
+	if state.X != expected {
+		t.Errorf("expected player to stop at x %v after extrapolating but got %v", expected, state.X)
+	}
+}
+
+func TestBufferDelayGrowsWithJitter(t *testing.T) {
+	server := testServer{snapshotInterval: 3}
+	var steady, jittery Buffer
+	for frame := 0; frame < 300; frame++ {
+		if frame%server.snapshotInterval == 0 {
+			server.send(&steady, frame)
+		}
+		// every other world state arrives 2 frames late
+		switch frame % 6 {
+		case 0:
+			server.send(&jittery, frame)
+		case 5:
+			server.send(&jittery, frame-2)
+		}
+		steady.Advance()
+		jittery.Advance()
+	}
+	if steady.Delay() >= jittery.Delay() {
+		t.Errorf("expected jitter to increase the delay, steady: %v, jittery: %v", steady.Delay(), jittery.Delay())
+	}
+	if delay := steady.Delay(); delay < float64(server.snapshotInterval) {
+		t.Errorf("expected delay of at least the snapshot interval but got %v", delay)
+	}
+}
+
+func TestBufferIgnoresOldWorldStates(t *testing.T) {
+	var buf Buffer
+	buf.Add(10, []packs.PlayerState{{NetID: 1, X: 10}})
+	buf.Add(5, []packs.PlayerState{{NetID: 1, X: 5}})
+	if buf.snapshotCount != 1 {
+		t.Errorf("expected older world state to be ignored")
+	}
+	if _, ok := buf.Sample(2); ok {
+		t.Errorf("expected missing player to not be found")
+	}
+}
@@ -1,8 +1,28 @@
 package netcode
 
-import "github.com/silbinarywolf/toy-webrtc-mmo/internal/world"
+import (
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/correction"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/world"
+)
 
 type Controller interface {
 	BeforeUpdate(world *world.World)
 	HasStartedOrConnected() bool
+	// Stats returns the network statistics of every connection
+	Stats() []ConnectionStats
+}
+
+// ConnectionStats are the network statistics of a single connection
+type ConnectionStats struct {
+	// ConnID is the player NetID the connection belongs to on the server, the client
+	// only has a connection to the server which is always 0
+	ConnID uint16
+	rtt.Stats
+	// InputBufferDepth is how many inputs the server is holding onto before simulating them and
+	// InputBufferTargetDepth is how many it's aiming to hold, these are always 0 on the client
+	InputBufferDepth       int
+	InputBufferTargetDepth int
+	// Prediction is how often and how badly the client mispredicted its own player, this is always 0 on the server
+	Prediction correction.Stats
 }
@@ -1,8 +1,42 @@
 package netconf
 
+import (
+	"io"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
+)
+
 type Options struct {
 	// PublicIP is used by the:
 	// Client: to connect to server
 	// Server: to setup the STUN server
 	PublicIP string
+	// Compress will compress the datagrams we send when it makes them smaller, this
+	// is mostly useful for the server as full world states compress well.
+	//
+	// Compressed datagrams are always read, so this doesn't need to match between the
+	// client and server.
+	Compress bool
+	// Capture will record every datagram sent and received to this writer if set,
+	// captures can be read with cmd/packdump
+	Capture io.Writer
+	// StatsLogInterval will log the latency, packet loss and bandwidth of every
+	// connection at this interval if set
+	StatsLogInterval time.Duration
+	// SnapshotRate is how many world states per second the server sends each client, this
+	// can be changed per client with server.Controller.SetSnapshotRate.
+	//
+	// If 0, a world state is sent every tick (netconst.TickRate)
+	SnapshotRate int
+	// MaxRewind is how far back in time the server will rewind the world to simulate a client's input
+	// on the tick they pressed it, this is capped at a second.
+	//
+	// Higher values are fairer to clients with slow connections but give people more room to abuse
+	// it, ie. a hacked client could say it jumped in the past after missing a jump.
+	//
+	// If 0, this is netconst.DefaultMaxRewind. If negative, inputs are simulated when they arrive.
+	MaxRewind time.Duration
+	// Clock is used for round trip times, captures and logging stats, if nil this is the system clock
+	Clock clock.Clock
 }
@@ -1,6 +1,8 @@
 // netconst are constants used by either the client and/server
 package netconst
 
+import "time"
+
 // MaxClientInputBuffer is how many frames of input we hold onto so that when we get world state from the
 // server, we can replay our inputs that haven't been simulated by the server yet.
 const MaxClientInputBuffer = 30
@@ -16,3 +18,19 @@ const MaxClientInputBuffer = 30
 // "clumsy" (packet loss/latency tool for Windows) at 350ms ping, we got a less janky user experience on the
 // client-side when we sent up only the last few frames but kept more for ourself
 const MaxServerInputBuffer = 10
+
+// ProtocolID salts the checksum at the start of every datagram so that we drop traffic
+// from other builds or services.
+//
+// Change this whenever packets change in a way that older builds can't read.
+const ProtocolID uint32 = 0x746f7906
+
+// TickRate is how many times per second the client and server update the game.
+//
+// Game logic moves a fixed amount every update, so if the client and server don't
+// tick at the same rate, their simulations drift apart.
+const TickRate = 60
+
+// DefaultMaxRewind is how far back in time the server will rewind the world to simulate
+// a client's input on the tick they pressed it, if not set with netconf.Options.MaxRewind
+const DefaultMaxRewind = 200 * time.Millisecond
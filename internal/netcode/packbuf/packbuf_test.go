@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -13,15 +15,36 @@ import (
 type sampleStructFormat struct {
 	Bool                 bool
 	Int                  int
+	Int8                 int8
+	Int16                int16
 	Int32                int32
 	Int64                int64
+	Uint                 uint
+	Uint8                uint8
 	Uint16               uint16
+	Uint32               uint32
+	Uint64               uint64
 	Float32              float32
 	Float64              float64
+	String               string
+	Named                sampleNamedInt
+	Bytes                []byte
+	Array                [3]int16
+	NestedSlice          [][]uint16
+	Map                  map[string]uint32
+	MapOfStruct          map[int8]SampleStructEmbeddedStruct
+	Pointer              *int32
+	PointerStruct        *SampleStructEmbeddedStruct
 	SliceOfPointerStruct []*sampleStructFormat
 	SampleStructEmbeddedStruct
 }
 
+type sampleNamedInt uint8
+
+func int32Pointer(v int32) *int32 {
+	return &v
+}
+
 type SampleStructEmbeddedStruct struct {
 	Int int
 }
@@ -31,13 +54,35 @@ var testData = []sampleStructFormat{
 		// test all zeroed data
 	},
 	{
-		Bool:    true,
-		Int:     9223372036854775807,
-		Int32:   2147483647,
-		Int64:   9223372036854775807,
-		Uint16:  65535,
-		Float32: 1.3333,
-		Float64: 1.3333,
+		Bool:        true,
+		Int:         9223372036854775807,
+		Int8:        -128,
+		Int16:       -32768,
+		Int32:       2147483647,
+		Int64:       9223372036854775807,
+		Uint:        18446744073709551615,
+		Uint8:       255,
+		Uint16:      65535,
+		Uint32:      4294967295,
+		Uint64:      18446744073709551615,
+		Float32:     1.3333,
+		Float64:     1.3333,
+		String:      "hello",
+		Named:       200,
+		Bytes:       []byte{1, 2, 3},
+		Array:       [3]int16{-1, 0, 1},
+		NestedSlice: [][]uint16{{1, 2}, {3}},
+		Map: map[string]uint32{
+			"a": 1,
+			"b": 2,
+			"c": 3,
+		},
+		MapOfStruct: map[int8]SampleStructEmbeddedStruct{
+			-1: {Int: 1},
+			1:  {Int: -1},
+		},
+		Pointer:       int32Pointer(-5),
+		PointerStruct: &SampleStructEmbeddedStruct{Int: 5},
 		SliceOfPointerStruct: []*sampleStructFormat{
 			{
 				Bool:    true,
@@ -113,6 +158,550 @@ func testReadWriteStruct(t logger) {
 	}
 }
 
+func TestReadWriteStructBits(t *testing.T) {
+	for _, testInput := range testData {
+		buf := &bytes.Buffer{}
+		bw := NewBitWriter(buf)
+		if err := Write(bw, &testInput); err != nil {
+			t.Fatalf("unable to Write: %+v", err)
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatalf("unable to Flush: %+v", err)
+		}
+		var testOutput sampleStructFormat
+		if err := Read(NewBitReader(bytes.NewReader(buf.Bytes())), &testOutput); err != nil {
+			t.Fatalf("unable to Read: %+v", err)
+		}
+		if diff := DeepEqual(testInput, testOutput); diff != nil {
+			t.Fatalf("Unable to write/read packet: %v", diff)
+		}
+	}
+}
+
+type sampleTaggedStructFormat struct {
+	Skipped   int                `packbuf:"-"`
+	Slice     []uint16           `packbuf:"maxsize:3"`
+	String    string             `packbuf:"maxsize:8"`
+	Ranged    int                `packbuf:"range:-10,10"`
+	Quantized float32            `packbuf:"quantize:-100,100,0.5"`
+	Floats    []float64          `packbuf:"maxsize:2;quantize:0,1,0.125"`
+	Array     [2]int8            `packbuf:"range:-1,1"`
+	Map       map[uint8]*float32 `packbuf:"maxsize:2;quantize:0,1,0.5"`
+}
+
+func float32Pointer(v float32) *float32 {
+	return &v
+}
+
+func TestReadWriteTaggedStruct(t *testing.T) {
+	testInput := sampleTaggedStructFormat{
+		Skipped:   5,
+		Slice:     []uint16{1, 2, 3},
+		String:    "12345678",
+		Ranged:    -10,
+		Quantized: -99.5,
+		Floats:    []float64{0.125, 1},
+		Array:     [2]int8{-1, 1},
+		Map: map[uint8]*float32{
+			1: float32Pointer(0.5),
+			2: nil,
+		},
+	}
+	for _, useBits := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		var w io.Writer = buf
+		bw := NewBitWriter(buf)
+		if useBits {
+			w = bw
+		}
+		if err := Write(w, &testInput); err != nil {
+			t.Fatalf("unable to Write: %+v", err)
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatalf("unable to Flush: %+v", err)
+		}
+		var r io.Reader = bytes.NewReader(buf.Bytes())
+		if useBits {
+			r = NewBitReader(r)
+		}
+		var testOutput sampleTaggedStructFormat
+		if err := Read(r, &testOutput); err != nil {
+			t.Fatalf("unable to Read: %+v", err)
+		}
+		expected := testInput
+		expected.Skipped = 0
+		if diff := DeepEqual(expected, testOutput); diff != nil {
+			t.Fatalf("Unable to write/read tagged struct (bits: %v): %v", useBits, diff)
+		}
+	}
+}
+
+func TestTaggedStructLimits(t *testing.T) {
+	badInputs := []sampleTaggedStructFormat{
+		{Slice: []uint16{1, 2, 3, 4}},
+		{String: "123456789"},
+		{Ranged: 11},
+		{Ranged: -11},
+		{Array: [2]int8{0, 2}},
+		{Map: map[uint8]*float32{1: nil, 2: nil, 3: nil}},
+	}
+	for _, testInput := range badInputs {
+		if err := Write(&bytes.Buffer{}, &testInput); err == nil {
+			t.Errorf("expected Write to fail on limits: %+v", testInput)
+		}
+	}
+
+	// Floats outside of the quantize range can't be written
+	for _, quantized := range []float32{1000, -1000} {
+		err := Write(&bytes.Buffer{}, &sampleTaggedStructFormat{Quantized: quantized})
+		if !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("expected quantized value of %v to be out of range but got: %v", quantized, err)
+		}
+	}
+
+	// Reading is limited too, even if the writer didn't respect the limit.
+	// "maxsize:5" is written with 3-bits so a size of 6 can still be sent
+	{
+		buf := &bytes.Buffer{}
+		bw := NewBitWriter(buf)
+		if err := bw.WriteBits(6, 3); err != nil {
+			t.Fatalf("unable to WriteBits: %+v", err)
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatalf("unable to Flush: %+v", err)
+		}
+		var testOutput struct {
+			Slice []uint16 `packbuf:"maxsize:5"`
+		}
+		if err := Read(NewBitReader(bytes.NewReader(buf.Bytes())), &testOutput); err == nil {
+			t.Errorf("expected Read to fail with slice larger than maxsize")
+		}
+	}
+}
+
+func TestInvalidTag(t *testing.T) {
+	var testInput struct {
+		Value float32 `packbuf:"range:0,10"`
+	}
+	err := Write(&bytes.Buffer{}, &testInput)
+	if _, ok := err.(*TagError); !ok {
+		t.Fatalf("expected TagError but got: %v", err)
+	}
+}
+
+type hostileTestCase struct {
+	Name  string
+	Data  []byte
+	Error error
+}
+
+func TestReadHostileInput(t *testing.T) {
+	testCases := []hostileTestCase{
+		{
+			Name:  "negative slice length",
+			Data:  []byte{0xFF, 0xFF, 0xFF, 0xFF},
+			Error: ErrNegativeLength,
+		},
+		{
+			Name:  "slice length larger than budget",
+			Data:  []byte{0xFF, 0xFF, 0xFF, 0x7F},
+			Error: ErrBudgetExceeded,
+		},
+		{
+			Name:  "slice length larger than data",
+			Data:  []byte{0x02, 0x00, 0x00, 0x00, 0x01},
+			Error: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, test := range testCases {
+		var testOutput struct {
+			Slice []uint8
+		}
+		err := Read(bytes.NewReader(test.Data), &testOutput)
+		if !errors.Is(err, test.Error) {
+			t.Errorf("%s: expected error %v but got: %v", test.Name, test.Error, err)
+		}
+	}
+
+	// short string
+	{
+		var testOutput struct {
+			String string
+		}
+		err := Read(bytes.NewReader([]byte{0x05, 0x00, 'a', 'b'}), &testOutput)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected error %v but got: %v", io.ErrUnexpectedEOF, err)
+		}
+	}
+
+	// budget is shared by nested slices
+	{
+		buf := &bytes.Buffer{}
+		testInput := sampleStructFormat{
+			SliceOfPointerStruct: make([]*sampleStructFormat, 10),
+		}
+		for i := range testInput.SliceOfPointerStruct {
+			testInput.SliceOfPointerStruct[i] = &sampleStructFormat{}
+		}
+		if err := Write(buf, &testInput); err != nil {
+			t.Fatalf("unable to Write: %+v", err)
+		}
+		var testOutput sampleStructFormat
+		err := ReadWithLimits(bytes.NewReader(buf.Bytes()), &testOutput, Limits{
+			MaxBytes:    1 << 20,
+			MaxElements: 5,
+		})
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("expected error %v but got: %v", ErrBudgetExceeded, err)
+		}
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != "SliceOfPointerStruct" {
+			t.Errorf("expected FieldError for SliceOfPointerStruct but got: %v", err)
+		}
+	}
+}
+
+func TestMapIsWrittenInKeyOrder(t *testing.T) {
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		// maps are iterated in a random order, so build a new one each time
+		var testInput struct {
+			Map map[int16]string
+		}
+		testInput.Map = make(map[int16]string)
+		for key := int16(-50); key < 50; key++ {
+			testInput.Map[key] = strconv.Itoa(int(key))
+		}
+		buf := &bytes.Buffer{}
+		if err := Write(buf, &testInput); err != nil {
+			t.Fatalf("unable to Write: %+v", err)
+		}
+		if expected == nil {
+			expected = buf.Bytes()
+			continue
+		}
+		if !bytes.Equal(expected, buf.Bytes()) {
+			t.Fatalf("expected map to be written the same each time")
+		}
+	}
+}
+
+func TestReadDuplicateMapKey(t *testing.T) {
+	// map with 2 entries, both with the key 1
+	data := []byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x05, 0x01, 0x06}
+	var testOutput struct {
+		Map map[uint8]uint8
+	}
+	err := Read(bytes.NewReader(data), &testOutput)
+	if !errors.Is(err, ErrDuplicateMapKey) {
+		t.Fatalf("expected error %v but got: %v", ErrDuplicateMapKey, err)
+	}
+}
+
+func TestUnsupportedMapKey(t *testing.T) {
+	testInput := struct {
+		Map map[bool]uint8
+	}{
+		Map: map[bool]uint8{true: 1},
+	}
+	if err := Write(&bytes.Buffer{}, &testInput); err == nil {
+		t.Fatalf("expected error when writing map with bool keys")
+	}
+}
+
+// sampleAngle is sent as a single byte
+type sampleAngle float32
+
+func (angle sampleAngle) MarshalPackbuf(e *Encoder) error {
+	return e.WriteUint8(uint8(angle / 360 * 256))
+}
+
+func (angle *sampleAngle) UnmarshalPackbuf(d *Decoder) error {
+	v, err := d.ReadUint8()
+	if err != nil {
+		return err
+	}
+	*angle = sampleAngle(v) * 360 / 256
+	return nil
+}
+
+// sampleFlags is sent as a single byte with MarshalBinary
+type sampleFlags struct {
+	isOnGround  bool
+	isCrouching bool
+}
+
+func (flags sampleFlags) MarshalBinary() ([]byte, error) {
+	var b byte
+	if flags.isOnGround {
+		b |= 1
+	}
+	if flags.isCrouching {
+		b |= 2
+	}
+	return []byte{b}, nil
+}
+
+func (flags *sampleFlags) UnmarshalBinary(data []byte) error {
+	if len(data) != 1 {
+		return errors.New("expected 1 byte for flags")
+	}
+	flags.isOnGround = data[0]&1 != 0
+	flags.isCrouching = data[0]&2 != 0
+	return nil
+}
+
+type sampleHookStructFormat struct {
+	Angle        sampleAngle
+	Angles       []sampleAngle
+	AngleMap     map[uint8]sampleAngle
+	AnglePointer *sampleAngle
+	Flags        sampleFlags
+	FlagsMap     map[uint8]sampleFlags
+}
+
+func TestReadWriteHooks(t *testing.T) {
+	angle := sampleAngle(90)
+	testInput := sampleHookStructFormat{
+		Angle:        180,
+		Angles:       []sampleAngle{0, 45, 270},
+		AngleMap:     map[uint8]sampleAngle{1: 90, 2: 315},
+		AnglePointer: &angle,
+		Flags:        sampleFlags{isOnGround: true},
+		FlagsMap: map[uint8]sampleFlags{
+			1: {isCrouching: true},
+			2: {isOnGround: true, isCrouching: true},
+		},
+	}
+	for _, useBits := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		var w io.Writer = buf
+		bw := NewBitWriter(buf)
+		if useBits {
+			w = bw
+		}
+		if err := Write(w, &testInput); err != nil {
+			t.Fatalf("unable to Write: %+v", err)
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatalf("unable to Flush: %+v", err)
+		}
+		var r io.Reader = bytes.NewReader(buf.Bytes())
+		if useBits {
+			r = NewBitReader(r)
+		}
+		var testOutput sampleHookStructFormat
+		if err := Read(r, &testOutput); err != nil {
+			t.Fatalf("unable to Read: %+v", err)
+		}
+		if !reflect.DeepEqual(testInput, testOutput) {
+			t.Fatalf("Unable to write/read struct with hooks (bits: %v):\n%+v\n%+v", useBits, testInput, testOutput)
+		}
+	}
+}
+
+func TestReadWriteTopLevelHook(t *testing.T) {
+	testInput := sampleFlags{isOnGround: true, isCrouching: true}
+	buf := &bytes.Buffer{}
+	if err := Write(buf, &testInput); err != nil {
+		t.Fatalf("unable to Write: %+v", err)
+	}
+	// 4 byte length then the flags
+	if expected := []byte{0x01, 0x00, 0x00, 0x00, 0x03}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected %x but got %x", expected, buf.Bytes())
+	}
+	var testOutput sampleFlags
+	if err := Read(bytes.NewReader(buf.Bytes()), &testOutput); err != nil {
+		t.Fatalf("unable to Read: %+v", err)
+	}
+	if testInput != testOutput {
+		t.Fatalf("expected %+v but got %+v", testInput, testOutput)
+	}
+}
+
+func TestHookWithTag(t *testing.T) {
+	var testInput struct {
+		Angles []sampleAngle `packbuf:"range:0,10"`
+	}
+	err := Write(&bytes.Buffer{}, &testInput)
+	if _, ok := err.(*TagError); !ok {
+		t.Fatalf("expected TagError but got: %v", err)
+	}
+}
+
+// TestReadReusesValues tests that reading into a value that already has slices,
+// maps and pointers set re-uses them and doesn't keep any old data
+func TestReadReusesValues(t *testing.T) {
+	type reuseStruct struct {
+		Slice   []uint16
+		Map     map[uint8]uint8
+		Pointer *int32
+		String  string
+	}
+	var testOutput reuseStruct
+	for _, testInput := range []reuseStruct{
+		{Slice: []uint16{1, 2, 3}, Map: map[uint8]uint8{1: 1, 2: 2}, Pointer: int32Pointer(5), String: "hello"},
+		{Slice: []uint16{4}, Map: map[uint8]uint8{3: 3}, Pointer: int32Pointer(6)},
+		{Slice: []uint16{}, Map: map[uint8]uint8{}},
+	} {
+		buf := &bytes.Buffer{}
+		if err := Write(buf, &testInput); err != nil {
+			t.Fatalf("unable to Write: %+v", err)
+		}
+		prevSlice, prevPointer := testOutput.Slice, testOutput.Pointer
+		if err := Read(bytes.NewReader(buf.Bytes()), &testOutput); err != nil {
+			t.Fatalf("unable to Read: %+v", err)
+		}
+		if !reflect.DeepEqual(testInput, testOutput) {
+			t.Fatalf("expected:\n%+v\nbut got:\n%+v", testInput, testOutput)
+		}
+		if prevSlice != nil && &prevSlice[:1][0] != &testOutput.Slice[:1][0] {
+			t.Errorf("expected slice to be re-used")
+		}
+		if prevPointer != nil && testOutput.Pointer != nil && prevPointer != testOutput.Pointer {
+			t.Errorf("expected pointer to be re-used")
+		}
+	}
+}
+
+// TestAppend tests that Append writes the same bytes as a BitWriter onto the end of the slice
+func TestAppend(t *testing.T) {
+	testInput := sampleTaggedStructFormat{
+		Slice:  []uint16{1, 2, 3},
+		String: "123",
+		Ranged: 5,
+	}
+	buf := &bytes.Buffer{}
+	bw := NewBitWriter(buf)
+	if err := Write(bw, &testInput); err != nil {
+		t.Fatalf("unable to Write: %+v", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unable to Flush: %+v", err)
+	}
+	data, err := Append([]byte{0xFF}, &testInput)
+	if err != nil {
+		t.Fatalf("unable to Append: %+v", err)
+	}
+	if data[0] != 0xFF || !bytes.Equal(data[1:], buf.Bytes()) {
+		t.Fatalf("Append wrote:\n%x\nbut BitWriter wrote:\n%x", data, buf.Bytes())
+	}
+}
+
+func TestDescribeStruct(t *testing.T) {
+	var schema Schema
+	name, err := schema.DescribeStruct(reflect.TypeOf(&sampleTaggedStructFormat{}))
+	if err != nil {
+		t.Fatalf("unable to describe struct: %v", err)
+	}
+	structSchema := schema.Struct(name)
+	if structSchema == nil {
+		t.Fatalf("missing struct %s in schema", name)
+	}
+	fields := make(map[string]*TypeSchema)
+	for _, field := range structSchema.Fields {
+		fields[field.Name] = field.Type
+	}
+	if _, ok := fields["Skipped"]; ok {
+		t.Errorf("expected skipped field to not be described")
+	}
+	if slice := fields["Slice"]; slice.Kind != KindSlice || slice.MaxSize == nil || slice.MaxSize.Bits != 2 || slice.Elem.Kind != KindUint16 {
+		t.Errorf("unexpected schema for Slice: %+v", slice)
+	}
+	if ranged := fields["Ranged"]; ranged.Kind != KindInt64 || ranged.Range == nil || ranged.Range.Bits != 5 {
+		t.Errorf("unexpected schema for Ranged: %+v", ranged)
+	}
+	if quantized := fields["Quantized"]; quantized.Quantize == nil || quantized.Quantize.Bits != 9 {
+		t.Errorf("unexpected schema for Quantized: %+v", quantized)
+	}
+	if array := fields["Array"]; array.Kind != KindArray || array.Len != 2 || array.Elem.Range == nil {
+		t.Errorf("expected range to apply to each element of Array: %+v", array)
+	}
+	if m := fields["Map"]; m.Kind != KindMap || m.Key.Kind != KindUint8 || m.Elem.Kind != KindPointer || m.Elem.Elem.Quantize == nil || m.Elem.MaxSize != nil {
+		t.Errorf("unexpected schema for Map: %+v", m)
+	}
+
+	// structs that reference themselves are only described once
+	name, err = schema.DescribeStruct(reflect.TypeOf(sampleStructFormat{}))
+	if err != nil {
+		t.Fatalf("unable to describe struct: %v", err)
+	}
+	var count int
+	for _, structSchema := range schema.Structs {
+		if structSchema.Name == name {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected %s to be described once but got %d", name, count)
+	}
+
+	name, err = schema.DescribeStruct(reflect.TypeOf(sampleHookStructFormat{}))
+	if err != nil {
+		t.Fatalf("unable to describe struct: %v", err)
+	}
+	for _, field := range schema.Struct(name).Fields {
+		switch field.Name {
+		case "Angle":
+			if field.Type.Kind != KindCustom {
+				t.Errorf("expected MarshalPackbuf type to be custom but got %s", field.Type.Kind)
+			}
+		case "Flags":
+			if field.Type.Kind != KindBinary {
+				t.Errorf("expected MarshalBinary type to be binary but got %s", field.Type.Kind)
+			}
+		}
+	}
+}
+
+type bitTestCase struct {
+	Value uint64
+	Bits  uint
+}
+
+func TestBitWriterReader(t *testing.T) {
+	testCases := []bitTestCase{
+		{Value: 1, Bits: 1},
+		{Value: 0, Bits: 1},
+		{Value: 5, Bits: 3},
+		{Value: 1023, Bits: 10},
+		{Value: 0, Bits: 0},
+		{Value: 65535, Bits: 16},
+		{Value: 1<<33 + 7, Bits: 34},
+		{Value: 18446744073709551615, Bits: 64},
+		{Value: 1, Bits: 1},
+	}
+	buf := &bytes.Buffer{}
+	bw := NewBitWriter(buf)
+	totalBits := uint(0)
+	for _, test := range testCases {
+		if err := bw.WriteBits(test.Value, test.Bits); err != nil {
+			t.Fatalf("unable to WriteBits: %v", err)
+		}
+		totalBits += test.Bits
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unable to Flush: %v", err)
+	}
+	if expectedLen := int(totalBits+7) / 8; buf.Len() != expectedLen {
+		t.Fatalf("expected %d bytes but got %d", expectedLen, buf.Len())
+	}
+	br := NewBitReader(bytes.NewReader(buf.Bytes()))
+	for _, test := range testCases {
+		value, err := br.ReadBits(test.Bits)
+		if err != nil {
+			t.Fatalf("unable to ReadBits: %v", err)
+		}
+		if value != test.Value {
+			t.Errorf("expected %d but got %d when reading %d bits", test.Value, value, test.Bits)
+		}
+	}
+	br.Align()
+	if _, err := br.ReadBits(1); err != io.EOF {
+		t.Fatalf("expected io.EOF after reading all bits but got: %v", err)
+	}
+}
+
 // Below is a copy-paste of
 // https://github.com/go-test/deep/commit/8ed16920c079d9f721f068f915e1539e9ef3236c
 
@@ -0,0 +1,219 @@
+package packbuf
+
+import (
+	"errors"
+	"io"
+)
+
+// BitWriter packs values into a stream of bits rather than bytes.
+//
+// Passing a BitWriter to Write will pack bools as a single bit and every other value
+// as its usual byte size, but unaligned to byte boundaries.
+//
+// Bits are packed starting from the least significant bit of each byte, as done in
+// https://gafferongames.com/post/reading_and_writing_packets/
+type BitWriter struct {
+	w           io.Writer
+	scratch     uint64
+	scratchBits uint
+	out         []byte
+}
+
+// NewBitWriter returns a BitWriter that will write to w when Flush is called
+func NewBitWriter(w io.Writer) *BitWriter {
+	bw := &BitWriter{}
+	bw.Reset(w)
+	return bw
+}
+
+// Reset discards any unflushed data and will write to w on the next Flush
+func (bw *BitWriter) Reset(w io.Writer) {
+	bw.w = w
+	bw.scratch = 0
+	bw.scratchBits = 0
+	bw.out = bw.out[:0]
+}
+
+// WriteBits writes the lowest number of bits of value, bits can be between 0 and 64
+func (bw *BitWriter) WriteBits(value uint64, bits uint) error {
+	if bits > 64 {
+		return errors.New("cannot write more than 64 bits at a time")
+	}
+	if bits > 32 {
+		// split so that scratch can never overflow
+		bw.writeBits(value&0xFFFFFFFF, 32)
+		value >>= 32
+		bits -= 32
+	}
+	bw.writeBits(value, bits)
+	return nil
+}
+
+func (bw *BitWriter) writeBits(value uint64, bits uint) {
+	if bits < 64 {
+		value &= (1 << bits) - 1
+	}
+	bw.scratch |= value << bw.scratchBits
+	bw.scratchBits += bits
+	for bw.scratchBits >= 8 {
+		bw.out = append(bw.out, byte(bw.scratch))
+		bw.scratch >>= 8
+		bw.scratchBits -= 8
+	}
+}
+
+// WriteBool writes a bool as a single bit
+func (bw *BitWriter) WriteBool(v bool) error {
+	var bit uint64
+	if v {
+		bit = 1
+	}
+	bw.writeBits(bit, 1)
+	return nil
+}
+
+// Write writes every byte as 8 bits, this allows BitWriter to be used as an io.Writer
+func (bw *BitWriter) Write(p []byte) (int, error) {
+	if bw.scratchBits == 0 {
+		bw.out = append(bw.out, p...)
+		return len(p), nil
+	}
+	for _, b := range p {
+		bw.writeBits(uint64(b), 8)
+	}
+	return len(p), nil
+}
+
+// Flush pads any remaining bits to the next byte boundary with zeroes and writes
+// the packed data to the underlying writer
+func (bw *BitWriter) Flush() error {
+	bw.pad()
+	if len(bw.out) == 0 {
+		return nil
+	}
+	_, err := bw.w.Write(bw.out)
+	bw.out = bw.out[:0]
+	return err
+}
+
+// pad pads any remaining bits to the next byte boundary with zeroes
+func (bw *BitWriter) pad() {
+	if bw.scratchBits > 0 {
+		bw.out = append(bw.out, byte(bw.scratch))
+		bw.scratch = 0
+		bw.scratchBits = 0
+	}
+}
+
+// BitReader unpacks values written by a BitWriter
+type BitReader struct {
+	r           io.Reader
+	scratch     uint64
+	scratchBits uint
+	byteBuf     [1]byte
+}
+
+// NewBitReader returns a BitReader that reads bytes from r as they're needed
+func NewBitReader(r io.Reader) *BitReader {
+	br := &BitReader{}
+	br.Reset(r)
+	return br
+}
+
+// Reset discards any unread bits and will read from r
+func (br *BitReader) Reset(r io.Reader) {
+	br.r = r
+	br.scratch = 0
+	br.scratchBits = 0
+}
+
+// ReadBits reads the given number of bits, bits can be between 0 and 64
+func (br *BitReader) ReadBits(bits uint) (uint64, error) {
+	if bits > 64 {
+		return 0, errors.New("cannot read more than 64 bits at a time")
+	}
+	if bits > 32 {
+		low, err := br.readBits(32)
+		if err != nil {
+			return 0, err
+		}
+		high, err := br.readBits(bits - 32)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		return low | (high << 32), nil
+	}
+	return br.readBits(bits)
+}
+
+func (br *BitReader) readBits(bits uint) (uint64, error) {
+	hasReadByte := false
+	for br.scratchBits < bits {
+		b, err := br.readByte()
+		if err != nil {
+			if err == io.EOF && (hasReadByte || br.scratchBits > 0) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		hasReadByte = true
+		br.scratch |= uint64(b) << br.scratchBits
+		br.scratchBits += 8
+	}
+	value := br.scratch
+	if bits < 64 {
+		value &= (1 << bits) - 1
+	}
+	br.scratch >>= bits
+	br.scratchBits -= bits
+	return value, nil
+}
+
+func (br *BitReader) readByte() (byte, error) {
+	if r, ok := br.r.(io.ByteReader); ok {
+		return r.ReadByte()
+	}
+	if _, err := io.ReadFull(br.r, br.byteBuf[:]); err != nil {
+		return 0, err
+	}
+	return br.byteBuf[0], nil
+}
+
+// ReadBool reads a single bit
+func (br *BitReader) ReadBool() (bool, error) {
+	bit, err := br.readBits(1)
+	if err != nil {
+		return false, err
+	}
+	return bit != 0, nil
+}
+
+// Read reads 8 bits per byte, this allows BitReader to be used as an io.Reader
+func (br *BitReader) Read(p []byte) (int, error) {
+	if br.scratchBits == 0 {
+		return br.r.Read(p)
+	}
+	for i := range p {
+		b, err := br.readBits(8)
+		if err != nil {
+			if i > 0 && err == io.ErrUnexpectedEOF {
+				// we read some bytes, so let the next call report the error
+				return i, nil
+			}
+			return i, err
+		}
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+// Align discards any bits left over from the last byte read, so the next read
+// starts on a byte boundary. This should be called after reading everything a
+// BitWriter wrote before calling Flush.
+func (br *BitReader) Align() {
+	br.scratch = 0
+	br.scratchBits = 0
+}
@@ -0,0 +1,255 @@
+package packbuf
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// PackbufUnmarshaler is implemented by types that can read themselves, ie. code generated
+// by cmd/packbuf-gen or hand-written encodings. Read will use this instead of reflection
+// if it's implemented by the data or any of its fields.
+type PackbufUnmarshaler interface {
+	UnmarshalPackbuf(d *Decoder) error
+}
+
+// Decoder reads values written by an Encoder
+//
+// It also tracks how much of its Limits have been used so that code reading slices
+// and strings can call Allocate before allocating memory.
+type Decoder struct {
+	r       io.Reader
+	br      *BitReader
+	scratch [8]byte
+
+	remainingBytes    int
+	remainingElements int
+}
+
+// NewDecoder returns a Decoder that reads from r with the given limits
+func NewDecoder(r io.Reader, limits Limits) *Decoder {
+	d := &Decoder{}
+	d.Reset(r, limits)
+	return d
+}
+
+// Reset will make the Decoder read from r and reset the budget to limits
+func (d *Decoder) Reset(r io.Reader, limits Limits) {
+	d.r = r
+	d.br, _ = r.(*BitReader)
+	d.remainingBytes = limits.MaxBytes
+	d.remainingElements = limits.MaxElements
+}
+
+// Allocate will use up the budget for count elements of the given size, this should
+// be called before allocating a slice
+func (d *Decoder) Allocate(count int, size uintptr) error {
+	if count < 0 {
+		return ErrNegativeLength
+	}
+	if count > d.remainingElements {
+		return fmt.Errorf("%w: %d elements exceeds the remaining %d", ErrBudgetExceeded, count, d.remainingElements)
+	}
+	if size > 0 && count > d.remainingBytes/int(size) {
+		return fmt.Errorf("%w: %d bytes exceeds the remaining %d", ErrBudgetExceeded, uintptr(count)*size, d.remainingBytes)
+	}
+	d.remainingElements -= count
+	d.remainingBytes -= count * int(size)
+	return nil
+}
+
+// AllocateBytes will use up the budget for a string of n bytes
+func (d *Decoder) AllocateBytes(n int) error {
+	if n > d.remainingBytes {
+		return fmt.Errorf("%w: %d bytes exceeds the remaining %d", ErrBudgetExceeded, n, d.remainingBytes)
+	}
+	d.remainingBytes -= n
+	return nil
+}
+
+// readUint reads a value written by Encoder.writeUint
+func (d *Decoder) readUint(bitCount uint) (uint64, error) {
+	if d.br != nil {
+		return d.br.ReadBits(bitCount)
+	}
+	bs := d.scratch[:(bitCount+7)/8]
+	if _, err := io.ReadFull(d.r, bs); err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i, b := range bs {
+		v |= uint64(b) << (8 * i)
+	}
+	return v, nil
+}
+
+func (d *Decoder) ReadBool() (bool, error) {
+	if d.br != nil {
+		return d.br.ReadBool()
+	}
+	v, err := d.readUint(8)
+	return v != 0, err
+}
+
+func (d *Decoder) ReadUint8() (uint8, error) {
+	v, err := d.readUint(8)
+	return uint8(v), err
+}
+
+func (d *Decoder) ReadUint16() (uint16, error) {
+	v, err := d.readUint(16)
+	return uint16(v), err
+}
+
+func (d *Decoder) ReadUint32() (uint32, error) {
+	v, err := d.readUint(32)
+	return uint32(v), err
+}
+
+func (d *Decoder) ReadUint64() (uint64, error) {
+	return d.readUint(64)
+}
+
+// ReadUint reads a uint written as 64-bits
+func (d *Decoder) ReadUint() (uint, error) {
+	v, err := d.readUint(64)
+	return uint(v), err
+}
+
+// ReadInt reads an int written as 64-bits
+func (d *Decoder) ReadInt() (int, error) {
+	v, err := d.readUint(64)
+	return int(v), err
+}
+
+func (d *Decoder) ReadInt8() (int8, error) {
+	v, err := d.readUint(8)
+	return int8(v), err
+}
+
+func (d *Decoder) ReadInt16() (int16, error) {
+	v, err := d.readUint(16)
+	return int16(v), err
+}
+
+func (d *Decoder) ReadInt32() (int32, error) {
+	v, err := d.readUint(32)
+	return int32(v), err
+}
+
+func (d *Decoder) ReadInt64() (int64, error) {
+	v, err := d.readUint(64)
+	return int64(v), err
+}
+
+func (d *Decoder) ReadFloat32() (float32, error) {
+	v, err := d.readUint(32)
+	return math.Float32frombits(uint32(v)), err
+}
+
+func (d *Decoder) ReadFloat64() (float64, error) {
+	v, err := d.readUint(64)
+	return math.Float64frombits(v), err
+}
+
+// ReadBytes fills v, the budget should be allocated before calling this
+func (d *Decoder) ReadBytes(v []byte) error {
+	_, err := io.ReadFull(d.r, v)
+	return err
+}
+
+// ReadBinary reads data written by Encoder.WriteBinary and passes it to UnmarshalBinary
+func (d *Decoder) ReadBinary(m encoding.BinaryUnmarshaler) error {
+	n, err := d.ReadLength()
+	if err != nil {
+		return err
+	}
+	if err := d.AllocateBytes(n); err != nil {
+		return err
+	}
+	data := make([]byte, n)
+	if err := d.ReadBytes(data); err != nil {
+		return err
+	}
+	return m.UnmarshalBinary(data)
+}
+
+// ReadLength reads the length of a slice that has no maxsize limit
+func (d *Decoder) ReadLength() (int, error) {
+	n, err := d.ReadInt32()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, ErrNegativeLength
+	}
+	return int(n), nil
+}
+
+// ReadString reads a string written by Encoder.WriteString
+func (d *Decoder) ReadString() (string, error) {
+	n, err := d.ReadUint16()
+	if err != nil {
+		return "", err
+	}
+	return d.ReadStringData(int(n))
+}
+
+// ReadStringData reads a string of n bytes
+func (d *Decoder) ReadStringData(n int) (string, error) {
+	if n > maxStringSize {
+		return "", errors.New("cannot read string larger than " + strconv.Itoa(maxStringSize))
+	}
+	if n == 0 {
+		return "", nil
+	}
+	if err := d.AllocateBytes(n); err != nil {
+		return "", err
+	}
+	stringData := make([]byte, n)
+	if _, err := io.ReadFull(d.r, stringData); err != nil {
+		return "", err
+	}
+	return string(stringData), nil
+}
+
+// ReadSize reads the length of a slice or string that has a maxsize limit
+func (d *Decoder) ReadSize(maxSize int) (int, error) {
+	n, err := d.readUint(uint(bits.Len64(uint64(maxSize))))
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(maxSize) {
+		return 0, fmt.Errorf("%w: size of %d exceeds maxsize of %d", ErrMaxSizeExceeded, n, maxSize)
+	}
+	return int(n), nil
+}
+
+// ReadRanged reads an integer that has a range limit
+func (d *Decoder) ReadRanged(min, max int64) (int64, error) {
+	q, err := d.readUint(uint(bits.Len64(uint64(max - min))))
+	if err != nil {
+		return 0, err
+	}
+	if q > uint64(max-min) {
+		return 0, fmt.Errorf("%w: %d is outside of %d to %d", ErrOutOfRange, int64(q)+min, min, max)
+	}
+	return min + int64(q), nil
+}
+
+// ReadQuantized reads a float written by Encoder.WriteQuantized
+func (d *Decoder) ReadQuantized(min, max, precision float64) (float64, error) {
+	steps := quantizeSteps(min, max, precision)
+	q, err := d.readUint(uint(bits.Len64(steps)))
+	if err != nil {
+		return 0, err
+	}
+	if q > steps {
+		return 0, fmt.Errorf("%w: quantized value %d is larger than the maximum of %d", ErrOutOfRange, q, steps)
+	}
+	return min + float64(q)*precision, nil
+}
@@ -0,0 +1,210 @@
+package packbuf
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// PackbufMarshaler is implemented by types that can write themselves, ie. code generated
+// by cmd/packbuf-gen or hand-written encodings. Write will use this instead of reflection
+// if it's implemented by the data or any of its fields.
+type PackbufMarshaler interface {
+	MarshalPackbuf(e *Encoder) error
+}
+
+// Encoder writes values in the same format that Write uses
+//
+// If the underlying writer is a *BitWriter, bools are written as a single bit and
+// values with limits are written with the least amount of bits needed.
+type Encoder struct {
+	w       io.Writer
+	bw      *BitWriter
+	scratch [8]byte
+}
+
+// NewEncoder returns an Encoder that writes to w
+func NewEncoder(w io.Writer) *Encoder {
+	e := &Encoder{}
+	e.Reset(w)
+	return e
+}
+
+// Reset will make the Encoder write to w
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.bw, _ = w.(*BitWriter)
+}
+
+// writeUint writes the lowest number of bits of v, if we're not writing to a
+// BitWriter then its written as the least amount of bytes that can hold those bits
+func (e *Encoder) writeUint(v uint64, bitCount uint) error {
+	if e.bw != nil {
+		return e.bw.WriteBits(v, bitCount)
+	}
+	bs := e.scratch[:(bitCount+7)/8]
+	for i := range bs {
+		bs[i] = byte(v >> (8 * i))
+	}
+	_, err := e.w.Write(bs)
+	return err
+}
+
+func (e *Encoder) WriteBool(v bool) error {
+	if e.bw != nil {
+		// bools only need a single bit when bit packing
+		return e.bw.WriteBool(v)
+	}
+	var b uint64
+	if v {
+		b = 1
+	}
+	return e.writeUint(b, 8)
+}
+
+func (e *Encoder) WriteUint8(v uint8) error {
+	return e.writeUint(uint64(v), 8)
+}
+
+func (e *Encoder) WriteUint16(v uint16) error {
+	return e.writeUint(uint64(v), 16)
+}
+
+func (e *Encoder) WriteUint32(v uint32) error {
+	return e.writeUint(uint64(v), 32)
+}
+
+func (e *Encoder) WriteUint64(v uint64) error {
+	return e.writeUint(v, 64)
+}
+
+// WriteUint writes a uint as 64-bits
+func (e *Encoder) WriteUint(v uint) error {
+	return e.writeUint(uint64(v), 64)
+}
+
+// WriteInt writes an int as 64-bits
+//
+// "int" can be 32-bit or 64-bit in Golang spec, so assuming int64 (largest)
+func (e *Encoder) WriteInt(v int) error {
+	return e.writeUint(uint64(v), 64)
+}
+
+func (e *Encoder) WriteInt8(v int8) error {
+	return e.writeUint(uint64(uint8(v)), 8)
+}
+
+func (e *Encoder) WriteInt16(v int16) error {
+	return e.writeUint(uint64(uint16(v)), 16)
+}
+
+func (e *Encoder) WriteInt32(v int32) error {
+	return e.writeUint(uint64(uint32(v)), 32)
+}
+
+func (e *Encoder) WriteInt64(v int64) error {
+	return e.writeUint(uint64(v), 64)
+}
+
+func (e *Encoder) WriteFloat32(v float32) error {
+	return e.writeUint(uint64(math.Float32bits(v)), 32)
+}
+
+func (e *Encoder) WriteFloat64(v float64) error {
+	return e.writeUint(math.Float64bits(v), 64)
+}
+
+// WriteBytes writes the bytes as-is, without a length
+func (e *Encoder) WriteBytes(v []byte) error {
+	_, err := e.w.Write(v)
+	return err
+}
+
+// WriteBinary writes the data returned by MarshalBinary with its length before it
+func (e *Encoder) WriteBinary(m encoding.BinaryMarshaler) error {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := e.WriteLength(len(data)); err != nil {
+		return err
+	}
+	return e.WriteBytes(data)
+}
+
+// WriteLength writes the length of a slice that has no maxsize limit
+func (e *Encoder) WriteLength(n int) error {
+	return e.WriteInt32(int32(n))
+}
+
+// WriteString writes the length of the string followed by its data
+func (e *Encoder) WriteString(v string) error {
+	if len(v) > maxStringSize {
+		return errors.New("cannot write string larger than " + strconv.Itoa(maxStringSize))
+	}
+	if err := e.WriteUint16(uint16(len(v))); err != nil {
+		return err
+	}
+	return e.WriteStringData(v)
+}
+
+// WriteStringData writes the string without a length
+func (e *Encoder) WriteStringData(v string) error {
+	if sw, ok := e.w.(io.StringWriter); ok {
+		_, err := sw.WriteString(v)
+		return err
+	}
+	// todo(jae): 2021-03-07
+	// casts to []byte which could be slow, profile later and make fast
+	_, err := e.w.Write([]byte(v))
+	return err
+}
+
+// WriteSize writes the length of a slice or string that has a maxsize limit
+func (e *Encoder) WriteSize(n int, maxSize int) error {
+	if n > maxSize {
+		return fmt.Errorf("%w: size of %d exceeds maxsize of %d", ErrMaxSizeExceeded, n, maxSize)
+	}
+	return e.writeUint(uint64(n), uint(bits.Len64(uint64(maxSize))))
+}
+
+// WriteRanged writes an integer that has a range limit
+func (e *Encoder) WriteRanged(v int64, min, max int64) error {
+	if v < min || v > max {
+		return fmt.Errorf("%w: %d is outside of %d to %d", ErrOutOfRange, v, min, max)
+	}
+	return e.writeUint(uint64(v-min), uint(bits.Len64(uint64(max-min))))
+}
+
+// WriteRangedUint writes an unsigned integer that has a range limit
+func (e *Encoder) WriteRangedUint(v uint64, min, max int64) error {
+	if v > math.MaxInt64 {
+		return fmt.Errorf("%w: %d is outside of %d to %d", ErrOutOfRange, v, min, max)
+	}
+	return e.WriteRanged(int64(v), min, max)
+}
+
+// WriteQuantized writes a float as a fixed point number, values outside of min/max are an error
+func (e *Encoder) WriteQuantized(v float64, min, max, precision float64) error {
+	if math.IsNaN(v) {
+		return errors.New("cannot quantize NaN")
+	}
+	if v < min || v > max {
+		return fmt.Errorf("%w: %v is outside of %v to %v", ErrOutOfRange, v, min, max)
+	}
+	steps := quantizeSteps(min, max, precision)
+	q := uint64(math.Round((v - min) / precision))
+	if q > steps {
+		q = steps
+	}
+	return e.writeUint(q, uint(bits.Len64(steps)))
+}
+
+// quantizeSteps is the largest value a quantized float can be written as
+func quantizeSteps(min, max, precision float64) uint64 {
+	return uint64(math.Round((max - min) / precision))
+}
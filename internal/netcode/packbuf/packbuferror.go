@@ -0,0 +1,50 @@
+package packbuf
+
+import (
+	"errors"
+	"reflect"
+)
+
+var (
+	// ErrNegativeLength is returned when reading a slice with a negative length
+	ErrNegativeLength = errors.New("negative length")
+	// ErrMaxSizeExceeded is returned when a slice or string is larger than its maxsize tag
+	ErrMaxSizeExceeded = errors.New("maxsize exceeded")
+	// ErrOutOfRange is returned when an integer is outside of its range tag or
+	// a quantized float is outside of its quantize tag
+	ErrOutOfRange = errors.New("value out of range")
+	// ErrDuplicateMapKey is returned when reading a map that has the same key twice
+	ErrDuplicateMapKey = errors.New("duplicate map key")
+	// ErrBudgetExceeded is returned when reading would allocate more than the
+	// Limits given to ReadWithLimits
+	ErrBudgetExceeded = errors.New("decode budget exceeded")
+)
+
+// FieldError is returned when a field can't be written or read, errors.Is can be used
+// to check for the underlying error, ie. errors.Is(err, ErrBudgetExceeded)
+type FieldError struct {
+	Type  reflect.Type
+	Field string
+	Err   error
+}
+
+func (err *FieldError) Error() string {
+	return err.Type.String() + "." + err.Field + ": " + err.Err.Error()
+}
+
+func (err *FieldError) Unwrap() error {
+	return err.Err
+}
+
+// fieldError adds the struct and field name to an error, if the error came from
+// a nested struct then it already has the name of the field that failed
+func fieldError(t reflect.Type, i int, err error) error {
+	if _, ok := err.(*FieldError); ok {
+		return err
+	}
+	return &FieldError{
+		Type:  t,
+		Field: t.Field(i).Name,
+		Err:   err,
+	}
+}
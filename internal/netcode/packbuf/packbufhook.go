@@ -0,0 +1,71 @@
+package packbuf
+
+import (
+	"encoding"
+	"reflect"
+)
+
+var (
+	packbufMarshalerType   = reflect.TypeOf((*PackbufMarshaler)(nil)).Elem()
+	packbufUnmarshalerType = reflect.TypeOf((*PackbufUnmarshaler)(nil)).Elem()
+	binaryMarshalerType    = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType  = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// hasHook returns true if values of type t, or a pointer to them, have their own
+// MarshalPackbuf, UnmarshalPackbuf, MarshalBinary or UnmarshalBinary method
+func hasHook(t reflect.Type) bool {
+	for _, iface := range []reflect.Type{
+		packbufMarshalerType,
+		packbufUnmarshalerType,
+		binaryMarshalerType,
+		binaryUnmarshalerType,
+	} {
+		if t.Implements(iface) || reflect.PtrTo(t).Implements(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// methodValue returns v as an interface value that implements iface, if v or a
+// pointer to v implements it
+func methodValue(v reflect.Value, iface reflect.Type) (interface{}, bool) {
+	if v.Type().Implements(iface) {
+		return v.Interface(), true
+	}
+	if !reflect.PtrTo(v.Type()).Implements(iface) {
+		return nil, false
+	}
+	if !v.CanAddr() {
+		// map values can't be addressed, so copy them
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface(), true
+	}
+	return v.Addr().Interface(), true
+}
+
+// writeHook writes v with its MarshalPackbuf or MarshalBinary method, it returns
+// false if v has neither
+func writeHook(e *Encoder, v reflect.Value) (bool, error) {
+	if m, ok := methodValue(v, packbufMarshalerType); ok {
+		return true, m.(PackbufMarshaler).MarshalPackbuf(e)
+	}
+	if m, ok := methodValue(v, binaryMarshalerType); ok {
+		return true, e.WriteBinary(m.(encoding.BinaryMarshaler))
+	}
+	return false, nil
+}
+
+// readHook reads v with its UnmarshalPackbuf or UnmarshalBinary method, it returns
+// false if v has neither
+func readHook(d *Decoder, v reflect.Value) (bool, error) {
+	if m, ok := methodValue(v, packbufUnmarshalerType); ok {
+		return true, m.(PackbufUnmarshaler).UnmarshalPackbuf(d)
+	}
+	if m, ok := methodValue(v, binaryUnmarshalerType); ok {
+		return true, d.ReadBinary(m.(encoding.BinaryUnmarshaler))
+	}
+	return false, nil
+}
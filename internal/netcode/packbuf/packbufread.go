@@ -1,12 +1,11 @@
 package packbuf
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
-	"strconv"
+	"sync"
 )
 
 const (
@@ -15,167 +14,320 @@ const (
 	maxStringSize = 65535
 )
 
+// Limits is the maximum amount of memory a single Read can allocate, this stops
+// malicious data from making us allocate huge slices
+//
+// Slices, maps and pointers that are re-used count towards the limits too, so the
+// same data is limited the same way no matter what it's read into.
+type Limits struct {
+	// MaxBytes is the total size in bytes of every slice, string and pointer allocated
+	MaxBytes int
+	// MaxElements is the total length of every slice
+	MaxElements int
+}
+
+// DefaultLimits are used by Read
+var DefaultLimits = Limits{
+	MaxBytes:    1 << 20,
+	MaxElements: 1 << 16,
+}
+
+// decoderPool holds Decoders so that Read doesn't allocate one every call
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		return &Decoder{}
+	},
+}
+
+// Read will read into the exported fields of a pointer to a struct from r
+//
+// If r is a *BitReader, bools are expected to be packed as a single bit.
+//
+// Slices, maps and pointers that are already set will be re-used if they have
+// enough capacity, so reading into the same value again won't allocate.
+//
+// If data or any of its fields implement PackbufUnmarshaler, that will be used instead
+// of reflection. Otherwise if they implement encoding.BinaryUnmarshaler, that will be
+// given the data written by encoding.BinaryMarshaler.
 func Read(r io.Reader, data interface{}) error {
-	err := readStruct(r, data)
-	if err != nil {
+	return ReadWithLimits(r, data, DefaultLimits)
+}
+
+// ReadWithLimits is the same as Read but will return ErrBudgetExceeded if the data
+// would allocate more than the given limits
+func ReadWithLimits(r io.Reader, data interface{}, limits Limits) error {
+	d := decoderPool.Get().(*Decoder)
+	d.Reset(r, limits)
+	err := readData(d, data)
+	// don't hold onto the reader while in the pool
+	d.Reset(nil, Limits{})
+	decoderPool.Put(d)
+	return err
+}
+
+func readData(d *Decoder, data interface{}) error {
+	if ok, err := readHook(d, reflect.ValueOf(data)); ok {
 		return err
 	}
-	return nil
+	return readStruct(d, reflect.ValueOf(data).Elem())
 }
 
-func readStruct(buf io.Reader, structData interface{}) error {
-	v := reflect.ValueOf(structData).Elem()
+func readStruct(d *Decoder, v reflect.Value) error {
+	t := v.Type()
+	options, err := structOptions(t)
+	if err != nil {
+		return err
+	}
 	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		if !field.CanSet() {
+		fieldOptions := &options[i]
+		if fieldOptions.Skip ||
+			t.Field(i).PkgPath != "" {
 			continue
 		}
+		if err := readValue(d, v.Field(i), fieldOptions); err != nil {
+			return fieldError(t, i, err)
+		}
+	}
+	return nil
+}
 
-		if t := reflect.TypeOf(field.Interface()); t.Kind() == reflect.Slice {
-			var sliceLenCompact int32
-			if err := binary.Read(buf, binary.LittleEndian, &sliceLenCompact); err != nil {
-				return err
-			}
-
-			//
-			sliceLen := int(sliceLenCompact)
-			if sliceLen == 0 {
-				// Ignore setting if no data
-				// This ensures the data stays as "nil"
-				continue
-			}
-			// todo(Jae): 2021-04-04
-			// allow setting max limit on slice data structure via tags
-			// on struct? ie. `packbuf:"maxsize:5"`
-			//
-			// this would stop the server from being able to receive weird
-			// false packets
-
-			slice := reflect.MakeSlice(t, sliceLen, sliceLen)
-			field.Set(slice)
-			switch sliceType := t.Elem(); sliceType.Kind() {
-			case reflect.Uint8:
-				value := make([]byte, sliceLen)
-				if _, err := buf.Read(value); err != nil {
-					return err
-				}
-				field.SetBytes(value)
-			case reflect.Uint16:
-				slice := make([]uint16, sliceLen)
-				for i := 0; i < sliceLen; i++ {
-					if err := binary.Read(buf, binary.LittleEndian, &slice[i]); err != nil {
-						return err
-					}
-				}
-				field.Set(reflect.ValueOf(slice))
-			case reflect.Struct:
-				for i := 0; i < sliceLen; i++ {
-					v := field.Index(i)
-					if err := readStruct(buf, v.Addr().Interface()); err != nil {
-						return err
-					}
-				}
-			case reflect.Ptr:
-				ptrToType := sliceType.Elem()
-				if ptrToType.Kind() != reflect.Struct {
-					return errors.New("unable to handle []*Type where Type is not a struct")
-				}
-				for i := 0; i < sliceLen; i++ {
-					v := field.Index(i)
-					v.Set(reflect.New(ptrToType))
-					if err := readStruct(buf, v.Interface()); err != nil {
-						return err
-					}
-				}
-			default:
-				// custom types or structs must be explicitly typed
-				// using calls to reflect.TypeOf on the defined type.
-				return errors.New("TODO: Read: " + t.Elem().Kind().String())
-			}
-			continue
+// readValue reads a value written by writeValue
+func readValue(d *Decoder, v reflect.Value, opts *FieldOptions) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		isSet, err := d.ReadBool()
+		if err != nil {
+			return err
 		}
-		if field.Kind() == reflect.Struct {
-			if err := readStruct(buf, field.Addr().Interface()); err != nil {
-				return err
-			}
-			continue
+		if !isSet {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
 		}
-		switch fieldType := field.Interface().(type) {
-		case bool:
-			var value byte
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-				return err
-			}
-			if value != 0 {
-				field.SetBool(true)
-				break
-			}
-			field.SetBool(false)
-		case byte:
-			var value byte
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
+		elemType := v.Type().Elem()
+		if err := d.Allocate(1, elemType.Size()); err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(elemType))
+		}
+		return readValue(d, v.Elem(), opts)
+	}
+	if ok, err := readHook(d, v); ok {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return readStruct(d, v)
+	case reflect.Slice:
+		sliceLen, err := readLength(d, opts)
+		if err != nil {
+			return err
+		}
+		// Check the length before allocating so false packets can't make
+		// us allocate huge amounts of memory
+		t := v.Type()
+		if err := d.Allocate(sliceLen, t.Elem().Size()); err != nil {
+			return err
+		}
+		if v.Cap() >= sliceLen {
+			// re-use the slice, if it's nil and there's no data it stays as "nil"
+			v.SetLen(sliceLen)
+		} else {
+			v.Set(reflect.MakeSlice(t, sliceLen, sliceLen))
+		}
+		if sliceLen == 0 {
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 && !opts.HasRange {
+			return d.ReadBytes(v.Bytes())
+		}
+		elemOptions := opts.elemOptions()
+		for j := 0; j < sliceLen; j++ {
+			if err := readValue(d, v.Index(j), &elemOptions); err != nil {
 				return err
 			}
-			field.SetUint(uint64(value))
-		case uint16:
-			var value uint16
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
+		}
+		return nil
+	case reflect.Array:
+		for j := 0; j < v.Len(); j++ {
+			if err := readValue(d, v.Index(j), opts); err != nil {
 				return err
 			}
-			field.SetUint(uint64(value))
-		case uint64:
-			var value uint64
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-				return err
+		}
+		return nil
+	case reflect.Map:
+		mapLen, err := readLength(d, opts)
+		if err != nil {
+			return err
+		}
+		t := v.Type()
+		if err := d.Allocate(mapLen, t.Key().Size()+t.Elem().Size()); err != nil {
+			return err
+		}
+		m := v
+		if m.IsNil() {
+			if mapLen == 0 {
+				// Ignore setting if no data, this ensures the data stays as "nil"
+				return nil
 			}
-			field.SetUint(value)
-		case int,
-			int64:
-			// NOTE(Jae): 2020-05-16
-			// "int" can be 32-bit or 64-bit in Golang spec, so assuming int64 (largest)
-			var value int64
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-				return err
+			m = reflect.MakeMapWithSize(t, mapLen)
+		} else {
+			// re-use the map
+			for iter := m.MapRange(); iter.Next(); {
+				m.SetMapIndex(iter.Key(), reflect.Value{})
 			}
-			field.SetInt(value)
-		case int32:
-			var value int32
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
+		}
+		elemOptions := opts.elemOptions()
+		for j := 0; j < mapLen; j++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := readValue(d, key, &noOptions); err != nil {
 				return err
 			}
-			field.SetInt(int64(value))
-		case float32:
-			var value float32
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
-				return err
+			if m.MapIndex(key).IsValid() {
+				return ErrDuplicateMapKey
 			}
-			field.SetFloat(float64(value))
-		case float64:
-			var value float64
-			if err := binary.Read(buf, binary.LittleEndian, &value); err != nil {
+			value := reflect.New(t.Elem()).Elem()
+			if err := readValue(d, value, &elemOptions); err != nil {
 				return err
 			}
-			field.SetFloat(value)
-		case string:
-			var stringSize uint16
-			if err := binary.Read(buf, binary.LittleEndian, &stringSize); err != nil {
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return nil
+	}
+	if opts.HasQuantize {
+		value, err := d.ReadQuantized(opts.QuantizeMin, opts.QuantizeMax, opts.QuantizePrecision)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(value)
+		return nil
+	}
+	if opts.HasRange {
+		return readRanged(d, v, opts)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		value, err := d.ReadBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(value)
+	case reflect.Int8:
+		value, err := d.ReadInt8()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(value))
+	case reflect.Int16:
+		value, err := d.ReadInt16()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(value))
+	case reflect.Int32:
+		value, err := d.ReadInt32()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(value))
+	case reflect.Int,
+		reflect.Int64:
+		// NOTE(Jae): 2020-05-16
+		// "int" can be 32-bit or 64-bit in Golang spec, so assuming int64 (largest)
+		value, err := d.ReadInt64()
+		if err != nil {
+			return err
+		}
+		v.SetInt(value)
+	case reflect.Uint8:
+		value, err := d.ReadUint8()
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(value))
+	case reflect.Uint16:
+		value, err := d.ReadUint16()
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(value))
+	case reflect.Uint32:
+		value, err := d.ReadUint32()
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(value))
+	case reflect.Uint,
+		reflect.Uint64:
+		value, err := d.ReadUint64()
+		if err != nil {
+			return err
+		}
+		v.SetUint(value)
+	case reflect.Float32:
+		value, err := d.ReadFloat32()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(value))
+	case reflect.Float64:
+		value, err := d.ReadFloat64()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(value)
+	case reflect.String:
+		var stringSize int
+		if opts.HasMaxSize {
+			size, err := d.ReadSize(opts.MaxSize)
+			if err != nil {
 				return err
 			}
-			if stringSize > maxStringSize {
-				return errors.New("cannot write string larger than " + strconv.Itoa(maxStringSize))
-			}
-			if stringSize == 0 {
-				// Nothing to write to field
-				continue
-			}
-			stringData := make([]byte, stringSize)
-			if _, err := buf.Read(stringData); err != nil {
+			stringSize = size
+		} else {
+			size, err := d.ReadUint16()
+			if err != nil {
 				return err
 			}
-			field.SetString(string(stringData))
-		default:
-			return fmt.Errorf("cannot read unsupported data type: %T in struct %T", fieldType, structData)
+			stringSize = int(size)
+		}
+		value, err := d.ReadStringData(stringSize)
+		if err != nil {
+			return err
+		}
+		v.SetString(value)
+	default:
+		return errors.New("cannot read unsupported data type: " + v.Type().String())
+	}
+	return nil
+}
+
+// readLength reads the length of a slice or map
+func readLength(d *Decoder, opts *FieldOptions) (int, error) {
+	if opts.HasMaxSize {
+		return d.ReadSize(opts.MaxSize)
+	}
+	return d.ReadLength()
+}
+
+// readRanged reads an integer field that has a range tag
+func readRanged(d *Decoder, field reflect.Value, opts *FieldOptions) error {
+	v, err := d.ReadRanged(opts.RangeMin, opts.RangeMax)
+	if err != nil {
+		return err
+	}
+	switch field.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v < 0 || field.OverflowUint(uint64(v)) {
+			return fmt.Errorf("%w: %d overflows %s", ErrOutOfRange, v, field.Type())
+		}
+		field.SetUint(uint64(v))
+	default:
+		if field.OverflowInt(v) {
+			return fmt.Errorf("%w: %d overflows %s", ErrOutOfRange, v, field.Type())
 		}
+		field.SetInt(v)
 	}
 	return nil
 }
@@ -0,0 +1,308 @@
+package packbuf
+
+import (
+	"errors"
+	"math/bits"
+	"reflect"
+)
+
+// Kinds of TypeSchema
+const (
+	KindBool    = "bool"
+	KindInt8    = "int8"
+	KindInt16   = "int16"
+	KindInt32   = "int32"
+	KindInt64   = "int64"
+	KindUint8   = "uint8"
+	KindUint16  = "uint16"
+	KindUint32  = "uint32"
+	KindUint64  = "uint64"
+	KindFloat32 = "float32"
+	KindFloat64 = "float64"
+	KindString  = "string"
+	KindSlice   = "slice"
+	KindArray   = "array"
+	KindMap     = "map"
+	KindPointer = "pointer"
+	KindStruct  = "struct"
+	// KindBinary is a type with a MarshalBinary method, it's written as its length
+	// followed by the bytes returned by MarshalBinary
+	KindBinary = "binary"
+	// KindCustom is a type with a MarshalPackbuf method, how it's written is
+	// up to that method and so can't be described
+	KindCustom = "custom"
+)
+
+// Schema describes how Write encodes values so they can be read and written
+// outside of Go, ie. by tooling written in TypeScript.
+//
+// Everything is described as if written to a BitWriter, bools are a single bit and
+// every other value is written with the least significant bit first. If not
+// using a BitWriter, each value is rounded up to the nearest byte.
+type Schema struct {
+	// Structs are every struct type used by the described types
+	Structs []*StructSchema `json:"structs"`
+
+	structNames map[reflect.Type]string
+}
+
+// StructSchema describes the exported fields of a struct that are written, in
+// the order they are written
+type StructSchema struct {
+	// Name is the Go type name including its package, ie. "packs.PlayerState"
+	Name   string         `json:"name"`
+	Fields []*FieldSchema `json:"fields"`
+}
+
+type FieldSchema struct {
+	Name string      `json:"name"`
+	Type *TypeSchema `json:"type"`
+}
+
+// TypeSchema describes how a single value is encoded
+//
+// - Integers are written with their bit size, except int and uint which are always 64-bit
+// - Floats are written as their IEEE 754 bits
+// - Strings are written as their length followed by their bytes
+// - Slices and maps are written as their length followed by each element, map keys are
+// written before each value and are sorted from lowest to highest
+// - Arrays are each element, without a length
+// - Pointers are written as a bool of whether they're set, followed by the value if set
+// - Structs are written as each of their fields
+//
+// Lengths are written as int32, except strings which use uint16. Strings, slices and maps
+// with a maxsize use MaxSize.Bits instead.
+type TypeSchema struct {
+	Kind string `json:"kind"`
+	// Struct is the name of the struct in Schema.Structs if Kind is KindStruct
+	Struct string `json:"struct,omitempty"`
+	// Elem is the type of the elements of a slice, array or map, or the
+	// value a pointer points to
+	Elem *TypeSchema `json:"elem,omitempty"`
+	// Key is the type of the keys of a map
+	Key *TypeSchema `json:"key,omitempty"`
+	// Len is the length of an array
+	Len int `json:"len,omitempty"`
+	// GoType is the Go type for KindBinary and KindCustom
+	GoType string `json:"goType,omitempty"`
+
+	MaxSize  *MaxSizeSchema  `json:"maxSize,omitempty"`
+	Range    *RangeSchema    `json:"range,omitempty"`
+	Quantize *QuantizeSchema `json:"quantize,omitempty"`
+}
+
+// MaxSizeSchema is set for strings, slices and maps with a maxsize tag, their
+// length is written with Bits instead of the usual length size
+type MaxSizeSchema struct {
+	Max  int `json:"max"`
+	Bits int `json:"bits"`
+}
+
+// RangeSchema is set for integers with a range tag, they are written as Bits of
+// the value minus Min
+type RangeSchema struct {
+	Min  int64 `json:"min"`
+	Max  int64 `json:"max"`
+	Bits int   `json:"bits"`
+}
+
+// QuantizeSchema is set for floats with a quantize tag, they are written as Bits of
+// round((value - Min) / Precision), values outside of Min and Max can't be written
+type QuantizeSchema struct {
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Precision float64 `json:"precision"`
+	Bits      int     `json:"bits"`
+}
+
+// Struct returns the struct with the given name or nil if it hasn't been described
+func (s *Schema) Struct(name string) *StructSchema {
+	for _, structSchema := range s.Structs {
+		if structSchema.Name == name {
+			return structSchema
+		}
+	}
+	return nil
+}
+
+// DescribeStruct adds t and every struct it uses to the schema and returns the name
+// it was added with. t must be a struct or a pointer to a struct.
+//
+// The fields of t are always described, even if t has its own MarshalPackbuf method,
+// as code generated by cmd/packbuf-gen writes the same data as reflection would.
+func (s *Schema) DescribeStruct(t reflect.Type) (string, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return "", errors.New("cannot describe " + t.String() + ", expected a struct")
+	}
+	return s.describeStruct(t)
+}
+
+func (s *Schema) describeStruct(t reflect.Type) (string, error) {
+	if name, ok := s.structNames[t]; ok {
+		return name, nil
+	}
+	if t.Name() == "" {
+		return "", errors.New("cannot describe anonymous struct: " + t.String())
+	}
+	name := t.String()
+	if s.structNames == nil {
+		s.structNames = make(map[reflect.Type]string)
+	}
+	for _, other := range s.Structs {
+		if other.Name == name {
+			return "", errors.New("cannot describe " + name + ", a different type with the same name has already been described")
+		}
+	}
+	// add before describing fields so structs that reference themselves work
+	structSchema := &StructSchema{
+		Name:   name,
+		Fields: []*FieldSchema{},
+	}
+	s.structNames[t] = name
+	s.Structs = append(s.Structs, structSchema)
+
+	options, err := structOptions(t)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if options[i].Skip ||
+			field.PkgPath != "" {
+			continue
+		}
+		typeSchema, err := s.describeValue(field.Type, &options[i])
+		if err != nil {
+			return "", fieldError(t, i, err)
+		}
+		structSchema.Fields = append(structSchema.Fields, &FieldSchema{
+			Name: field.Name,
+			Type: typeSchema,
+		})
+	}
+	return name, nil
+}
+
+// describeValue describes a value written by writeValue
+func (s *Schema) describeValue(t reflect.Type, opts *FieldOptions) (*TypeSchema, error) {
+	if t.Kind() == reflect.Ptr {
+		elem, err := s.describeValue(t.Elem(), opts)
+		if err != nil {
+			return nil, err
+		}
+		return &TypeSchema{
+			Kind: KindPointer,
+			Elem: elem,
+		}, nil
+	}
+	if implements(t, packbufMarshalerType) {
+		return &TypeSchema{
+			Kind:   KindCustom,
+			GoType: t.String(),
+		}, nil
+	}
+	if implements(t, binaryMarshalerType) {
+		return &TypeSchema{
+			Kind:   KindBinary,
+			GoType: t.String(),
+		}, nil
+	}
+	typeSchema := &TypeSchema{}
+	switch t.Kind() {
+	case reflect.Struct:
+		name, err := s.describeStruct(t)
+		if err != nil {
+			return nil, err
+		}
+		typeSchema.Kind = KindStruct
+		typeSchema.Struct = name
+		return typeSchema, nil
+	case reflect.Slice, reflect.Map:
+		typeSchema.Kind = KindSlice
+		if t.Kind() == reflect.Map {
+			typeSchema.Kind = KindMap
+			key, err := s.describeValue(t.Key(), &noOptions)
+			if err != nil {
+				return nil, err
+			}
+			typeSchema.Key = key
+		}
+		typeSchema.MaxSize = maxSizeSchema(opts)
+		elemOptions := opts.elemOptions()
+		elem, err := s.describeValue(t.Elem(), &elemOptions)
+		if err != nil {
+			return nil, err
+		}
+		typeSchema.Elem = elem
+		return typeSchema, nil
+	case reflect.Array:
+		elem, err := s.describeValue(t.Elem(), opts)
+		if err != nil {
+			return nil, err
+		}
+		typeSchema.Kind = KindArray
+		typeSchema.Len = t.Len()
+		typeSchema.Elem = elem
+		return typeSchema, nil
+	case reflect.String:
+		typeSchema.Kind = KindString
+		typeSchema.MaxSize = maxSizeSchema(opts)
+		return typeSchema, nil
+	}
+	kind, ok := basicKinds[t.Kind()]
+	if !ok {
+		return nil, errors.New("cannot describe unsupported data type: " + t.String())
+	}
+	typeSchema.Kind = kind
+	if opts.HasQuantize {
+		typeSchema.Quantize = &QuantizeSchema{
+			Min:       opts.QuantizeMin,
+			Max:       opts.QuantizeMax,
+			Precision: opts.QuantizePrecision,
+			Bits:      bits.Len64(quantizeSteps(opts.QuantizeMin, opts.QuantizeMax, opts.QuantizePrecision)),
+		}
+	}
+	if opts.HasRange {
+		typeSchema.Range = &RangeSchema{
+			Min:  opts.RangeMin,
+			Max:  opts.RangeMax,
+			Bits: bits.Len64(uint64(opts.RangeMax - opts.RangeMin)),
+		}
+	}
+	return typeSchema, nil
+}
+
+// basicKinds are the kinds that are written as a single number or bool
+var basicKinds = map[reflect.Kind]string{
+	reflect.Bool:    KindBool,
+	reflect.Int8:    KindInt8,
+	reflect.Int16:   KindInt16,
+	reflect.Int32:   KindInt32,
+	reflect.Int:     KindInt64,
+	reflect.Int64:   KindInt64,
+	reflect.Uint8:   KindUint8,
+	reflect.Uint16:  KindUint16,
+	reflect.Uint32:  KindUint32,
+	reflect.Uint:    KindUint64,
+	reflect.Uint64:  KindUint64,
+	reflect.Float32: KindFloat32,
+	reflect.Float64: KindFloat64,
+}
+
+func maxSizeSchema(opts *FieldOptions) *MaxSizeSchema {
+	if !opts.HasMaxSize {
+		return nil
+	}
+	return &MaxSizeSchema{
+		Max:  opts.MaxSize,
+		Bits: bits.Len64(uint64(opts.MaxSize)),
+	}
+}
+
+// implements returns true if t or a pointer to t implements iface
+func implements(t reflect.Type, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
@@ -0,0 +1,237 @@
+package packbuf
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fields can be configured with a `packbuf` struct tag. Multiple options are separated
+// by a semi-colon, ie. `packbuf:"maxsize:10;range:0,100"`
+//
+// - "-" will skip the field, it won't be written and will be left as-is when read
+// - "maxsize:N" limits the length of a slice, map or string to N
+// - "range:min,max" limits an integer (or each integer in a slice, array or map) to be between min and max (inclusive)
+// - "quantize:min,max,precision" sends a float (or each float in a slice, array or map) as a fixed point number between min and max (inclusive)
+//
+// Limits are checked when writing and reading. Fields with limits are also written with the smallest
+// number of bits (or bytes if not using a BitWriter) needed to fit the limit.
+const tagName = "packbuf"
+
+// FieldOptions are the options set by a `packbuf` struct tag
+type FieldOptions struct {
+	Skip bool
+
+	HasMaxSize bool
+	MaxSize    int
+
+	HasRange bool
+	RangeMin int64
+	RangeMax int64
+
+	HasQuantize       bool
+	QuantizeMin       float64
+	QuantizeMax       float64
+	QuantizePrecision float64
+}
+
+// TagError is returned if a `packbuf` struct tag is malformed or used on a field
+// type it doesn't support
+type TagError struct {
+	Type  reflect.Type
+	Field string
+	Tag   string
+	Err   error
+}
+
+func (err *TagError) Error() string {
+	return "invalid " + tagName + " tag \"" + err.Tag + "\" on " + err.Type.String() + "." + err.Field + ": " + err.Err.Error()
+}
+
+func (err *TagError) Unwrap() error {
+	return err.Err
+}
+
+// structOptionsCache is a map of reflect.Type to []FieldOptions so
+// we only parse struct tags once per type
+var structOptionsCache sync.Map
+
+// structOptions returns the options for each field of the struct type t
+func structOptions(t reflect.Type) ([]FieldOptions, error) {
+	if options, ok := structOptionsCache.Load(t); ok {
+		return options.([]FieldOptions), nil
+	}
+	options := make([]FieldOptions, t.NumField())
+	for i := range options {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup(tagName)
+		if !ok {
+			continue
+		}
+		if err := parseFieldOptions(&options[i], field.Type, tag); err != nil {
+			return nil, &TagError{
+				Type:  t,
+				Field: field.Name,
+				Tag:   tag,
+				Err:   err,
+			}
+		}
+	}
+	structOptionsCache.Store(t, options)
+	return options, nil
+}
+
+// noOptions is used for values that can't have options, ie. map keys
+var noOptions FieldOptions
+
+// elemOptions are the options for each element of a slice or map, maxsize only
+// applies to the slice or map itself
+func (opts *FieldOptions) elemOptions() FieldOptions {
+	elemOptions := *opts
+	elemOptions.HasMaxSize = false
+	elemOptions.MaxSize = 0
+	return elemOptions
+}
+
+// parseFieldOptions parses the tag and checks the options can be used on a field of type t
+func parseFieldOptions(opts *FieldOptions, t reflect.Type, tag string) error {
+	parsed, err := ParseTag(tag)
+	if err != nil {
+		return err
+	}
+	// options apply to what a pointer points to
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if hasHook(t) && (parsed.HasMaxSize || parsed.HasRange || parsed.HasQuantize) {
+		return errors.New("cannot be used on " + t.String() + " as it has its own Marshal or Unmarshal method")
+	}
+	// range and quantize apply to each number in a slice, array or map
+	elemType := t
+	for {
+		switch elemType.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			elemType = elemType.Elem()
+			continue
+		}
+		break
+	}
+	if parsed.HasMaxSize {
+		if kind := t.Kind(); kind != reflect.Slice && kind != reflect.String && kind != reflect.Map {
+			return errors.New("maxsize can only be used on slices, maps or strings")
+		}
+		if t.Kind() == reflect.String && parsed.MaxSize > maxStringSize {
+			return errors.New("maxsize cannot be larger than " + strconv.Itoa(maxStringSize) + " for strings")
+		}
+	}
+	if hasHook(elemType) && (parsed.HasRange || parsed.HasQuantize) {
+		return errors.New("cannot be used on " + elemType.String() + " as it has its own Marshal or Unmarshal method")
+	}
+	if parsed.HasRange && !isIntKind(elemType.Kind()) {
+		return errors.New("range can only be used on integers")
+	}
+	if parsed.HasQuantize {
+		if kind := elemType.Kind(); kind != reflect.Float32 && kind != reflect.Float64 {
+			return errors.New("quantize can only be used on floats")
+		}
+	}
+	*opts = parsed
+	return nil
+}
+
+// ParseTag parses the value of a `packbuf` struct tag
+//
+// This doesn't check that the options can be used on the type of the field, that's
+// done when the struct is first written or read.
+func ParseTag(tag string) (FieldOptions, error) {
+	var opts FieldOptions
+	if tag == "-" {
+		opts.Skip = true
+		return opts, nil
+	}
+	for _, option := range strings.Split(tag, ";") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		name, value := option, ""
+		if i := strings.Index(option, ":"); i != -1 {
+			name, value = option[:i], option[i+1:]
+		}
+		args := strings.Split(value, ",")
+		switch name {
+		case "maxsize":
+			if len(args) != 1 {
+				return opts, errors.New("maxsize expects 1 argument")
+			}
+			maxSize, err := strconv.Atoi(strings.TrimSpace(args[0]))
+			if err != nil {
+				return opts, err
+			}
+			if maxSize < 0 {
+				return opts, errors.New("maxsize cannot be negative")
+			}
+			opts.HasMaxSize = true
+			opts.MaxSize = maxSize
+		case "range":
+			if len(args) != 2 {
+				return opts, errors.New("range expects 2 arguments, min and max")
+			}
+			min, err := strconv.ParseInt(strings.TrimSpace(args[0]), 10, 64)
+			if err != nil {
+				return opts, err
+			}
+			max, err := strconv.ParseInt(strings.TrimSpace(args[1]), 10, 64)
+			if err != nil {
+				return opts, err
+			}
+			if min > max {
+				return opts, errors.New("range min cannot be larger than max")
+			}
+			opts.HasRange = true
+			opts.RangeMin = min
+			opts.RangeMax = max
+		case "quantize":
+			if len(args) != 3 {
+				return opts, errors.New("quantize expects 3 arguments, min, max and precision")
+			}
+			var values [3]float64
+			for i, arg := range args {
+				v, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+				if err != nil {
+					return opts, err
+				}
+				values[i] = v
+			}
+			min, max, precision := values[0], values[1], values[2]
+			if min >= max {
+				return opts, errors.New("quantize min must be less than max")
+			}
+			if precision <= 0 {
+				return opts, errors.New("quantize precision must be greater than 0")
+			}
+			if steps := math.Round((max - min) / precision); steps >= 1<<32 {
+				return opts, errors.New("quantize cannot use more than 32 bits, lower the precision")
+			}
+			opts.HasQuantize = true
+			opts.QuantizeMin = min
+			opts.QuantizeMax = max
+			opts.QuantizePrecision = precision
+		default:
+			return opts, errors.New("unknown option: " + name)
+		}
+	}
+	return opts, nil
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
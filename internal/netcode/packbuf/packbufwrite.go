@@ -1,149 +1,244 @@
 package packbuf
 
 import (
-	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
-	"math"
 	"reflect"
-	"strconv"
+	"sort"
+	"sync"
 )
 
+// encoderPool holds Encoders so that Write doesn't allocate one every call
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return &Encoder{}
+	},
+}
+
+// appender is used by Append to bit-pack data onto the end of a slice
+type appender struct {
+	bw BitWriter
+	e  Encoder
+}
+
+var appenderPool = sync.Pool{
+	New: func() interface{} {
+		return &appender{}
+	},
+}
+
+// Write will write the exported fields of a pointer to a struct to w
+//
+// If w is a *BitWriter, bools will be packed as a single bit.
+//
+// Pointers are written with a bool before them that's false if they're nil, and maps
+// are written in order of their keys so that the same data is always written the same way.
+//
+// If data or any of its fields implement PackbufMarshaler, that will be used instead
+// of reflection. Otherwise if they implement encoding.BinaryMarshaler, the result of
+// MarshalBinary is written with its length before it.
 func Write(w io.Writer, data interface{}) error {
-	err := writeStruct(w, data)
-	if err != nil {
+	e := encoderPool.Get().(*Encoder)
+	e.Reset(w)
+	err := writeData(e, data)
+	// don't hold onto the writer while in the pool
+	e.Reset(nil)
+	encoderPool.Put(e)
+	return err
+}
+
+// Append bit-packs data onto the end of dst, this is the same as calling Write with
+// a BitWriter and then calling Flush, except it won't allocate if dst has enough capacity
+func Append(dst []byte, data interface{}) ([]byte, error) {
+	a := appenderPool.Get().(*appender)
+	a.bw.Reset(nil)
+	a.bw.out = dst
+	a.e.Reset(&a.bw)
+	err := writeData(&a.e, data)
+	a.bw.pad()
+	dst = a.bw.out
+	// don't hold onto dst while in the pool
+	a.bw.out = nil
+	a.e.Reset(nil)
+	appenderPool.Put(a)
+	return dst, err
+}
+
+func writeData(e *Encoder, data interface{}) error {
+	if ok, err := writeHook(e, reflect.ValueOf(data)); ok {
 		return err
 	}
-	return err
+	return writeStruct(e, reflect.ValueOf(data).Elem())
 }
 
-func writeStruct(w io.Writer, value interface{}) error {
-	v := reflect.ValueOf(value).Elem()
+func writeStruct(e *Encoder, v reflect.Value) error {
+	t := v.Type()
+	options, err := structOptions(t)
+	if err != nil {
+		return err
+	}
 	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		if !field.CanSet() {
-			return errors.New("cannot serialize unexported field: " + field.String())
+		fieldOptions := &options[i]
+		if fieldOptions.Skip {
+			continue
 		}
-		switch field.Kind() {
-		case reflect.Slice:
-			sliceLenCompact := int32(field.Len())
-			if err := binary.Write(w, binary.LittleEndian, sliceLenCompact); err != nil {
-				return err
-			}
+		if t.Field(i).PkgPath != "" {
+			return errors.New("cannot serialize unexported field: " + t.String() + "." + t.Field(i).Name)
+		}
+		if err := writeValue(e, v.Field(i), fieldOptions); err != nil {
+			return fieldError(t, i, err)
+		}
+	}
+	return nil
+}
 
-			// todo: change to field.Type() and ensure it works
-			t := reflect.TypeOf(field.Interface())
-			sliceLen := field.Len()
-			sliceType := t.Elem()
-			switch sliceType.Kind() { // type of the slice element
-			case reflect.Struct:
-				for i := 0; i < sliceLen; i++ {
-					v := field.Index(i)
-					if err := writeStruct(w, v.Addr().Interface()); err != nil {
-						return err
-					}
-				}
-			case reflect.Ptr:
-				ptrToType := sliceType.Elem()
-				if ptrToType.Kind() != reflect.Struct {
-					return errors.New("unable to handle []*Type where Type is not a struct")
-				}
-				for i := 0; i < sliceLen; i++ {
-					v := field.Index(i)
-					if err := writeStruct(w, v.Interface()); err != nil {
-						return err
-					}
-				}
-			default:
-				switch fieldValue := field.Interface().(type) {
-				case []uint8:
-					if _, err := w.Write(fieldValue); err != nil {
-						return err
-					}
-				case []uint16:
-					for _, v := range fieldValue {
-						if err := binary.Write(w, binary.LittleEndian, &v); err != nil {
-							return err
-						}
-					}
-				default:
-					// custom types or structs must be explicitly typed
-					// using calls to reflect.TypeOf on the defined type.
-					return errors.New("TODO: writeStruct for type: " + t.Elem().Kind().String())
-				}
-			}
-			continue
-		case reflect.Struct:
-			if err := writeStruct(w, field.Addr().Interface()); err != nil {
-				return err
-			}
-			continue
+// writeValue writes a field, or an element of a slice, array or map
+//
+// maxsize only applies to the field itself, while range and quantize apply to
+// every number in the field, ie. each number in a slice
+func writeValue(e *Encoder, v reflect.Value, opts *FieldOptions) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		// pointers are optional, so write if they're set or not
+		if err := e.WriteBool(!v.IsNil()); err != nil {
+			return err
 		}
-		switch fieldValue := field.Interface().(type) {
-		case bool:
-			bsBack := [1]byte{0}
-			bs := bsBack[:]
-			if fieldValue {
-				bs[0] = 1
-			}
-			if _, err := w.Write(bs); err != nil {
-				return err
-			}
-		case byte:
-			var bsBack [1]byte
-			bs := bsBack[:]
-			bs[0] = fieldValue
-			binary.LittleEndian.PutUint64(bs, uint64(fieldValue))
-			if _, err := w.Write(bs); err != nil {
-				return err
-			}
-		case int:
-			// NOTE(Jae): 2021-03-07
-			// "int" can be 32-bit or 64-bit in Golang spec, so assuming int64 (largest)
-			var bsBack [8]byte
-			bs := bsBack[:]
-			binary.LittleEndian.PutUint64(bs, uint64(fieldValue))
-			if _, err := w.Write(bs); err != nil {
-				return err
-			}
-		case int16:
-			var bsBack [2]byte
-			bs := bsBack[:]
-			binary.LittleEndian.PutUint16(bs, uint16(fieldValue))
-			if _, err := w.Write(bs); err != nil {
-				return err
-			}
-		case float32:
-			var bsBack [4]byte
-			bs := bsBack[:]
-			binary.LittleEndian.PutUint32(bs, math.Float32bits(fieldValue))
-			if _, err := w.Write(bs); err != nil {
+		if v.IsNil() {
+			return nil
+		}
+		return writeValue(e, v.Elem(), opts)
+	}
+	if ok, err := writeHook(e, v); ok {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return writeStruct(e, v)
+	case reflect.Slice:
+		if err := writeLength(e, v.Len(), opts); err != nil {
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && !opts.HasRange {
+			return e.WriteBytes(v.Bytes())
+		}
+		elemOptions := opts.elemOptions()
+		for j := 0; j < v.Len(); j++ {
+			if err := writeValue(e, v.Index(j), &elemOptions); err != nil {
 				return err
 			}
-		case int32,
-			int64,
-			uint16,
-			uint64,
-			float64:
-			if err := binary.Write(w, binary.LittleEndian, fieldValue); err != nil {
+		}
+		return nil
+	case reflect.Array:
+		for j := 0; j < v.Len(); j++ {
+			if err := writeValue(e, v.Index(j), opts); err != nil {
 				return err
 			}
-		case string:
-			if len(fieldValue) > maxStringSize {
-				return errors.New("cannot write string larger than " + strconv.Itoa(maxStringSize))
-			}
-			if err := binary.Write(w, binary.LittleEndian, uint16(len(fieldValue))); err != nil {
+		}
+		return nil
+	case reflect.Map:
+		if err := writeLength(e, v.Len(), opts); err != nil {
+			return err
+		}
+		// maps are written in order of their keys so the same map is
+		// always written as the same bytes
+		keys := v.MapKeys()
+		if err := sortMapKeys(v.Type().Key(), keys); err != nil {
+			return err
+		}
+		elemOptions := opts.elemOptions()
+		for _, key := range keys {
+			if err := writeValue(e, key, &noOptions); err != nil {
 				return err
 			}
-			// todo(jae): 2021-03-07
-			// casts to []byte which could be slow, profile later and make fast
-			if _, err := w.Write([]byte(fieldValue)); err != nil {
+			if err := writeValue(e, v.MapIndex(key), &elemOptions); err != nil {
 				return err
 			}
-		default:
-			return fmt.Errorf("Cannot write unsupported data type: %T in packet type %T", fieldValue, value)
 		}
+		return nil
+	}
+	if opts.HasQuantize {
+		return e.WriteQuantized(v.Float(), opts.QuantizeMin, opts.QuantizeMax, opts.QuantizePrecision)
+	}
+	if opts.HasRange {
+		return writeRanged(e, v, opts)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return e.WriteBool(v.Bool())
+	case reflect.Int8:
+		return e.WriteInt8(int8(v.Int()))
+	case reflect.Int16:
+		return e.WriteInt16(int16(v.Int()))
+	case reflect.Int32:
+		return e.WriteInt32(int32(v.Int()))
+	case reflect.Int,
+		reflect.Int64:
+		// NOTE(Jae): 2021-03-07
+		// "int" can be 32-bit or 64-bit in Golang spec, so assuming int64 (largest)
+		return e.WriteInt64(v.Int())
+	case reflect.Uint8:
+		return e.WriteUint8(uint8(v.Uint()))
+	case reflect.Uint16:
+		return e.WriteUint16(uint16(v.Uint()))
+	case reflect.Uint32:
+		return e.WriteUint32(uint32(v.Uint()))
+	case reflect.Uint,
+		reflect.Uint64:
+		return e.WriteUint64(v.Uint())
+	case reflect.Float32:
+		return e.WriteFloat32(float32(v.Float()))
+	case reflect.Float64:
+		return e.WriteFloat64(v.Float())
+	case reflect.String:
+		if !opts.HasMaxSize {
+			return e.WriteString(v.String())
+		}
+		if err := e.WriteSize(v.Len(), opts.MaxSize); err != nil {
+			return err
+		}
+		return e.WriteStringData(v.String())
+	}
+	return errors.New("cannot write unsupported data type: " + v.Type().String())
+}
+
+// writeLength writes the length of a slice or map
+func writeLength(e *Encoder, n int, opts *FieldOptions) error {
+	if opts.HasMaxSize {
+		return e.WriteSize(n, opts.MaxSize)
+	}
+	return e.WriteLength(n)
+}
+
+// sortMapKeys sorts the keys of a map from lowest to highest
+func sortMapKeys(t reflect.Type, keys []reflect.Value) error {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Int() < keys[j].Int()
+		})
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Uint() < keys[j].Uint()
+		})
+	case reflect.Float32, reflect.Float64:
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Float() < keys[j].Float()
+		})
+	case reflect.String:
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	default:
+		return errors.New("unsupported map key type: " + t.String() + ", map keys must be numbers or strings")
 	}
 	return nil
 }
+
+// writeRanged writes an integer field that has a range tag
+func writeRanged(e *Encoder, field reflect.Value, opts *FieldOptions) error {
+	switch field.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return e.WriteRangedUint(field.Uint(), opts.RangeMin, opts.RangeMax)
+	}
+	return e.WriteRanged(field.Int(), opts.RangeMin, opts.RangeMax)
+}
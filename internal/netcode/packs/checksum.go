@@ -0,0 +1,69 @@
+package packs
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ChecksumSize is the size of the checksum written at the start of a datagram
+const ChecksumSize = 4
+
+// ErrChecksumMismatch is returned by Checksum.Open if the datagram was corrupted or sent
+// by something using a different protocol ID
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrChecksumMissing is returned by Checksum.Open if the datagram is too small to have a checksum
+var ErrChecksumMissing = errors.New("datagram is too small to have a checksum")
+
+// Checksum prefixes each datagram with a CRC32 of its packets, salted by a protocol ID.
+//
+// The protocol ID itself is never sent, so datagrams from other builds of the game or
+// from other services will fail the checksum and can be dropped before we try to decode them.
+//
+// source: https://gafferongames.com/post/reliability_ordering_and_congestion_avoidance_over_udp/
+type Checksum struct {
+	// salt is the CRC32 of the protocol ID, the CRC32 of the packets is continued from this
+	salt uint32
+}
+
+// NewChecksum returns a Checksum that is salted with protocolID, both ends of a connection
+// must use the same protocol ID.
+func NewChecksum(protocolID uint32) Checksum {
+	var protocolIDBytes [4]byte
+	binary.LittleEndian.PutUint32(protocolIDBytes[:], protocolID)
+	return Checksum{
+		salt: crc32.ChecksumIEEE(protocolIDBytes[:]),
+	}
+}
+
+// AppendHeader appends space for the checksum to dst, this should be called before any
+// packets are written to the datagram.
+func (c Checksum) AppendHeader(dst []byte) []byte {
+	return append(dst, 0, 0, 0, 0)
+}
+
+// Seal writes the checksum of the packets in the datagram to its header, the datagram
+// must start with the space reserved by AppendHeader.
+func (c Checksum) Seal(datagram []byte) {
+	if len(datagram) < ChecksumSize {
+		panic("developer mistake, datagram is missing space for a checksum, call AppendHeader first")
+	}
+	binary.LittleEndian.PutUint32(datagram, c.sum(datagram[ChecksumSize:]))
+}
+
+// Open checks the checksum of a datagram written by Seal and returns the packets after it
+func (c Checksum) Open(datagram []byte) ([]byte, error) {
+	if len(datagram) < ChecksumSize {
+		return nil, ErrChecksumMissing
+	}
+	payload := datagram[ChecksumSize:]
+	if binary.LittleEndian.Uint32(datagram) != c.sum(payload) {
+		return nil, ErrChecksumMismatch
+	}
+	return payload, nil
+}
+
+func (c Checksum) sum(payload []byte) uint32 {
+	return crc32.Update(c.salt, crc32.IEEETable, payload)
+}
@@ -0,0 +1,49 @@
+package packs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+func TestChecksum(t *testing.T) {
+	checksum := NewChecksum(1)
+	var rtt rtt.RoundTripTracking
+	datagram, _, err := AppendPacket(checksum.AppendHeader(nil), &rtt, &AckPacket{
+		SequenceIDList: []uint16{1, 2, 3},
+	})
+	if err != nil {
+		t.Fatalf("unable to write packet: %v", err)
+	}
+	checksum.Seal(datagram)
+
+	payload, err := checksum.Open(datagram)
+	if err != nil {
+		t.Fatalf("unable to open datagram: %v", err)
+	}
+	if !bytes.Equal(payload, datagram[ChecksumSize:]) {
+		t.Errorf("expected payload to be the packets after the checksum")
+	}
+	if _, _, err := Read(bytes.NewReader(payload)); err != nil {
+		t.Errorf("unable to read packet after checksum: %v", err)
+	}
+
+	// every single bit flip should be caught
+	for i := range datagram {
+		for bit := 0; bit < 8; bit++ {
+			corrupted := append([]byte(nil), datagram...)
+			corrupted[i] ^= 1 << bit
+			if _, err := checksum.Open(corrupted); !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("expected ErrChecksumMismatch with bit %d of byte %d flipped but got: %v", bit, i, err)
+			}
+		}
+	}
+	if _, err := NewChecksum(2).Open(datagram); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch with a different protocol ID but got: %v", err)
+	}
+	if _, err := checksum.Open(datagram[:ChecksumSize-1]); !errors.Is(err, ErrChecksumMissing) {
+		t.Errorf("expected ErrChecksumMissing for a datagram smaller than a checksum but got: %v", err)
+	}
+}
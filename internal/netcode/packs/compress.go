@@ -0,0 +1,121 @@
+package packs
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+// CompressionHeaderSize is the size of the flags written by Compressor.AppendHeader
+const CompressionHeaderSize = 1
+
+const (
+	// datagramCompressed is set in the compression header if the packets after it are compressed
+	datagramCompressed uint8 = 1 << iota
+)
+
+// maxDecompressedSize is the largest a datagram can be after decompressing it, this
+// stops small malicious datagrams from decompressing into huge amounts of memory
+const maxDecompressedSize = 65536
+
+// ErrDecompressedTooLarge is returned by Compressor.Decompress if the datagram would
+// decompress into more than 64kb
+var ErrDecompressedTooLarge = errors.New("decompressed datagram is too large")
+
+// Compressor deflates the packets in a datagram and writes a flag before them saying
+// whether they were compressed, so compression only needs to be used when it makes
+// the datagram smaller.
+//
+// A Compressor re-uses its buffers and so isn't safe to use from multiple goroutines.
+type Compressor struct {
+	w   *flate.Writer
+	buf bytes.Buffer
+
+	r   io.ReadCloser
+	src bytes.Reader
+	out []byte
+}
+
+// NewCompressor returns a Compressor that compresses with flate.BestSpeed
+func NewCompressor() *Compressor {
+	w, err := flate.NewWriter(nil, flate.BestSpeed)
+	if err != nil {
+		// this only happens if the compression level is invalid
+		panic(err)
+	}
+	return &Compressor{
+		w: w,
+	}
+}
+
+// AppendHeader appends the compression flags to dst, this should be called before any
+// packets are written to the datagram.
+func (c *Compressor) AppendHeader(dst []byte) []byte {
+	return append(dst, 0)
+}
+
+// Compress will compress the packets after the compression header at datagram[start:] but only
+// if that makes the datagram smaller. It returns the datagram which is shortened if compressed.
+func (c *Compressor) Compress(datagram []byte, start int) ([]byte, error) {
+	payload := datagram[start+CompressionHeaderSize:]
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	if _, err := c.w.Write(payload); err != nil {
+		return datagram, err
+	}
+	if err := c.w.Close(); err != nil {
+		return datagram, err
+	}
+	if c.buf.Len() >= len(payload) {
+		// leave as-is, it's smaller uncompressed
+		datagram[start] &^= datagramCompressed
+		return datagram, nil
+	}
+	datagram[start] |= datagramCompressed
+	n := copy(payload, c.buf.Bytes())
+	return datagram[:start+CompressionHeaderSize+n], nil
+}
+
+// Decompress returns the packets after the compression header at the start of datagram,
+// decompressing them if need be.
+//
+// If the packets were compressed, the returned slice is only valid until the next call
+// to Decompress.
+func (c *Compressor) Decompress(datagram []byte) ([]byte, error) {
+	if len(datagram) < CompressionHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := datagram[CompressionHeaderSize:]
+	if datagram[0]&datagramCompressed == 0 {
+		return payload, nil
+	}
+	c.src.Reset(payload)
+	if c.r == nil {
+		c.r = flate.NewReader(&c.src)
+	} else if err := c.r.(flate.Resetter).Reset(&c.src, nil); err != nil {
+		return nil, err
+	}
+	if c.out == nil {
+		// allocate one byte more than the limit so we can tell if we went over it
+		c.out = make([]byte, maxDecompressedSize+1)
+	}
+	n := 0
+	for {
+		if n == len(c.out) {
+			return nil, ErrDecompressedTooLarge
+		}
+		m, err := c.r.Read(c.out[n:])
+		n += m
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if n > maxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return c.out[:n], nil
+}
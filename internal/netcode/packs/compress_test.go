@@ -0,0 +1,101 @@
+package packs
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+// newTestFullWorldState returns a world state of players standing still, which is
+// the kind of packet that compresses well
+func newTestFullWorldState(playerCount int) *ServerWorldStatePacket {
+	players := make([]PlayerState, playerCount)
+	for i := range players {
+		players[i] = PlayerState{
+			NetID: uint16(i + 1),
+			X:     float32(i * 32),
+			Y:     400,
+		}
+	}
+	packet := &ServerWorldStatePacket{
+		MyNetID: 1,
+	}
+	packet.DeltaEncode(false, 0, nil, players)
+	return packet
+}
+
+func TestCompressor(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Packet       Packet
+		IsCompressed bool
+	}{
+		{
+			Name:         "full world state",
+			Packet:       newTestFullWorldState(64),
+			IsCompressed: true,
+		},
+		{
+			Name:   "small ack",
+			Packet: &AckPacket{SequenceIDList: []uint16{1}},
+		},
+	}
+	for _, testCase := range testCases {
+		compressor := NewCompressor()
+		var rtt rtt.RoundTripTracking
+		checksum := NewChecksum(1)
+		datagram := compressor.AppendHeader(checksum.AppendHeader(nil))
+		datagram, _, err := AppendPacket(datagram, &rtt, testCase.Packet)
+		if err != nil {
+			t.Fatalf("%s: unable to write packet: %v", testCase.Name, err)
+		}
+		uncompressed := append([]byte(nil), datagram[ChecksumSize+CompressionHeaderSize:]...)
+		datagram, err = compressor.Compress(datagram, ChecksumSize)
+		if err != nil {
+			t.Fatalf("%s: unable to compress: %v", testCase.Name, err)
+		}
+		checksum.Seal(datagram)
+		if isCompressed := len(datagram) < ChecksumSize+CompressionHeaderSize+len(uncompressed); isCompressed != testCase.IsCompressed {
+			t.Errorf("%s: expected compressed to be %v, uncompressed size %d, compressed size %d", testCase.Name, testCase.IsCompressed, len(uncompressed), len(datagram))
+		}
+
+		payload, err := checksum.Open(datagram)
+		if err != nil {
+			t.Fatalf("%s: unable to open datagram: %v", testCase.Name, err)
+		}
+		payload, err = NewCompressor().Decompress(payload)
+		if err != nil {
+			t.Fatalf("%s: unable to decompress: %v", testCase.Name, err)
+		}
+		if !bytes.Equal(payload, uncompressed) {
+			t.Errorf("%s: decompressed packets don't match what was written", testCase.Name)
+		}
+	}
+}
+
+func TestCompressorDecompressLimit(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(datagramCompressed)
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, maxDecompressedSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCompressor().Decompress(buf.Bytes()); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Errorf("expected ErrDecompressedTooLarge but got: %v", err)
+	}
+
+	// truncated compressed data should fail rather than return part of the packets
+	truncated := buf.Bytes()[:buf.Len()/2]
+	if _, err := NewCompressor().Decompress(truncated); err == nil {
+		t.Errorf("expected error when decompressing truncated data")
+	}
+}
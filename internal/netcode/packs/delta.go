@@ -0,0 +1,193 @@
+package packs
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	playerDeltaX uint8 = 1 << iota
+	playerDeltaY
+	playerDeltaHspeed
+	playerDeltaVspeed
+	// playerDeltaDirLeft is the value of DirLeft rather than whether it
+	// changed, as its cheaper than storing it in Positions or Speeds
+	playerDeltaDirLeft
+)
+
+const (
+	// positionMin and positionMax are the range of the positions in a PlayerStateDelta,
+	// players outside of this are sent in full
+	positionMin = -2048
+	positionMax = 2047
+	// PositionPrecision is the precision of the positions in a PlayerStateDelta, a 1/16th of a pixel
+	PositionPrecision = 0.0625
+)
+
+// MissingBaselinePlayer is returned by DeltaDecode if a delta refers to a
+// player that doesn't exist in the baseline
+type MissingBaselinePlayer struct {
+	netID uint16
+}
+
+func (err *MissingBaselinePlayer) Error() string {
+	return "player delta has no player in baseline with net id: " + strconv.Itoa(int(err.netID))
+}
+
+// DeltaEncode will set the players on the packet, only sending the fields that have
+// changed compared to the baseline.
+//
+// If hasBaseline is false, every player is sent in full.
+func (packet *ServerWorldStatePacket) DeltaEncode(hasBaseline bool, baselineSeqID uint16, baseline []PlayerState, players []PlayerState) {
+	packet.HasBaseline = hasBaseline
+	packet.BaselineSequenceID = 0
+	packet.Players = packet.Players[:0]
+	packet.PlayerDeltas = packet.PlayerDeltas[:0]
+	if !hasBaseline {
+		packet.Players = append(packet.Players, players...)
+		return
+	}
+	packet.BaselineSequenceID = baselineSeqID
+	for i, state := range players {
+		baseIndex := findPlayerState(baseline, state.NetID, i)
+		if baseIndex == -1 ||
+			!isDeltaPosition(state.X) ||
+			!isDeltaPosition(state.Y) {
+			// new player since the baseline or too far away to fit in a delta, send everything
+			packet.Players = append(packet.Players, state)
+			continue
+		}
+		base := &baseline[baseIndex]
+		// re-use the Positions and Speeds of the delta that was in this slot last time
+		// so we don't allocate every frame
+		n := len(packet.PlayerDeltas)
+		if n < cap(packet.PlayerDeltas) {
+			packet.PlayerDeltas = packet.PlayerDeltas[:n+1]
+		} else {
+			packet.PlayerDeltas = append(packet.PlayerDeltas, PlayerStateDelta{})
+		}
+		delta := &packet.PlayerDeltas[n]
+		delta.NetID = state.NetID
+		delta.Flags = 0
+		delta.Positions = delta.Positions[:0]
+		delta.Speeds = delta.Speeds[:0]
+		if state.X != base.X {
+			delta.Flags |= playerDeltaX
+			delta.Positions = append(delta.Positions, state.X)
+		}
+		if state.Y != base.Y {
+			delta.Flags |= playerDeltaY
+			delta.Positions = append(delta.Positions, state.Y)
+		}
+		if state.Hspeed != base.Hspeed {
+			delta.Flags |= playerDeltaHspeed
+			delta.Speeds = append(delta.Speeds, state.Hspeed)
+		}
+		if state.Vspeed != base.Vspeed {
+			delta.Flags |= playerDeltaVspeed
+			delta.Speeds = append(delta.Speeds, state.Vspeed)
+		}
+		if state.DirLeft {
+			delta.Flags |= playerDeltaDirLeft
+		}
+	}
+}
+
+// DeltaDecode will apply PlayerDeltas to the baseline and append them to Players
+// so that Players holds the full world state.
+//
+// The baseline must be the player states of the world state with BaselineSequenceID.
+// Players that were in the baseline but have no delta were removed.
+func (packet *ServerWorldStatePacket) DeltaDecode(baseline []PlayerState) error {
+	if !packet.HasBaseline {
+		return nil
+	}
+	for i, delta := range packet.PlayerDeltas {
+		baseIndex := findPlayerState(baseline, delta.NetID, i)
+		if baseIndex == -1 {
+			return &MissingBaselinePlayer{
+				netID: delta.NetID,
+			}
+		}
+		state := baseline[baseIndex]
+		positions, speeds := delta.Positions, delta.Speeds
+		ok := true
+		if delta.Flags&playerDeltaX != 0 && ok {
+			state.X, ok = nextDeltaValue(&positions)
+		}
+		if delta.Flags&playerDeltaY != 0 && ok {
+			state.Y, ok = nextDeltaValue(&positions)
+		}
+		if delta.Flags&playerDeltaHspeed != 0 && ok {
+			state.Hspeed, ok = nextDeltaValue(&speeds)
+		}
+		if delta.Flags&playerDeltaVspeed != 0 && ok {
+			state.Vspeed, ok = nextDeltaValue(&speeds)
+		}
+		if !ok || len(positions) != 0 || len(speeds) != 0 {
+			return errors.New("player delta has a mismatched number of values for net id: " + strconv.Itoa(int(delta.NetID)))
+		}
+		state.DirLeft = delta.Flags&playerDeltaDirLeft != 0
+		packet.Players = append(packet.Players, state)
+	}
+	packet.HasBaseline = false
+	packet.BaselineSequenceID = 0
+	packet.PlayerDeltas = packet.PlayerDeltas[:0]
+	return nil
+}
+
+// isDeltaPosition is true if the position can be sent in PlayerStateDelta.Positions
+func isDeltaPosition(v float32) bool {
+	return v >= positionMin && v <= positionMax
+}
+
+// nextDeltaValue removes the first value from values, ok is false if there are none left
+func nextDeltaValue(values *[]float32) (v float32, ok bool) {
+	if len(*values) == 0 {
+		return 0, false
+	}
+	v = (*values)[0]
+	*values = (*values)[1:]
+	return v, true
+}
+
+const (
+	// playerDeltaPositionFlags are the flags that have a value in PlayerStateDelta.Positions
+	playerDeltaPositionFlags = playerDeltaX | playerDeltaY
+	// playerDeltaSpeedFlags are the flags that have a value in PlayerStateDelta.Speeds
+	playerDeltaSpeedFlags = playerDeltaHspeed | playerDeltaVspeed
+)
+
+// Validate checks that the server gave us our net ID and that every delta has a
+// value for each field it says has changed
+func (packet *ServerWorldStatePacket) Validate() error {
+	if packet.MyNetID == 0 {
+		return errors.New("net id should never be 0")
+	}
+	for _, delta := range packet.PlayerDeltas {
+		if bits.OnesCount8(delta.Flags&playerDeltaPositionFlags) != len(delta.Positions) ||
+			bits.OnesCount8(delta.Flags&playerDeltaSpeedFlags) != len(delta.Speeds) {
+			return errors.New("player delta has a mismatched number of values for net id: " + strconv.Itoa(int(delta.NetID)))
+		}
+	}
+	return nil
+}
+
+// findPlayerState returns the index of the player with the given net id or -1
+// if it doesn't exist.
+//
+// Players are generally in the same order frame-to-frame, so we check
+// the hint index first before searching the whole list.
+func findPlayerState(players []PlayerState, netID uint16, hint int) int {
+	if hint < len(players) &&
+		players[hint].NetID == netID {
+		return hint
+	}
+	for i := range players {
+		if players[i].NetID == netID {
+			return i
+		}
+	}
+	return -1
+}
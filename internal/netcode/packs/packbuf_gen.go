@@ -0,0 +1,417 @@
+// Code generated by packbuf-gen. DO NOT EDIT.
+
+package packs
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
+)
+
+// MarshalPackbuf writes AckPacket without using reflection
+func (packet *AckPacket) MarshalPackbuf(e *packbuf.Encoder) error {
+	if err := e.WriteLength(len(packet.SequenceIDList)); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(AckPacket{}), Field: "SequenceIDList", Err: err}
+	}
+	for i0 := range packet.SequenceIDList {
+		if err := e.WriteUint16(packet.SequenceIDList[i0]); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(AckPacket{}), Field: "SequenceIDList", Err: err}
+		}
+	}
+	return nil
+}
+
+// UnmarshalPackbuf reads AckPacket without using reflection
+func (packet *AckPacket) UnmarshalPackbuf(d *packbuf.Decoder) error {
+	{
+		n0, err := d.ReadLength()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(AckPacket{}), Field: "SequenceIDList", Err: err}
+		}
+		if err := d.Allocate(n0, unsafe.Sizeof(packet.SequenceIDList[0])); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(AckPacket{}), Field: "SequenceIDList", Err: err}
+		}
+		if cap(packet.SequenceIDList) >= n0 {
+			packet.SequenceIDList = packet.SequenceIDList[:n0]
+		} else {
+			packet.SequenceIDList = make([]uint16, n0)
+		}
+		for i0 := range packet.SequenceIDList {
+			{
+				value, err := d.ReadUint16()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(AckPacket{}), Field: "SequenceIDList", Err: err}
+				}
+				packet.SequenceIDList[i0] = value
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalPackbuf writes ClientPlayerPacket without using reflection
+func (packet *ClientPlayerPacket) MarshalPackbuf(e *packbuf.Encoder) error {
+	if err := e.WriteSize(len(packet.InputBuffer), 10); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ClientPlayerPacket{}), Field: "InputBuffer", Err: err}
+	}
+	for i0 := range packet.InputBuffer {
+		if err := e.WriteUint16(packet.InputBuffer[i0].Frame); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ClientFrameInput{}), Field: "Frame", Err: err}
+		}
+		if err := e.WriteBool(packet.InputBuffer[i0].PlayerInput.IsHoldingLeft); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ent.PlayerInput{}), Field: "IsHoldingLeft", Err: err}
+		}
+		if err := e.WriteBool(packet.InputBuffer[i0].PlayerInput.IsHoldingRight); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ent.PlayerInput{}), Field: "IsHoldingRight", Err: err}
+		}
+		if err := e.WriteBool(packet.InputBuffer[i0].PlayerInput.IsHoldingJump); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ent.PlayerInput{}), Field: "IsHoldingJump", Err: err}
+		}
+	}
+	return nil
+}
+
+// UnmarshalPackbuf reads ClientPlayerPacket without using reflection
+func (packet *ClientPlayerPacket) UnmarshalPackbuf(d *packbuf.Decoder) error {
+	{
+		n0, err := d.ReadSize(10)
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ClientPlayerPacket{}), Field: "InputBuffer", Err: err}
+		}
+		if err := d.Allocate(n0, unsafe.Sizeof(packet.InputBuffer[0])); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ClientPlayerPacket{}), Field: "InputBuffer", Err: err}
+		}
+		if cap(packet.InputBuffer) >= n0 {
+			packet.InputBuffer = packet.InputBuffer[:n0]
+		} else {
+			packet.InputBuffer = make([]ClientFrameInput, n0)
+		}
+		for i0 := range packet.InputBuffer {
+			{
+				value, err := d.ReadUint16()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(ClientFrameInput{}), Field: "Frame", Err: err}
+				}
+				packet.InputBuffer[i0].Frame = value
+			}
+			{
+				value, err := d.ReadBool()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(ent.PlayerInput{}), Field: "IsHoldingLeft", Err: err}
+				}
+				packet.InputBuffer[i0].PlayerInput.IsHoldingLeft = value
+			}
+			{
+				value, err := d.ReadBool()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(ent.PlayerInput{}), Field: "IsHoldingRight", Err: err}
+				}
+				packet.InputBuffer[i0].PlayerInput.IsHoldingRight = value
+			}
+			{
+				value, err := d.ReadBool()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(ent.PlayerInput{}), Field: "IsHoldingJump", Err: err}
+				}
+				packet.InputBuffer[i0].PlayerInput.IsHoldingJump = value
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalPackbuf writes ServerWorldStatePacket without using reflection
+func (packet *ServerWorldStatePacket) MarshalPackbuf(e *packbuf.Encoder) error {
+	if err := e.WriteUint16(packet.MyNetID); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "MyNetID", Err: err}
+	}
+	if err := e.WriteUint16(packet.LastSimulatedInputFrame); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "LastSimulatedInputFrame", Err: err}
+	}
+	if err := e.WriteUint16(packet.ServerTick); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "ServerTick", Err: err}
+	}
+	if err := e.WriteBool(packet.HasBaseline); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "HasBaseline", Err: err}
+	}
+	if err := e.WriteUint16(packet.BaselineSequenceID); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "BaselineSequenceID", Err: err}
+	}
+	if err := e.WriteLength(len(packet.Players)); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "Players", Err: err}
+	}
+	for i0 := range packet.Players {
+		if err := e.WriteUint16(packet.Players[i0].NetID); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "NetID", Err: err}
+		}
+		if err := e.WriteFloat32(packet.Players[i0].X); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "X", Err: err}
+		}
+		if err := e.WriteFloat32(packet.Players[i0].Y); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "Y", Err: err}
+		}
+		if err := e.WriteFloat32(packet.Players[i0].Hspeed); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "Hspeed", Err: err}
+		}
+		if err := e.WriteFloat32(packet.Players[i0].Vspeed); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "Vspeed", Err: err}
+		}
+		if err := e.WriteBool(packet.Players[i0].DirLeft); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "DirLeft", Err: err}
+		}
+	}
+	if err := e.WriteLength(len(packet.PlayerDeltas)); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "PlayerDeltas", Err: err}
+	}
+	for i0 := range packet.PlayerDeltas {
+		if err := e.WriteUint16(packet.PlayerDeltas[i0].NetID); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "NetID", Err: err}
+		}
+		if err := e.WriteUint8(packet.PlayerDeltas[i0].Flags); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Flags", Err: err}
+		}
+		if err := e.WriteSize(len(packet.PlayerDeltas[i0].Positions), 2); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Positions", Err: err}
+		}
+		for i1 := range packet.PlayerDeltas[i0].Positions {
+			if err := e.WriteQuantized(float64(packet.PlayerDeltas[i0].Positions[i1]), -2048, 2047, 0.0625); err != nil {
+				return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Positions", Err: err}
+			}
+		}
+		if err := e.WriteSize(len(packet.PlayerDeltas[i0].Speeds), 2); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Speeds", Err: err}
+		}
+		for i1 := range packet.PlayerDeltas[i0].Speeds {
+			if err := e.WriteFloat32(packet.PlayerDeltas[i0].Speeds[i1]); err != nil {
+				return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Speeds", Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalPackbuf reads ServerWorldStatePacket without using reflection
+func (packet *ServerWorldStatePacket) UnmarshalPackbuf(d *packbuf.Decoder) error {
+	{
+		value, err := d.ReadUint16()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "MyNetID", Err: err}
+		}
+		packet.MyNetID = value
+	}
+	{
+		value, err := d.ReadUint16()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "LastSimulatedInputFrame", Err: err}
+		}
+		packet.LastSimulatedInputFrame = value
+	}
+	{
+		value, err := d.ReadUint16()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "ServerTick", Err: err}
+		}
+		packet.ServerTick = value
+	}
+	{
+		value, err := d.ReadBool()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "HasBaseline", Err: err}
+		}
+		packet.HasBaseline = value
+	}
+	{
+		value, err := d.ReadUint16()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "BaselineSequenceID", Err: err}
+		}
+		packet.BaselineSequenceID = value
+	}
+	{
+		n0, err := d.ReadLength()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "Players", Err: err}
+		}
+		if err := d.Allocate(n0, unsafe.Sizeof(packet.Players[0])); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "Players", Err: err}
+		}
+		if cap(packet.Players) >= n0 {
+			packet.Players = packet.Players[:n0]
+		} else {
+			packet.Players = make([]PlayerState, n0)
+		}
+		for i0 := range packet.Players {
+			{
+				value, err := d.ReadUint16()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "NetID", Err: err}
+				}
+				packet.Players[i0].NetID = value
+			}
+			{
+				value, err := d.ReadFloat32()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "X", Err: err}
+				}
+				packet.Players[i0].X = value
+			}
+			{
+				value, err := d.ReadFloat32()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "Y", Err: err}
+				}
+				packet.Players[i0].Y = value
+			}
+			{
+				value, err := d.ReadFloat32()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "Hspeed", Err: err}
+				}
+				packet.Players[i0].Hspeed = value
+			}
+			{
+				value, err := d.ReadFloat32()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "Vspeed", Err: err}
+				}
+				packet.Players[i0].Vspeed = value
+			}
+			{
+				value, err := d.ReadBool()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerState{}), Field: "DirLeft", Err: err}
+				}
+				packet.Players[i0].DirLeft = value
+			}
+		}
+	}
+	{
+		n0, err := d.ReadLength()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "PlayerDeltas", Err: err}
+		}
+		if err := d.Allocate(n0, unsafe.Sizeof(packet.PlayerDeltas[0])); err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ServerWorldStatePacket{}), Field: "PlayerDeltas", Err: err}
+		}
+		if cap(packet.PlayerDeltas) >= n0 {
+			packet.PlayerDeltas = packet.PlayerDeltas[:n0]
+		} else {
+			packet.PlayerDeltas = make([]PlayerStateDelta, n0)
+		}
+		for i0 := range packet.PlayerDeltas {
+			{
+				value, err := d.ReadUint16()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "NetID", Err: err}
+				}
+				packet.PlayerDeltas[i0].NetID = value
+			}
+			{
+				value, err := d.ReadUint8()
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Flags", Err: err}
+				}
+				packet.PlayerDeltas[i0].Flags = value
+			}
+			{
+				n1, err := d.ReadSize(2)
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Positions", Err: err}
+				}
+				if err := d.Allocate(n1, unsafe.Sizeof(packet.PlayerDeltas[i0].Positions[0])); err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Positions", Err: err}
+				}
+				if cap(packet.PlayerDeltas[i0].Positions) >= n1 {
+					packet.PlayerDeltas[i0].Positions = packet.PlayerDeltas[i0].Positions[:n1]
+				} else {
+					packet.PlayerDeltas[i0].Positions = make([]float32, n1)
+				}
+				for i1 := range packet.PlayerDeltas[i0].Positions {
+					{
+						value, err := d.ReadQuantized(-2048, 2047, 0.0625)
+						if err != nil {
+							return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Positions", Err: err}
+						}
+						packet.PlayerDeltas[i0].Positions[i1] = float32(value)
+					}
+				}
+			}
+			{
+				n1, err := d.ReadSize(2)
+				if err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Speeds", Err: err}
+				}
+				if err := d.Allocate(n1, unsafe.Sizeof(packet.PlayerDeltas[i0].Speeds[0])); err != nil {
+					return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Speeds", Err: err}
+				}
+				if cap(packet.PlayerDeltas[i0].Speeds) >= n1 {
+					packet.PlayerDeltas[i0].Speeds = packet.PlayerDeltas[i0].Speeds[:n1]
+				} else {
+					packet.PlayerDeltas[i0].Speeds = make([]float32, n1)
+				}
+				for i1 := range packet.PlayerDeltas[i0].Speeds {
+					{
+						value, err := d.ReadFloat32()
+						if err != nil {
+							return &packbuf.FieldError{Type: reflect.TypeOf(PlayerStateDelta{}), Field: "Speeds", Err: err}
+						}
+						packet.PlayerDeltas[i0].Speeds[i1] = value
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalPackbuf writes ClockSyncRequestPacket without using reflection
+func (packet *ClockSyncRequestPacket) MarshalPackbuf(e *packbuf.Encoder) error {
+	return nil
+}
+
+// UnmarshalPackbuf reads ClockSyncRequestPacket without using reflection
+func (packet *ClockSyncRequestPacket) UnmarshalPackbuf(d *packbuf.Decoder) error {
+	return nil
+}
+
+// MarshalPackbuf writes ClockSyncResponsePacket without using reflection
+func (packet *ClockSyncResponsePacket) MarshalPackbuf(e *packbuf.Encoder) error {
+	if err := e.WriteUint16(packet.RequestSequenceID); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ClockSyncResponsePacket{}), Field: "RequestSequenceID", Err: err}
+	}
+	if err := e.WriteUint16(packet.ServerTick); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ClockSyncResponsePacket{}), Field: "ServerTick", Err: err}
+	}
+	if err := e.WriteRangedUint(uint64(packet.HeldMicroseconds), 0, 1000000); err != nil {
+		return &packbuf.FieldError{Type: reflect.TypeOf(ClockSyncResponsePacket{}), Field: "HeldMicroseconds", Err: err}
+	}
+	return nil
+}
+
+// UnmarshalPackbuf reads ClockSyncResponsePacket without using reflection
+func (packet *ClockSyncResponsePacket) UnmarshalPackbuf(d *packbuf.Decoder) error {
+	{
+		value, err := d.ReadUint16()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ClockSyncResponsePacket{}), Field: "RequestSequenceID", Err: err}
+		}
+		packet.RequestSequenceID = value
+	}
+	{
+		value, err := d.ReadUint16()
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ClockSyncResponsePacket{}), Field: "ServerTick", Err: err}
+		}
+		packet.ServerTick = value
+	}
+	{
+		value, err := d.ReadRanged(0, 1000000)
+		if err != nil {
+			return &packbuf.FieldError{Type: reflect.TypeOf(ClockSyncResponsePacket{}), Field: "HeldMicroseconds", Err: err}
+		}
+		packet.HeldMicroseconds = uint32(value)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package packs
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
+)
+
+// These have the same fields as the packets but none of the generated methods,
+// so packbuf will use reflection for them
+type (
+	reflectAckPacket               AckPacket
+	reflectClientPlayerPacket      ClientPlayerPacket
+	reflectServerWorldStatePacket  ServerWorldStatePacket
+	reflectClockSyncRequestPacket  ClockSyncRequestPacket
+	reflectClockSyncResponsePacket ClockSyncResponsePacket
+)
+
+func newTestServerWorldStatePacket(playerCount int) *ServerWorldStatePacket {
+	packet := &ServerWorldStatePacket{
+		MyNetID:                 1,
+		LastSimulatedInputFrame: 65000,
+		ServerTick:              1234,
+		HasBaseline:             true,
+		BaselineSequenceID:      42,
+	}
+	for i := 0; i < playerCount; i++ {
+		packet.Players = append(packet.Players, PlayerState{
+			NetID:   uint16(i + 1),
+			X:       float32(i) * 1.5,
+			Y:       float32(i)*0.25 - 100,
+			Hspeed:  float32(i) / 3,
+			Vspeed:  -1,
+			DirLeft: i%2 == 0,
+		})
+		delta := PlayerStateDelta{
+			NetID: uint16(i + 1),
+			Flags: uint8(i),
+		}
+		for j := 0; j < i%3; j++ {
+			delta.Positions = append(delta.Positions, float32(i+j))
+			delta.Speeds = append(delta.Speeds, float32(i+j)/3)
+		}
+		packet.PlayerDeltas = append(packet.PlayerDeltas, delta)
+	}
+	return packet
+}
+
+// TestGeneratedPacketsImplementPackbuf tests that go generate has been run for every registered packet
+func TestGeneratedPacketsImplementPackbuf(t *testing.T) {
+	for _, packetType := range packetIDToType {
+		packet := reflect.New(packetType).Interface()
+		if _, ok := packet.(packbuf.PackbufMarshaler); !ok {
+			t.Errorf("%T does not implement packbuf.PackbufMarshaler, run go generate", packet)
+		}
+		if _, ok := packet.(packbuf.PackbufUnmarshaler); !ok {
+			t.Errorf("%T does not implement packbuf.PackbufUnmarshaler, run go generate", packet)
+		}
+	}
+}
+
+// TestGeneratedMatchesReflection tests that the generated code writes the exact same bytes
+// as the reflection path and that the generated code can read them back
+func TestGeneratedMatchesReflection(t *testing.T) {
+	clientPacket := &ClientPlayerPacket{
+		InputBuffer: []ClientFrameInput{
+			{Frame: 1, PlayerInput: ent.PlayerInput{IsHoldingLeft: true}},
+			{Frame: 2, PlayerInput: ent.PlayerInput{IsHoldingRight: true, IsHoldingJump: true}},
+		},
+	}
+	worldStatePacket := newTestServerWorldStatePacket(5)
+	clockSyncResponse := &ClockSyncResponsePacket{
+		RequestSequenceID: 65535,
+		ServerTick:        1234,
+		HeldMicroseconds:  3500,
+	}
+	testCases := []struct {
+		Generated  Packet
+		Reflection interface{}
+	}{
+		{
+			Generated:  &AckPacket{SequenceIDList: []uint16{1, 2, 65535}},
+			Reflection: (*reflectAckPacket)(&AckPacket{SequenceIDList: []uint16{1, 2, 65535}}),
+		},
+		{
+			Generated:  &AckPacket{},
+			Reflection: &reflectAckPacket{},
+		},
+		{
+			Generated:  clientPacket,
+			Reflection: (*reflectClientPlayerPacket)(clientPacket),
+		},
+		{
+			Generated:  worldStatePacket,
+			Reflection: (*reflectServerWorldStatePacket)(worldStatePacket),
+		},
+		{
+			Generated:  &ClockSyncRequestPacket{},
+			Reflection: &reflectClockSyncRequestPacket{},
+		},
+		{
+			Generated:  clockSyncResponse,
+			Reflection: (*reflectClockSyncResponsePacket)(clockSyncResponse),
+		},
+	}
+	for _, testCase := range testCases {
+		for _, useBits := range []bool{false, true} {
+			var generated, reflection bytes.Buffer
+			if err := writeTestPacket(&generated, testCase.Generated, useBits); err != nil {
+				t.Fatalf("unable to write %T with generated code: %v", testCase.Generated, err)
+			}
+			if err := writeTestPacket(&reflection, testCase.Reflection, useBits); err != nil {
+				t.Fatalf("unable to write %T with reflection: %v", testCase.Reflection, err)
+			}
+			if !bytes.Equal(generated.Bytes(), reflection.Bytes()) {
+				t.Fatalf("%T (bits: %v) generated code wrote:\n%x\nbut reflection wrote:\n%x", testCase.Generated, useBits, generated.Bytes(), reflection.Bytes())
+			}
+			packet := reflect.New(packetIDToType[testCase.Generated.ID()]).Interface()
+			var r io.Reader = bytes.NewReader(reflection.Bytes())
+			if useBits {
+				r = packbuf.NewBitReader(r)
+			}
+			if err := packbuf.Read(r, packet); err != nil {
+				t.Fatalf("unable to read %T with generated code: %v", packet, err)
+			}
+			if diff := DeepEqual(testCase.Generated, packet); diff != nil {
+				t.Fatalf("%T (bits: %v) read by generated code doesn't match ---- \n%v", packet, useBits, diff)
+			}
+		}
+	}
+}
+
+func writeTestPacket(buf *bytes.Buffer, packet interface{}, useBits bool) error {
+	if !useBits {
+		return packbuf.Write(buf, packet)
+	}
+	bw := packbuf.NewBitWriter(buf)
+	if err := packbuf.Write(bw, packet); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func BenchmarkServerWorldStatePacketWrite(b *testing.B) {
+	packet := newTestServerWorldStatePacket(256)
+	b.Run("Generated", func(b *testing.B) {
+		benchmarkWrite(b, packet)
+	})
+	b.Run("Reflection", func(b *testing.B) {
+		benchmarkWrite(b, (*reflectServerWorldStatePacket)(packet))
+	})
+}
+
+func BenchmarkServerWorldStatePacketRead(b *testing.B) {
+	var buf bytes.Buffer
+	if err := writeTestPacket(&buf, newTestServerWorldStatePacket(256), true); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.Run("Generated", func(b *testing.B) {
+		benchmarkRead(b, data, func() interface{} {
+			return &ServerWorldStatePacket{}
+		})
+	})
+	b.Run("Reflection", func(b *testing.B) {
+		benchmarkRead(b, data, func() interface{} {
+			return &reflectServerWorldStatePacket{}
+		})
+	})
+}
+
+func benchmarkWrite(b *testing.B, packet interface{}) {
+	var buf bytes.Buffer
+	bw := packbuf.NewBitWriter(&buf)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := packbuf.Write(bw, packet); err != nil {
+			b.Fatal(err)
+		}
+		if err := bw.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(buf.Len()))
+}
+
+func benchmarkRead(b *testing.B, data []byte, newPacket func() interface{}) {
+	var r bytes.Reader
+	br := packbuf.NewBitReader(&r)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		br.Reset(&r)
+		if err := packbuf.ReadWithLimits(br, newPacket(), decodeLimits); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
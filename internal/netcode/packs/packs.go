@@ -1,22 +1,32 @@
 // packs is a package that holds the packet data structures and associates an ID with them
 package packs
 
+//go:generate go run github.com/silbinarywolf/toy-webrtc-mmo/cmd/packbuf-gen
+
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"reflect"
 	"strconv"
+	"sync"
 
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
 )
 
+// Packet IDs used by the packets in this package, packets defined elsewhere
+// must use a different ID
 const (
-	// packetInvalid            PacketID = 0
-	packetAck                PacketID = 1
-	packetClientPlayerUpdate PacketID = 2
-	packetWorldStateUpdate   PacketID = 3
+	packetInvalid            PacketID = 0
+	PacketAck                PacketID = 1
+	PacketClientPlayerUpdate PacketID = 2
+	PacketWorldStateUpdate   PacketID = 3
+	PacketClockSyncRequest   PacketID = 4
+	PacketClockSyncResponse  PacketID = 5
 )
 
 // AckPacket is used by client/server to acknowledge that it recieved
@@ -26,29 +36,46 @@ type AckPacket struct {
 }
 
 func (packet *AckPacket) ID() PacketID {
-	return packetAck
+	return PacketAck
 }
 
 func init() {
-	register(&AckPacket{})
+	register(&AckPacket{}, ClientToServer, ServerToClient)
 }
 
 // ClientPlayerPacket is the data sent from the client to the server
 type ClientPlayerPacket struct {
-	InputBuffer []ClientFrameInput
+	// InputBuffer is capped at netconst.MaxServerInputBuffer, struct tags
+	// can't reference constants so this must be kept in sync manually
+	InputBuffer []ClientFrameInput `packbuf:"maxsize:10"`
 }
 
 func (packet *ClientPlayerPacket) ID() PacketID {
-	return packetClientPlayerUpdate
+	return PacketClientPlayerUpdate
+}
+
+// Validate checks that the client didn't send more inputs than the server will process
+// and that every input has a frame number
+func (packet *ClientPlayerPacket) Validate() error {
+	if len(packet.InputBuffer) > netconst.MaxServerInputBuffer {
+		return fmt.Errorf("sent %d inputs when the limit is %d", len(packet.InputBuffer), netconst.MaxServerInputBuffer)
+	}
+	for _, input := range packet.InputBuffer {
+		if input.Frame == 0 {
+			return errors.New("input frame should never be 0")
+		}
+	}
+	return nil
 }
 
 type ClientFrameInput struct {
+	// Frame is the frame number the input was made on, this is never 0
 	Frame uint16
 	ent.PlayerInput
 }
 
 func init() {
-	register(&ClientPlayerPacket{})
+	register(&ClientPlayerPacket{}, ClientToServer)
 }
 
 // ServerWorldStatePacket is the data sent from the server to the client
@@ -58,7 +85,19 @@ type ServerWorldStatePacket struct {
 	// this is utilized by the client to replay inputs that haven't been processed
 	// by the server yet
 	LastSimulatedInputFrame uint16
-	Players                 []PlayerState
+	// ServerTick is how many times the server has updated the world when this world state
+	// was taken, world states aren't sent every tick so clients use this to interpolate between them
+	ServerTick uint16
+	// HasBaseline is true if PlayerDeltas were encoded against the world state
+	// sent with BaselineSequenceID, which the client has acknowledged.
+	HasBaseline        bool
+	BaselineSequenceID uint16
+	// Players are the players sent in full, this is every player if there
+	// is no baseline or players that didn't exist in the baseline.
+	Players []PlayerState
+	// PlayerDeltas are the players that existed in the baseline, with
+	// only the fields that have changed.
+	PlayerDeltas []PlayerStateDelta
 }
 
 // PlayerState is the player state information we want to send per frame
@@ -74,17 +113,62 @@ type PlayerState struct {
 	DirLeft        bool
 }
 
+// PlayerStateDelta is a PlayerState that only holds the fields that changed
+// from the same player in the baseline world state
+type PlayerStateDelta struct {
+	NetID uint16
+	// Flags holds which fields have changed as well as the value of DirLeft
+	Flags uint8
+	// Positions are the changed fields in order of X, Y, these are sent as 16-bit
+	// fixed point numbers, see PositionPrecision
+	Positions []float32 `packbuf:"maxsize:2;quantize:-2048,2047,0.0625"`
+	// Speeds are the changed fields in order of Hspeed, Vspeed
+	Speeds []float32 `packbuf:"maxsize:2"`
+}
+
 func (packet *ServerWorldStatePacket) ID() PacketID {
-	return packetWorldStateUpdate
+	return PacketWorldStateUpdate
 }
 
 func init() {
-	register(&ServerWorldStatePacket{})
+	register(&ServerWorldStatePacket{}, ServerToClient)
 }
 
-type PacketID uint8
+// ClockSyncRequestPacket is sent by the client to find out the servers tick, the server
+// replies with a ClockSyncResponsePacket.
+//
+// The client times the round trip with the sequence ID this was sent with.
+type ClockSyncRequestPacket struct {
+}
+
+func (packet *ClockSyncRequestPacket) ID() PacketID {
+	return PacketClockSyncRequest
+}
+
+func init() {
+	register(&ClockSyncRequestPacket{}, ClientToServer)
+}
+
+// ClockSyncResponsePacket is the servers reply to a ClockSyncRequestPacket
+type ClockSyncResponsePacket struct {
+	// RequestSequenceID is the sequence ID of the request this is replying to
+	RequestSequenceID uint16
+	// ServerTick is the servers tick when this reply was sent
+	ServerTick uint16
+	// HeldMicroseconds is how long the server held onto the request before replying,
+	// this time isn't part of the round trip. Requests are never held for more than a second.
+	HeldMicroseconds uint32 `packbuf:"range:0,1000000"`
+}
 
-var packetIDToType = make(map[PacketID]reflect.Type)
+func (packet *ClockSyncResponsePacket) ID() PacketID {
+	return PacketClockSyncResponse
+}
+
+func init() {
+	register(&ClockSyncResponsePacket{}, ServerToClient)
+}
+
+type PacketID uint8
 
 type Packet interface {
 	ID() PacketID
@@ -98,45 +182,184 @@ func (err *InvalidPacketID) Error() string {
 	return "invalid packet id: " + strconv.Itoa(int(err.id))
 }
 
-func register(packet Packet) {
-	id := packet.ID()
-	if _, ok := packetIDToType[id]; ok {
-		panic("cannot register a packet with the same id twice: " + strconv.Itoa(int(id)))
+// decodeLimits is the most memory that reading a single packet can allocate.
+//
+// This is a lot smaller than packbuf.DefaultLimits as we read untrusted data from every
+// client every frame and packets should fit in ~1200 bytes anyway.
+var decodeLimits = packbuf.Limits{
+	MaxBytes:    65536,
+	MaxElements: 4096,
+}
+
+// ReadError is returned when the packet ID was read but the rest of the packet
+// couldn't be, errors.Is can be used to check for the underlying error
+type ReadError struct {
+	ID  PacketID
+	Err error
+}
+
+func (err *ReadError) Error() string {
+	return "unable to read packet id " + strconv.Itoa(int(err.ID)) + ": " + err.Err.Error()
+}
+
+func (err *ReadError) Unwrap() error {
+	return err.Err
+}
+
+func newReadError(id PacketID, err error) *ReadError {
+	if errors.Is(err, io.EOF) {
+		// the packet ID was read so we shouldn't be at the end yet
+		err = fmt.Errorf("%w: %v", io.ErrUnexpectedEOF, err)
+	}
+	return &ReadError{
+		ID:  id,
+		Err: err,
 	}
-	packetIDToType[id] = reflect.TypeOf(packet).Elem()
 }
 
+// headerSize is the size of the packet ID and sequence ID written before every packet
+const headerSize = 3
+
+// Read reads the next packet from r into a newly allocated packet, use Reader to
+// re-use packets instead.
+//
+// This will read any registered packet, use Registry.Read to only read packets sent in
+// one direction.
 func Read(r io.Reader) (uint16, Packet, error) {
-	var packetID PacketID
-	if err := binary.Read(r, binary.LittleEndian, &packetID); err != nil {
+	return read(r, packetIDToType)
+}
+
+func read(r io.Reader, idToType map[PacketID]reflect.Type) (uint16, Packet, error) {
+	var header [headerSize]byte
+	packetID, seqID, err := readHeader(r, &header, idToType)
+	if err != nil {
 		return 0, nil, err
 	}
-	packetType, ok := packetIDToType[packetID]
-	if !ok {
-		return 0, nil, &InvalidPacketID{
-			id: packetID,
-		}
+	packet := reflect.New(idToType[packetID]).Interface().(Packet)
+	if err := readBody(packbuf.NewBitReader(r), packetID, packet); err != nil {
+		return 0, nil, err
 	}
-	var seqID uint16
-	if err := binary.Read(r, binary.LittleEndian, &seqID); err != nil {
+	return seqID, packet, nil
+}
+
+// Reader reads every packet with the same ID into the same packet, so once every
+// packet type has been read at least once, reading won't allocate.
+//
+// A packet returned by Read is only valid until the next packet with the same ID
+// is read, so any slices that need to be kept must be copied.
+type Reader struct {
+	// Registry holds the packets that can be read, if nil any registered packet can be read
+	Registry *Registry
+
+	packets map[PacketID]Packet
+	header  [headerSize]byte
+	br      packbuf.BitReader
+}
+
+// Read reads the next packet from r
+func (reader *Reader) Read(r io.Reader) (uint16, Packet, error) {
+	idToType := packetIDToType
+	if reader.Registry != nil {
+		idToType = reader.Registry.idToType
+	}
+	packetID, seqID, err := readHeader(r, &reader.header, idToType)
+	if err != nil {
 		return 0, nil, err
 	}
-	packet := reflect.New(packetType).Interface().(Packet)
-	if err := packbuf.Read(r, packet); err != nil {
+	packet, ok := reader.packets[packetID]
+	if !ok {
+		if reader.packets == nil {
+			reader.packets = make(map[PacketID]Packet)
+		}
+		packet = reflect.New(idToType[packetID]).Interface().(Packet)
+		reader.packets[packetID] = packet
+	}
+	reader.br.Reset(r)
+	if err := readBody(&reader.br, packetID, packet); err != nil {
 		return 0, nil, err
 	}
 	return seqID, packet, nil
 }
 
-func Write(w io.Writer, rtt *rtt.RoundTripTracking, packet Packet) error {
-	if err := binary.Write(w, binary.LittleEndian, packet.ID()); err != nil {
-		return err
+// readHeader reads the packet ID and sequence ID written before every packet
+func readHeader(r io.Reader, header *[headerSize]byte, idToType map[PacketID]reflect.Type) (PacketID, uint16, error) {
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return 0, 0, err
+	}
+	packetID := PacketID(header[0])
+	if _, ok := idToType[packetID]; !ok {
+		return 0, 0, &InvalidPacketID{
+			id: packetID,
+		}
 	}
-	if err := binary.Write(w, binary.LittleEndian, rtt.Next()); err != nil {
-		return err
+	if _, err := io.ReadFull(r, header[1:]); err != nil {
+		return 0, 0, newReadError(packetID, err)
 	}
-	if err := packbuf.Write(w, packet); err != nil {
-		return err
+	return packetID, binary.LittleEndian.Uint16(header[1:]), nil
+}
+
+// readBody reads the packet data written after the header
+func readBody(br *packbuf.BitReader, packetID PacketID, packet Packet) error {
+	// packet data is bit-packed and padded to the next byte, so
+	// the next packet in the datagram starts on a byte boundary
+	if err := packbuf.ReadWithLimits(br, packet, decodeLimits); err != nil {
+		return newReadError(packetID, err)
 	}
+	br.Align()
 	return nil
 }
+
+func Write(w io.Writer, rtt *rtt.RoundTripTracking, packet Packet) error {
+	_, err := WriteSequenced(w, rtt, packet)
+	return err
+}
+
+// writeBufferPool holds the buffers WriteSequenced uses so it doesn't allocate every call
+var writeBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+// WriteSequenced is the same as Write but also returns the sequence ID the packet was
+// written with, so that callers can tell when that packet has been acknowledged
+func WriteSequenced(w io.Writer, rtt *rtt.RoundTripTracking, packet Packet) (uint16, error) {
+	buf := writeBufferPool.Get().(*[]byte)
+	defer writeBufferPool.Put(buf)
+	data, seqID, err := AppendPacket((*buf)[:0], rtt, packet)
+	*buf = data
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return 0, err
+	}
+	return seqID, nil
+}
+
+// AppendPacket appends the packet to dst and returns the sequence ID it was written with,
+// this won't allocate if dst has enough capacity.
+//
+// If the packet can't be written, dst is returned unchanged and no sequence ID is used.
+func AppendPacket(dst []byte, rtt *rtt.RoundTripTracking, packet Packet) ([]byte, uint16, error) {
+	start := len(dst)
+	// the sequence ID is filled in once the packet is written, so that we don't
+	// use one for a packet we never send, as it'd count as lost
+	dst = append(dst, byte(packet.ID()), 0, 0)
+	// packet data is bit-packed and padded to the next byte, so
+	// the next packet in the datagram starts on a byte boundary
+	dst, err := packbuf.Append(dst, packet)
+	if err != nil {
+		return dst[:start], 0, err
+	}
+	var seqID uint16
+	if _, ok := packet.(*AckPacket); ok {
+		// acknowledgements aren't acknowledged, so we'd count every one as lost
+		seqID = rtt.NextUntracked()
+	} else {
+		seqID = rtt.Next()
+	}
+	dst[start+1] = byte(seqID)
+	dst[start+2] = byte(seqID >> 8)
+	return dst, seqID, nil
+}
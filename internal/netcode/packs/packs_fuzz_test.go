@@ -0,0 +1,53 @@
+// +build go1.18
+
+package packs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+// FuzzRead tests that reading untrusted data never panics and that any packet
+// we manage to read can be written back out.
+//
+// Run with: go test -tags headless -fuzz FuzzRead ./internal/netcode/packs
+func FuzzRead(f *testing.F) {
+	writerRtt := &rtt.RoundTripTracking{}
+	for _, packetType := range packetIDToType {
+		packet := reflect.New(packetType).Interface().(Packet)
+		writer := bytes.NewBuffer(nil)
+		if err := Write(writer, writerRtt, packet); err != nil {
+			f.Fatalf("Failed Packet.Write: %v\n", err)
+		}
+		f.Add(writer.Bytes())
+	}
+	{
+		writer := bytes.NewBuffer(nil)
+		if err := Write(writer, writerRtt, &ClientPlayerPacket{
+			InputBuffer: []ClientFrameInput{{Frame: 1}, {Frame: 2}},
+		}); err != nil {
+			f.Fatalf("Failed Packet.Write: %v\n", err)
+		}
+		if err := Write(writer, writerRtt, &AckPacket{
+			SequenceIDList: []uint16{1, 2, 3},
+		}); err != nil {
+			f.Fatalf("Failed Packet.Write: %v\n", err)
+		}
+		f.Add(writer.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		for {
+			_, packet, err := Read(reader)
+			if err != nil {
+				return
+			}
+			if err := Write(bytes.NewBuffer(nil), writerRtt, packet); err != nil {
+				t.Fatalf("able to read packet %T but not write it: %v", packet, err)
+			}
+		}
+	})
+}
@@ -7,9 +7,13 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
 )
 
@@ -80,6 +84,331 @@ func TestPacketDoubleWriteAndDoubleRead(t *testing.T) {
 	}
 }
 
+// TestClientPlayerPacketIsBitPacked tests that the bools of each input are packed as bits
+func TestClientPlayerPacketIsBitPacked(t *testing.T) {
+	packet := &ClientPlayerPacket{
+		InputBuffer: []ClientFrameInput{
+			{Frame: 1},
+			{Frame: 2},
+		},
+	}
+	packet.InputBuffer[0].IsHoldingJump = true
+	packet.InputBuffer[1].IsHoldingLeft = true
+	writer := bytes.NewBuffer(nil)
+	if err := Write(writer, &rtt.RoundTripTracking{}, packet); err != nil {
+		t.Fatalf("Failed Packet.Write: %v\n", err)
+	}
+	// 3 byte header then 4-bit slice length (maxsize:10), 16-bit frame and 3 bits of input per frame
+	const expectedSize = 3 + 6
+	if writer.Len() != expectedSize {
+		t.Fatalf("expected packet size of %d but got %d", expectedSize, writer.Len())
+	}
+	_, packetOutput, err := Read(bytes.NewReader(writer.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed Packet.Read: %v\n", err)
+	}
+	if diff := DeepEqual(packet, packetOutput); diff != nil {
+		t.Fatalf("Unable to serialize/deserialize packet: %T ---- \n%v", packet, diff)
+	}
+}
+
+// TestClientPlayerPacketMaxSize tests that the input buffer limit in the struct tag
+// matches netconst.MaxServerInputBuffer and is enforced
+func TestClientPlayerPacketMaxSize(t *testing.T) {
+	field, _ := reflect.TypeOf(ClientPlayerPacket{}).FieldByName("InputBuffer")
+	if tag, expected := field.Tag.Get("packbuf"), "maxsize:"+strconv.Itoa(netconst.MaxServerInputBuffer); tag != expected {
+		t.Fatalf("expected InputBuffer to have tag %q but got %q", expected, tag)
+	}
+	packet := &ClientPlayerPacket{
+		InputBuffer: make([]ClientFrameInput, netconst.MaxServerInputBuffer+1),
+	}
+	if err := Write(bytes.NewBuffer(nil), &rtt.RoundTripTracking{}, packet); err == nil {
+		t.Fatalf("expected error when writing more than %d inputs", netconst.MaxServerInputBuffer)
+	}
+}
+
+// TestPlayerStateDeltaPositions tests that the quantize range in the struct tag matches the
+// range DeltaEncode checks positions against
+func TestPlayerStateDeltaPositions(t *testing.T) {
+	field, _ := reflect.TypeOf(PlayerStateDelta{}).FieldByName("Positions")
+	if tag, expected := field.Tag.Get("packbuf"), fmt.Sprintf("maxsize:2;quantize:%v,%v,%v", positionMin, positionMax, PositionPrecision); tag != expected {
+		t.Fatalf("expected Positions to have tag %q but got %q", expected, tag)
+	}
+}
+
+// TestServerWorldStateDelta tests that a delta-encoded world state can be written, read
+// and then decoded back into the full world state
+func TestServerWorldStateDelta(t *testing.T) {
+	baseline := []PlayerState{
+		{NetID: 1, X: 180, Y: 180},
+		{NetID: 2, X: 300, Y: 528, Hspeed: 4, DirLeft: true},
+		{NetID: 3, X: 500, Y: 528},
+		{NetID: 5, X: 2000, Y: 528},
+	}
+	players := []PlayerState{
+		// unchanged
+		{NetID: 1, X: 180, Y: 180},
+		// player 3 left, player 4 joined and player 2 moved
+		{NetID: 4, X: 180, Y: 180},
+		// speeds aren't quantized like positions, so they should be exactly the same
+		{NetID: 2, X: 304, Y: 528, Hspeed: 4, Vspeed: -11.55, DirLeft: false},
+		// walked past where positions can be sent in a delta, so they're sent in full
+		{NetID: 5, X: 2048.5, Y: 528},
+	}
+	var packet ServerWorldStatePacket
+	packet.MyNetID = 1
+	packet.DeltaEncode(true, 10, baseline, players)
+	if len(packet.Players) != 2 || len(packet.PlayerDeltas) != 2 {
+		t.Fatalf("expected 2 full players and 2 deltas, got %d full and %d deltas", len(packet.Players), len(packet.PlayerDeltas))
+	}
+	writer := bytes.NewBuffer(nil)
+	if err := Write(writer, &rtt.RoundTripTracking{}, &packet); err != nil {
+		t.Fatalf("Failed Packet.Write: %v\n", err)
+	}
+	_, packetOutput, err := Read(bytes.NewReader(writer.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed Packet.Read: %v\n", err)
+	}
+	output := packetOutput.(*ServerWorldStatePacket)
+	if !output.HasBaseline || output.BaselineSequenceID != 10 {
+		t.Fatalf("expected baseline of 10, got %v (has baseline: %v)", output.BaselineSequenceID, output.HasBaseline)
+	}
+	if err := output.DeltaDecode(baseline); err != nil {
+		t.Fatalf("Failed DeltaDecode: %v", err)
+	}
+	if len(output.Players) != len(players) {
+		t.Fatalf("expected %d players, got %d", len(players), len(output.Players))
+	}
+	for _, expected := range players {
+		index := findPlayerState(output.Players, expected.NetID, 0)
+		if index == -1 {
+			t.Fatalf("missing player with net id %d after decoding", expected.NetID)
+		}
+		if diff := DeepEqual(expected, output.Players[index]); diff != nil {
+			t.Errorf("Unable to delta encode/decode player %d ---- \n%v", expected.NetID, diff)
+		}
+	}
+
+	// Without a baseline, everything should be sent in full
+	packet.DeltaEncode(false, 0, nil, players)
+	if packet.HasBaseline || len(packet.Players) != len(players) || len(packet.PlayerDeltas) != 0 {
+		t.Fatalf("expected all players to be sent in full without a baseline")
+	}
+}
+
+// TestAppendPacketMatchesWrite tests that AppendPacket writes the same bytes as Write
+// onto the end of the given slice
+func TestAppendPacketMatchesWrite(t *testing.T) {
+	packet := newTestServerWorldStatePacket(10)
+	writer := bytes.NewBuffer(nil)
+	if err := Write(writer, &rtt.RoundTripTracking{}, packet); err != nil {
+		t.Fatalf("Failed Packet.Write: %v\n", err)
+	}
+	data, _, err := AppendPacket([]byte{0xFF}, &rtt.RoundTripTracking{}, packet)
+	if err != nil {
+		t.Fatalf("Failed AppendPacket: %v\n", err)
+	}
+	if data[0] != 0xFF || !bytes.Equal(data[1:], writer.Bytes()) {
+		t.Fatalf("AppendPacket wrote:\n%x\nbut Write wrote:\n%x", data, writer.Bytes())
+	}
+}
+
+// TestAppendPacketErrorKeepsSequenceID tests that a packet that fails to be written doesn't
+// use up a sequence ID, as it'd never be acknowledged
+func TestAppendPacketErrorKeepsSequenceID(t *testing.T) {
+	var tracker rtt.RoundTripTracking
+	badPacket := &ClientPlayerPacket{
+		InputBuffer: make([]ClientFrameInput, netconst.MaxServerInputBuffer+1),
+	}
+	data, _, err := AppendPacket([]byte{0xFF}, &tracker, badPacket)
+	if err == nil {
+		t.Fatalf("expected error when writing more than %d inputs", netconst.MaxServerInputBuffer)
+	}
+	if len(data) != 1 || data[0] != 0xFF {
+		t.Fatalf("expected data to be unchanged but got: %x", data)
+	}
+	data, seqID, err := AppendPacket(data, &tracker, &ClientPlayerPacket{})
+	if err != nil {
+		t.Fatalf("Failed AppendPacket: %v\n", err)
+	}
+	if seqID != 0 || data[2] != 0 || data[3] != 0 {
+		t.Fatalf("expected sequence ID 0 to be used after the failed write but got %d: %x", seqID, data)
+	}
+}
+
+// TestAckPacketsAreNotLost tests that acknowledgements, which are never acknowledged
+// themselves, don't count towards packet loss
+func TestAckPacketsAreNotLost(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	var tracker rtt.RoundTripTracking
+	tracker.Init(rtt.Options{Clock: clk})
+	ackPacket := &AckPacket{SequenceIDList: []uint16{1, 2, 3}}
+	packet := newTestServerWorldStatePacket(1)
+	var buf []byte
+	for i := 0; i < 4*tracker.Capacity(); i++ {
+		var err error
+		buf, _, err = AppendPacket(buf[:0], &tracker, ackPacket)
+		if err != nil {
+			t.Fatalf("Failed AppendPacket: %v\n", err)
+		}
+		var seqID uint16
+		buf, seqID, err = AppendPacket(buf, &tracker, packet)
+		if err != nil {
+			t.Fatalf("Failed AppendPacket: %v\n", err)
+		}
+		clk.Advance(30 * time.Millisecond)
+		tracker.Ack(seqID)
+		clk.Advance(time.Second/netconst.TickRate - 30*time.Millisecond)
+	}
+	if stats := tracker.Stats(); stats.PacketLoss != 0 {
+		t.Errorf("expected no packet loss on a lossless connection but got %.1f%%", stats.PacketLoss)
+	}
+}
+
+// TestReaderReusesPackets tests that Reader reads into the same packet each time and
+// that nothing is left over from larger packets read before it
+func TestReaderReusesPackets(t *testing.T) {
+	var reader Reader
+	var lastPacket Packet
+	for _, playerCount := range []int{10, 3, 0, 10} {
+		data, _, err := AppendPacket(nil, &rtt.RoundTripTracking{}, newTestServerWorldStatePacket(playerCount))
+		if err != nil {
+			t.Fatalf("Failed AppendPacket: %v\n", err)
+		}
+		_, packet, err := reader.Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed Reader.Read: %v\n", err)
+		}
+		if lastPacket != nil && packet != lastPacket {
+			t.Fatalf("expected Reader to re-use the same packet")
+		}
+		lastPacket = packet
+		// compare what was written, as re-used slices will be empty rather than nil
+		output, _, err := AppendPacket(nil, &rtt.RoundTripTracking{}, packet)
+		if err != nil {
+			t.Fatalf("Failed AppendPacket: %v\n", err)
+		}
+		if !bytes.Equal(data, output) {
+			t.Fatalf("packet with %d players read by Reader doesn't match:\n%x\n%x", playerCount, data, output)
+		}
+	}
+}
+
+// testWorldStateFrame does what the server and client do with world state
+// packets every frame, encoding, writing, reading and decoding them
+type testWorldStateFrame struct {
+	baseline, players []PlayerState
+
+	rtt      rtt.RoundTripTracking
+	checksum Checksum
+	packet   ServerWorldStatePacket
+	buf      []byte
+	r        bytes.Reader
+	reader   Reader
+}
+
+func newTestWorldStateFrame(playerCount int) *testWorldStateFrame {
+	frame := &testWorldStateFrame{}
+	frame.checksum = NewChecksum(1)
+	frame.baseline = newTestServerWorldStatePacket(playerCount).Players
+	frame.players = append([]PlayerState(nil), frame.baseline...)
+	for i := range frame.players {
+		if i%2 == 0 {
+			frame.players[i].X += 4
+		}
+	}
+	return frame
+}
+
+func (frame *testWorldStateFrame) run() error {
+	frame.packet.DeltaEncode(true, 1, frame.baseline, frame.players)
+	var err error
+	frame.buf, _, err = AppendPacket(frame.checksum.AppendHeader(frame.buf[:0]), &frame.rtt, &frame.packet)
+	if err != nil {
+		return err
+	}
+	frame.checksum.Seal(frame.buf)
+	payload, err := frame.checksum.Open(frame.buf)
+	if err != nil {
+		return err
+	}
+	frame.r.Reset(payload)
+	_, packet, err := frame.reader.Read(&frame.r)
+	if err != nil {
+		return err
+	}
+	return packet.(*ServerWorldStatePacket).DeltaDecode(frame.baseline)
+}
+
+// TestWorldStateFrameDoesNotAllocate tests that once buffers have grown to fit,
+// sending and receiving the world state every frame doesn't allocate
+func TestWorldStateFrameDoesNotAllocate(t *testing.T) {
+	frame := newTestWorldStateFrame(64)
+	if err := frame.run(); err != nil {
+		t.Fatalf("Failed frame: %v", err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := frame.run(); err != nil {
+			t.Fatalf("Failed frame: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations per frame but got %v", allocs)
+	}
+}
+
+func BenchmarkWorldStateFrame(b *testing.B) {
+	frame := newTestWorldStateFrame(256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := frame.run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	data, _, err := AppendPacket(nil, &rtt.RoundTripTracking{}, newTestServerWorldStatePacket(256))
+	if err != nil {
+		b.Fatal(err)
+	}
+	var r bytes.Reader
+	b.Run("Read", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r.Reset(data)
+			if _, _, err := Read(&r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Reader", func(b *testing.B) {
+		var reader Reader
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r.Reset(data)
+			if _, _, err := reader.Read(&r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppendPacket(b *testing.B) {
+	packet := newTestServerWorldStatePacket(256)
+	var tracker rtt.RoundTripTracking
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		buf, _, err = AppendPacket(buf[:0], &tracker, packet)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(len(buf)))
+}
+
 // Below is a copy-paste of
 // https://github.com/go-test/deep/commit/8ed16920c079d9f721f068f915e1539e9ef3236c
 
@@ -0,0 +1,107 @@
+package packs
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+var (
+	// ClientToServer are the packets clients send to the server, the server will
+	// not read any other packet
+	ClientToServer = NewRegistry()
+	// ServerToClient are the packets the server sends to clients, clients will
+	// not read any other packet
+	ServerToClient = NewRegistry()
+)
+
+// packetIDToType is every packet registered with any Registry, packet IDs are shared
+// between every Registry so that an ID always means the same packet type
+var packetIDToType = make(map[PacketID]reflect.Type)
+
+// Registry holds the packet types that can be read.
+//
+// Packets should be registered from an init function, ie.
+//
+//	func init() {
+//		packs.ClientToServer.MustRegister(&MyPacket{})
+//	}
+type Registry struct {
+	idToType map[PacketID]reflect.Type
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		idToType: make(map[PacketID]reflect.Type),
+	}
+}
+
+// PacketIDConflict is returned by Registry.Register if the packet ID is already used
+// by a different packet type or the packet has already been registered
+type PacketIDConflict struct {
+	ID       PacketID
+	Existing reflect.Type
+	Packet   reflect.Type
+}
+
+func (err *PacketIDConflict) Error() string {
+	if err.Existing == err.Packet {
+		return "packet " + err.Packet.String() + " is already registered with id " + strconv.Itoa(int(err.ID))
+	}
+	return "cannot register " + err.Packet.String() + " with packet id " + strconv.Itoa(int(err.ID)) + ", it's already used by " + err.Existing.String()
+}
+
+// Register adds the packet type with the ID returned by its ID method, packet must be
+// a pointer to a struct.
+//
+// An error is returned if the ID is already used by a different packet type in any
+// Registry, or if the packet has already been registered with this Registry.
+func (registry *Registry) Register(packet Packet) error {
+	id := packet.ID()
+	if id == packetInvalid {
+		return errors.New("cannot register packet with id of 0")
+	}
+	t := reflect.TypeOf(packet)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return errors.New("cannot register " + t.String() + ", packets must be a pointer to a struct")
+	}
+	t = t.Elem()
+	if existing, ok := packetIDToType[id]; ok && existing != t {
+		return &PacketIDConflict{
+			ID:       id,
+			Existing: existing,
+			Packet:   t,
+		}
+	}
+	if existing, ok := registry.idToType[id]; ok {
+		return &PacketIDConflict{
+			ID:       id,
+			Existing: existing,
+			Packet:   t,
+		}
+	}
+	registry.idToType[id] = t
+	packetIDToType[id] = t
+	return nil
+}
+
+// MustRegister is the same as Register but panics if the packet can't be registered
+func (registry *Registry) MustRegister(packet Packet) {
+	if err := registry.Register(packet); err != nil {
+		panic(err)
+	}
+}
+
+// Read reads the next packet from r into a newly allocated packet, InvalidPacketID is
+// returned if the packet wasn't registered with this Registry
+func (registry *Registry) Read(r io.Reader) (uint16, Packet, error) {
+	return read(r, registry.idToType)
+}
+
+// register adds one of the packets defined in this package to each registry
+func register(packet Packet, registries ...*Registry) {
+	for _, registry := range registries {
+		registry.MustRegister(packet)
+	}
+}
@@ -0,0 +1,78 @@
+package packs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+// conflictingPacket uses the same ID as AckPacket
+type conflictingPacket struct{}
+
+func (packet *conflictingPacket) ID() PacketID {
+	return PacketAck
+}
+
+type invalidIDPacket struct{}
+
+func (packet *invalidIDPacket) ID() PacketID {
+	return packetInvalid
+}
+
+func TestRegistryConflicts(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register(&AckPacket{}); err != nil {
+		t.Fatalf("unable to register packet: %v", err)
+	}
+	var conflict *PacketIDConflict
+	if err := registry.Register(&AckPacket{}); !errors.As(err, &conflict) {
+		t.Errorf("expected PacketIDConflict when registering the same packet twice but got: %v", err)
+	}
+	// packet IDs are shared between registries
+	if err := NewRegistry().Register(&conflictingPacket{}); !errors.As(err, &conflict) {
+		t.Errorf("expected PacketIDConflict when registering a different packet with the same ID but got: %v", err)
+	}
+	if err := NewRegistry().Register(&invalidIDPacket{}); err == nil {
+		t.Errorf("expected error when registering a packet with an ID of 0")
+	}
+}
+
+// TestRegistryRejectsOtherDirection tests that a packet only sent by the server
+// can't be read by the server and vice versa
+func TestRegistryRejectsOtherDirection(t *testing.T) {
+	testCases := []struct {
+		Packet  Packet
+		Allowed *Registry
+		Denied  *Registry
+	}{
+		{
+			Packet:  &ClientPlayerPacket{},
+			Allowed: ClientToServer,
+			Denied:  ServerToClient,
+		},
+		{
+			Packet:  &ServerWorldStatePacket{},
+			Allowed: ServerToClient,
+			Denied:  ClientToServer,
+		},
+	}
+	for _, testCase := range testCases {
+		data, _, err := AppendPacket(nil, &rtt.RoundTripTracking{}, testCase.Packet)
+		if err != nil {
+			t.Fatalf("Failed AppendPacket: %v", err)
+		}
+		if _, _, err := testCase.Allowed.Read(bytes.NewReader(data)); err != nil {
+			t.Errorf("expected %T to be read but got: %v", testCase.Packet, err)
+		}
+		var invalidID *InvalidPacketID
+		if _, _, err := testCase.Denied.Read(bytes.NewReader(data)); !errors.As(err, &invalidID) {
+			t.Errorf("expected InvalidPacketID for %T but got: %v", testCase.Packet, err)
+		}
+		reader := Reader{Registry: testCase.Denied}
+		if _, _, err := reader.Read(bytes.NewReader(data)); !errors.As(err, &invalidID) {
+			t.Errorf("expected Reader to return InvalidPacketID for %T but got: %v", testCase.Packet, err)
+		}
+	}
+}
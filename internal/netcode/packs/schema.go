@@ -0,0 +1,58 @@
+package packs
+
+import (
+	"sort"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
+)
+
+// Schema describes every registered packet and how it's encoded, so packets can be
+// read and written outside of Go. See cmd/packschema.
+//
+// Each packet is written as its ID as a uint8, its sequence ID as a little-endian uint16,
+// then the fields of its struct bit-packed and padded to the next byte.
+type Schema struct {
+	// ProtocolID salts the checksum at the start of each datagram, see Checksum
+	ProtocolID uint32         `json:"protocolId"`
+	Packets    []PacketSchema `json:"packets"`
+	packbuf.Schema
+}
+
+type PacketSchema struct {
+	ID PacketID `json:"id"`
+	// Struct is the name of the packet struct in Schema.Structs
+	Struct string `json:"struct"`
+	// ClientToServer is true if the packet is registered with ClientToServer
+	ClientToServer bool `json:"clientToServer"`
+	// ServerToClient is true if the packet is registered with ServerToClient
+	ServerToClient bool `json:"serverToClient"`
+}
+
+// NewSchema describes every registered packet, ordered by packet ID
+func NewSchema(protocolID uint32) (*Schema, error) {
+	ids := make([]PacketID, 0, len(packetIDToType))
+	for id := range packetIDToType {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	schema := &Schema{
+		ProtocolID: protocolID,
+	}
+	for _, id := range ids {
+		name, err := schema.DescribeStruct(packetIDToType[id])
+		if err != nil {
+			return nil, err
+		}
+		_, isClientToServer := ClientToServer.idToType[id]
+		_, isServerToClient := ServerToClient.idToType[id]
+		schema.Packets = append(schema.Packets, PacketSchema{
+			ID:             id,
+			Struct:         name,
+			ClientToServer: isClientToServer,
+			ServerToClient: isServerToClient,
+		})
+	}
+	return schema, nil
+}
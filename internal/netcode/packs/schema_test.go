@@ -0,0 +1,361 @@
+package packs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packbuf"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+// schemaCodec reads and writes packets using only what's in a Schema, the same
+// way tools outside of Go would
+type schemaCodec struct {
+	schema *Schema
+}
+
+func (c *schemaCodec) decode(br *packbuf.BitReader, t *packbuf.TypeSchema) (interface{}, error) {
+	switch t.Kind {
+	case packbuf.KindBool:
+		return br.ReadBool()
+	case packbuf.KindPointer:
+		isSet, err := br.ReadBool()
+		if err != nil || !isSet {
+			return nil, err
+		}
+		return c.decode(br, t.Elem)
+	case packbuf.KindStruct:
+		structSchema := c.schema.Struct(t.Struct)
+		if structSchema == nil {
+			return nil, errors.New("missing struct: " + t.Struct)
+		}
+		fields := make(map[string]interface{})
+		for _, field := range structSchema.Fields {
+			v, err := c.decode(br, field.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields[field.Name] = v
+		}
+		return fields, nil
+	case packbuf.KindSlice, packbuf.KindArray, packbuf.KindMap:
+		n := t.Len
+		if t.Kind != packbuf.KindArray {
+			length, err := c.decodeLength(br, t, 32)
+			if err != nil {
+				return nil, err
+			}
+			n = length
+		}
+		var values []interface{}
+		for i := 0; i < n; i++ {
+			if t.Key != nil {
+				key, err := c.decode(br, t.Key)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, key)
+			}
+			v, err := c.decode(br, t.Elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case packbuf.KindString:
+		n, err := c.decodeLength(br, t, 16)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	if q := t.Quantize; q != nil {
+		v, err := br.ReadBits(uint(q.Bits))
+		if err != nil {
+			return nil, err
+		}
+		return q.Min + float64(v)*q.Precision, nil
+	}
+	if r := t.Range; r != nil {
+		v, err := br.ReadBits(uint(r.Bits))
+		if err != nil {
+			return nil, err
+		}
+		return r.Min + int64(v), nil
+	}
+	bitSize, err := schemaBitSize(t.Kind)
+	if err != nil {
+		return nil, err
+	}
+	v, err := br.ReadBits(bitSize)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Kind {
+	case packbuf.KindFloat32:
+		return float64(math.Float32frombits(uint32(v))), nil
+	case packbuf.KindFloat64:
+		return math.Float64frombits(v), nil
+	case packbuf.KindInt8:
+		return int64(int8(v)), nil
+	case packbuf.KindInt16:
+		return int64(int16(v)), nil
+	case packbuf.KindInt32:
+		return int64(int32(v)), nil
+	case packbuf.KindInt64:
+		return int64(v), nil
+	}
+	return v, nil
+}
+
+func (c *schemaCodec) decodeLength(br *packbuf.BitReader, t *packbuf.TypeSchema, bitSize uint) (int, error) {
+	if t.MaxSize != nil {
+		bitSize = uint(t.MaxSize.Bits)
+	}
+	n, err := br.ReadBits(bitSize)
+	return int(n), err
+}
+
+func (c *schemaCodec) encode(bw *packbuf.BitWriter, t *packbuf.TypeSchema, v interface{}) error {
+	switch t.Kind {
+	case packbuf.KindBool:
+		return bw.WriteBool(v.(bool))
+	case packbuf.KindPointer:
+		if err := bw.WriteBool(v != nil); err != nil || v == nil {
+			return err
+		}
+		return c.encode(bw, t.Elem, v)
+	case packbuf.KindStruct:
+		fields := v.(map[string]interface{})
+		for _, field := range c.schema.Struct(t.Struct).Fields {
+			if err := c.encode(bw, field.Type, fields[field.Name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case packbuf.KindSlice, packbuf.KindArray, packbuf.KindMap:
+		values, _ := v.([]interface{})
+		if t.Kind != packbuf.KindArray {
+			n := len(values)
+			if t.Key != nil {
+				n /= 2
+			}
+			if err := c.encodeLength(bw, t, 32, n); err != nil {
+				return err
+			}
+		}
+		for i, v := range values {
+			elem := t.Elem
+			if t.Key != nil && i%2 == 0 {
+				elem = t.Key
+			}
+			if err := c.encode(bw, elem, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case packbuf.KindString:
+		s := v.(string)
+		if err := c.encodeLength(bw, t, 16, len(s)); err != nil {
+			return err
+		}
+		_, err := bw.Write([]byte(s))
+		return err
+	}
+	if q := t.Quantize; q != nil {
+		return bw.WriteBits(uint64(math.Round((v.(float64)-q.Min)/q.Precision)), uint(q.Bits))
+	}
+	if r := t.Range; r != nil {
+		return bw.WriteBits(uint64(v.(int64)-r.Min), uint(r.Bits))
+	}
+	bitSize, err := schemaBitSize(t.Kind)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		if t.Kind == packbuf.KindFloat32 {
+			return bw.WriteBits(uint64(math.Float32bits(float32(v))), bitSize)
+		}
+		return bw.WriteBits(math.Float64bits(v), bitSize)
+	case int64:
+		return bw.WriteBits(uint64(v), bitSize)
+	case uint64:
+		return bw.WriteBits(v, bitSize)
+	}
+	return errors.New("unexpected value for " + t.Kind)
+}
+
+func (c *schemaCodec) encodeLength(bw *packbuf.BitWriter, t *packbuf.TypeSchema, bitSize uint, n int) error {
+	if t.MaxSize != nil {
+		bitSize = uint(t.MaxSize.Bits)
+	}
+	return bw.WriteBits(uint64(n), bitSize)
+}
+
+func schemaBitSize(kind string) (uint, error) {
+	switch kind {
+	case packbuf.KindInt8, packbuf.KindUint8:
+		return 8, nil
+	case packbuf.KindInt16, packbuf.KindUint16:
+		return 16, nil
+	case packbuf.KindInt32, packbuf.KindUint32, packbuf.KindFloat32:
+		return 32, nil
+	case packbuf.KindInt64, packbuf.KindUint64, packbuf.KindFloat64:
+		return 64, nil
+	}
+	return 0, errors.New("unsupported kind in schema: " + kind)
+}
+
+// compareSchemaValue checks a value decoded by schemaCodec matches the value read by packbuf
+func compareSchemaValue(path string, decoded interface{}, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := decoded.(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Name
+			if err := compareSchemaValue(path+"."+name, fields[name], v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		values, _ := decoded.([]interface{})
+		if len(values) != v.Len() {
+			return errors.New(path + ": expected length " + strconv.Itoa(v.Len()) + " but got " + strconv.Itoa(len(values)))
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := compareSchemaValue(path+"["+strconv.Itoa(i)+"]", values[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var expected interface{}
+	switch v.Kind() {
+	case reflect.Bool:
+		expected = v.Bool()
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		expected = v.Uint()
+		if ranged, ok := decoded.(int64); ok {
+			// the schema reads ranged integers as int64
+			decoded = uint64(ranged)
+		}
+	case reflect.Float32:
+		// the schema reads the full precision of quantized values, so compare
+		// as float32 like packbuf does when setting the field
+		decoded = float64(float32(decoded.(float64)))
+		expected = v.Float()
+	default:
+		return errors.New(path + ": unexpected kind " + v.Kind().String())
+	}
+	if decoded != expected {
+		return errors.New(path + ": schema decoded " + strconv.Quote(toString(decoded)) + " but packbuf read " + strconv.Quote(toString(expected)))
+	}
+	return nil
+}
+
+func toString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// TestSchemaRoundTrip tests that the schema, once turned into JSON and back, can be
+// used to read and write the same bytes as packbuf for every registered packet
+func TestSchemaRoundTrip(t *testing.T) {
+	schema, err := NewSchema(1)
+	if err != nil {
+		t.Fatalf("unable to create schema: %v", err)
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("unable to marshal schema: %v", err)
+	}
+	var jsonSchema Schema
+	if err := json.Unmarshal(data, &jsonSchema); err != nil {
+		t.Fatalf("unable to unmarshal schema: %v", err)
+	}
+	if len(jsonSchema.Packets) != len(packetIDToType) {
+		t.Fatalf("expected %d packets in schema but got %d", len(packetIDToType), len(jsonSchema.Packets))
+	}
+	codec := &schemaCodec{schema: &jsonSchema}
+
+	packets := map[PacketID]Packet{
+		PacketAck: &AckPacket{
+			SequenceIDList: []uint16{1, 65535, 3},
+		},
+		PacketClientPlayerUpdate: &ClientPlayerPacket{
+			InputBuffer: []ClientFrameInput{
+				{Frame: 1, PlayerInput: ent.PlayerInput{IsHoldingLeft: true}},
+				{Frame: 2, PlayerInput: ent.PlayerInput{IsHoldingRight: true, IsHoldingJump: true}},
+			},
+		},
+		PacketWorldStateUpdate: newTestServerWorldStatePacket(8),
+		PacketClockSyncRequest: &ClockSyncRequestPacket{},
+		PacketClockSyncResponse: &ClockSyncResponsePacket{
+			RequestSequenceID: 7,
+			ServerTick:        65535,
+			HeldMicroseconds:  1000000,
+		},
+	}
+	for _, packetSchema := range jsonSchema.Packets {
+		packet, ok := packets[packetSchema.ID]
+		if !ok {
+			t.Errorf("missing test packet for %s", packetSchema.Struct)
+			continue
+		}
+		if reflect.TypeOf(packet).Elem().String() != packetSchema.Struct {
+			t.Errorf("expected packet id %d to be %T but got %s", packetSchema.ID, packet, packetSchema.Struct)
+			continue
+		}
+		var rtt rtt.RoundTripTracking
+		datagram, _, err := AppendPacket(nil, &rtt, packet)
+		if err != nil {
+			t.Fatalf("unable to write %T: %v", packet, err)
+		}
+		if PacketID(datagram[0]) != packetSchema.ID {
+			t.Errorf("expected packet id %d but got %d", packetSchema.ID, datagram[0])
+		}
+		typeSchema := &packbuf.TypeSchema{
+			Kind:   packbuf.KindStruct,
+			Struct: packetSchema.Struct,
+		}
+		br := packbuf.NewBitReader(bytes.NewReader(datagram[headerSize:]))
+		decoded, err := codec.decode(br, typeSchema)
+		if err != nil {
+			t.Fatalf("unable to decode %T with schema: %v", packet, err)
+		}
+		var buf bytes.Buffer
+		buf.Write(datagram[:headerSize])
+		bw := packbuf.NewBitWriter(&buf)
+		if err := codec.encode(bw, typeSchema, decoded); err != nil {
+			t.Fatalf("unable to encode %T with schema: %v", packet, err)
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), datagram) {
+			t.Errorf("%T written with schema doesn't match packbuf\nschema:  %v\npackbuf: %v", packet, buf.Bytes(), datagram)
+		}
+
+		_, readPacket, err := Read(bytes.NewReader(datagram))
+		if err != nil {
+			t.Fatalf("unable to read %T: %v", packet, err)
+		}
+		if err := compareSchemaValue(packetSchema.Struct, decoded, reflect.ValueOf(readPacket).Elem()); err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+}
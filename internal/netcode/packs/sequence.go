@@ -0,0 +1,105 @@
+package packs
+
+import (
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+// SequenceStatus is how a received packet's sequence ID compares to the packets
+// received before it from the same connection
+type SequenceStatus uint8
+
+const (
+	// SequenceNewer is a packet that was sent after every other packet received so far
+	SequenceNewer SequenceStatus = iota
+	// SequenceStale is a packet that arrived out of order, a newer packet has already been received
+	SequenceStale
+	// SequenceDuplicate is a packet that has already been received
+	SequenceDuplicate
+)
+
+func (status SequenceStatus) String() string {
+	switch status {
+	case SequenceNewer:
+		return "newer"
+	case SequenceStale:
+		return "stale"
+	case SequenceDuplicate:
+		return "duplicate"
+	}
+	return "unknown"
+}
+
+// receivedSequenceWindow is how many sequence IDs before the highest one we
+// remember receiving, so we can detect duplicates that arrive out of order
+const receivedSequenceWindow = 64
+
+// ReceivedSequences tracks the sequence IDs received from a single connection
+type ReceivedSequences struct {
+	hasReceived bool
+	highest     uint16
+	// received has bit N set if the sequence ID "highest - 1 - N" was received
+	received uint64
+}
+
+// Receive records the sequence ID of a packet that was just read and returns
+// how it compares to the packets received before it.
+//
+// note(jae): 2021-04-18
+// Sequence IDs older than the window can't be checked for duplicates, these
+// are considered stale, which callers should be ignoring anyway.
+func (seqs *ReceivedSequences) Receive(seqID uint16) SequenceStatus {
+	if !seqs.hasReceived {
+		seqs.hasReceived = true
+		seqs.highest = seqID
+		seqs.received = 0
+		return SequenceNewer
+	}
+	if seqID == seqs.highest {
+		return SequenceDuplicate
+	}
+	if rtt.IsWrappedUInt16GreaterThan(seqID, seqs.highest) {
+		// shifting past the width of received clears it, which is what we want
+		// if we've skipped more sequence IDs than we remember
+		shift := seqID - seqs.highest
+		seqs.received = seqs.received<<shift | 1<<(shift-1)
+		seqs.highest = seqID
+		return SequenceNewer
+	}
+	age := seqs.highest - seqID
+	if age > receivedSequenceWindow {
+		return SequenceStale
+	}
+	bit := uint64(1) << (age - 1)
+	if seqs.received&bit != 0 {
+		return SequenceDuplicate
+	}
+	seqs.received |= bit
+	return SequenceStale
+}
+
+// Highest returns the newest sequence ID received, ok is false if nothing
+// has been received yet
+func (seqs *ReceivedSequences) Highest() (seqID uint16, ok bool) {
+	return seqs.highest, seqs.hasReceived
+}
+
+// PacketSequences tracks the sequence IDs received from a single connection for
+// each packet type.
+//
+// Every packet type shares the same sequence IDs, so if we tracked them together an
+// acknowledgement sent after a world state that arrives first would make that world
+// state stale. A packet is only stale if a newer packet of the same type was received.
+type PacketSequences struct {
+	byPacketID []ReceivedSequences
+}
+
+// Receive records the sequence ID of a packet that was just read and returns how it
+// compares to the packets of the same type received before it, see ReceivedSequences.Receive
+func (seqs *PacketSequences) Receive(id PacketID, seqID uint16) SequenceStatus {
+	if int(id) >= len(seqs.byPacketID) {
+		byPacketID := make([]ReceivedSequences, int(id)+1)
+		copy(byPacketID, seqs.byPacketID)
+		seqs.byPacketID = byPacketID
+	}
+	return seqs.byPacketID[id].Receive(seqID)
+}
@@ -0,0 +1,105 @@
+package packs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+)
+
+func TestReceivedSequences(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Received []uint16
+		Expected []SequenceStatus
+	}{
+		{
+			Name:     "in order",
+			Received: []uint16{1, 2, 3},
+			Expected: []SequenceStatus{SequenceNewer, SequenceNewer, SequenceNewer},
+		},
+		{
+			Name:     "first packet is newer",
+			Received: []uint16{40000},
+			Expected: []SequenceStatus{SequenceNewer},
+		},
+		{
+			Name:     "out of order",
+			Received: []uint16{1, 3, 2, 4},
+			Expected: []SequenceStatus{SequenceNewer, SequenceNewer, SequenceStale, SequenceNewer},
+		},
+		{
+			Name:     "duplicate of highest",
+			Received: []uint16{1, 2, 2},
+			Expected: []SequenceStatus{SequenceNewer, SequenceNewer, SequenceDuplicate},
+		},
+		{
+			Name:     "duplicate of out of order packet",
+			Received: []uint16{1, 5, 3, 3, 1},
+			Expected: []SequenceStatus{SequenceNewer, SequenceNewer, SequenceStale, SequenceDuplicate, SequenceDuplicate},
+		},
+		{
+			Name:     "wrap around",
+			Received: []uint16{65534, 65535, 0, 65535, 1},
+			Expected: []SequenceStatus{SequenceNewer, SequenceNewer, SequenceNewer, SequenceDuplicate, SequenceNewer},
+		},
+		{
+			Name:     "skipped more than the window",
+			Received: []uint16{1, 100, 1, 36},
+			Expected: []SequenceStatus{SequenceNewer, SequenceNewer, SequenceStale, SequenceStale},
+		},
+		{
+			Name:     "oldest packet in window",
+			Received: []uint16{1, 65, 1},
+			Expected: []SequenceStatus{SequenceNewer, SequenceNewer, SequenceDuplicate},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var seqs ReceivedSequences
+			if _, ok := seqs.Highest(); ok {
+				t.Fatalf("expected nothing received")
+			}
+			for i, seqID := range testCase.Received {
+				if status := seqs.Receive(seqID); status != testCase.Expected[i] {
+					t.Errorf("receiving %d (index %d): expected %s but got %s", seqID, i, testCase.Expected[i], status)
+				}
+			}
+			if _, ok := seqs.Highest(); !ok {
+				t.Errorf("expected something received")
+			}
+		})
+	}
+}
+
+// TestPacketSequencesReorderedAck tests that an acknowledgement sent after a world state
+// but received before it doesn't make the world state stale
+func TestPacketSequencesReorderedAck(t *testing.T) {
+	var tracker rtt.RoundTripTracking
+	worldStateDatagram, _, err := AppendPacket(nil, &tracker, newTestServerWorldStatePacket(2))
+	if err != nil {
+		t.Fatalf("Failed AppendPacket: %v", err)
+	}
+	ackDatagram, _, err := AppendPacket(nil, &tracker, &AckPacket{SequenceIDList: []uint16{1}})
+	if err != nil {
+		t.Fatalf("Failed AppendPacket: %v", err)
+	}
+
+	var seqs PacketSequences
+	for _, test := range []struct {
+		datagram []byte
+		expected SequenceStatus
+	}{
+		{ackDatagram, SequenceNewer},
+		{worldStateDatagram, SequenceNewer},
+		{worldStateDatagram, SequenceDuplicate},
+	} {
+		seqID, packet, err := ServerToClient.Read(bytes.NewReader(test.datagram))
+		if err != nil {
+			t.Fatalf("Failed Read: %v", err)
+		}
+		if status := seqs.Receive(packet.ID(), seqID); status != test.expected {
+			t.Errorf("receiving %T with sequence ID %d: expected %s but got %s", packet, seqID, test.expected, status)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package packs
+
+import (
+	"strconv"
+)
+
+// Validator is implemented by packets that have rules about the data they can hold
+// beyond what their field types and struct tags already limit, ie. a frame number
+// that should never be 0
+type Validator interface {
+	Validate() error
+}
+
+// ValidationError is returned by Validate if a packet broke one of its rules
+type ValidationError struct {
+	ID  PacketID
+	Err error
+}
+
+func (err *ValidationError) Error() string {
+	return "invalid packet id " + strconv.Itoa(int(err.ID)) + ": " + err.Err.Error()
+}
+
+func (err *ValidationError) Unwrap() error {
+	return err.Err
+}
+
+// Validate checks the packet follows its rules if it implements Validator
+func Validate(packet Packet) error {
+	validator, ok := packet.(Validator)
+	if !ok {
+		return nil
+	}
+	if err := validator.Validate(); err != nil {
+		return &ValidationError{
+			ID:  packet.ID(),
+			Err: err,
+		}
+	}
+	return nil
+}
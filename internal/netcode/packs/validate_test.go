@@ -0,0 +1,90 @@
+package packs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Packet  Packet
+		IsValid bool
+	}{
+		{
+			Name:    "ack has no rules",
+			Packet:  &AckPacket{},
+			IsValid: true,
+		},
+		{
+			Name: "inputs with frames",
+			Packet: &ClientPlayerPacket{
+				InputBuffer: []ClientFrameInput{{Frame: 1}, {Frame: 2}},
+			},
+			IsValid: true,
+		},
+		{
+			Name: "input with frame of 0",
+			Packet: &ClientPlayerPacket{
+				InputBuffer: []ClientFrameInput{{Frame: 1}, {Frame: 0}},
+			},
+		},
+		{
+			Name: "too many inputs",
+			Packet: &ClientPlayerPacket{
+				InputBuffer: make([]ClientFrameInput, netconst.MaxServerInputBuffer+1),
+			},
+		},
+		{
+			Name: "world state",
+			Packet: &ServerWorldStatePacket{
+				MyNetID: 1,
+				PlayerDeltas: []PlayerStateDelta{
+					{NetID: 1, Flags: playerDeltaX | playerDeltaVspeed | playerDeltaDirLeft, Positions: []float32{1}, Speeds: []float32{-1}},
+				},
+			},
+			IsValid: true,
+		},
+		{
+			Name:   "world state with net id of 0",
+			Packet: &ServerWorldStatePacket{},
+		},
+		{
+			Name: "world state delta missing values",
+			Packet: &ServerWorldStatePacket{
+				MyNetID: 1,
+				PlayerDeltas: []PlayerStateDelta{
+					{NetID: 1, Flags: playerDeltaX | playerDeltaY, Positions: []float32{1}},
+				},
+			},
+		},
+		{
+			Name: "world state delta with speed sent as position",
+			Packet: &ServerWorldStatePacket{
+				MyNetID: 1,
+				PlayerDeltas: []PlayerStateDelta{
+					{NetID: 1, Flags: playerDeltaHspeed, Positions: []float32{1}},
+				},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		err := Validate(testCase.Packet)
+		if testCase.IsValid {
+			if err != nil {
+				t.Errorf("%s: expected no error but got: %v", testCase.Name, err)
+			}
+			continue
+		}
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected ValidationError but got: %v", testCase.Name, err)
+			continue
+		}
+		if validationErr.ID != testCase.Packet.ID() {
+			t.Errorf("%s: expected ValidationError to have packet id %d but got %d", testCase.Name, testCase.Packet.ID(), validationErr.ID)
+		}
+	}
+}
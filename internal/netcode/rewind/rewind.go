@@ -0,0 +1,207 @@
+// rewind holds onto the state of the world for the last few ticks so that the server can simulate
+// an input on the tick the client pressed it, rather than the tick it arrived on.
+//
+// Imagine you're playing a precise platformer and you press jump to just land on a platform by 1 frame.
+// If the server processes your input even 1 frame later than you pressed it, you'll miss the platform.
+// By rewinding the world to what it was from the players perspective and simulating forward
+// again, they will definitely make the jump on the server-side.
+package rewind
+
+import (
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+)
+
+// LateInput is an input that should have been simulated on an earlier tick
+type LateInput struct {
+	NetID uint16
+	// Tick is the tick the input should have started being simulated on, the input
+	// replaces the inputs recorded for the player from this tick onwards
+	Tick  uint16
+	Input ent.PlayerInput
+}
+
+type frame struct {
+	tick uint16
+	// players is the state of every player at the start of the tick, including the
+	// inputs they were simulated with
+	players []ent.Player
+}
+
+// History is a ring buffer of the state of every player at the start of each tick
+type History struct {
+	frames []frame
+	// newest is the index of the newest frame in frames
+	newest int
+	count  int
+	// restored is every player we've rewound, re-used every rewind
+	restored []restoredPlayer
+}
+
+type restoredPlayer struct {
+	netID uint16
+	// inputs are what the player had before rewinding
+	inputs ent.PlayerInput
+}
+
+// Init sets how many ticks we can rewind, this clears the history
+func (h *History) Init(maxRewind int) {
+	if maxRewind < 0 {
+		maxRewind = 0
+	}
+	*h = History{
+		frames: make([]frame, maxRewind+1),
+	}
+}
+
+// MaxRewind is how many ticks we can rewind
+func (h *History) MaxRewind() int {
+	if len(h.frames) == 0 {
+		return 0
+	}
+	return len(h.frames) - 1
+}
+
+// Record stores the state of every player at the start of the tick, this should be
+// called once per tick after inputs are set and before the world is updated
+func (h *History) Record(tick uint16, players []*ent.Player) {
+	if len(h.frames) == 0 {
+		return
+	}
+	if h.count > 0 && tick != h.at(0).tick+1 {
+		// we missed a tick, so the old frames can't be simulated forward to this one
+		h.count = 0
+	}
+	h.newest = (h.newest + 1) % len(h.frames)
+	if h.count < len(h.frames) {
+		h.count++
+	}
+	f := &h.frames[h.newest]
+	f.tick = tick
+	f.players = f.players[:0]
+	for _, entity := range players {
+		f.players = append(f.players, *entity)
+	}
+}
+
+// at returns the frame that was recorded age ticks before the newest one
+func (h *History) at(age int) *frame {
+	i := h.newest - age
+	if i < 0 {
+		i += len(h.frames)
+	}
+	return &h.frames[i]
+}
+
+// age returns how many ticks before the newest frame the tick is, clamped to the frames we have.
+// ok is false if the tick is after the newest frame.
+func (h *History) age(tick uint16) (age int, ok bool) {
+	age = int(int16(h.at(0).tick - tick))
+	if age < 0 {
+		return 0, false
+	}
+	if age >= h.count {
+		age = h.count - 1
+	}
+	return age, true
+}
+
+// Player returns the state of the player at the start of the tick, ok is false if the tick
+// isn't in the history or the player wasn't in the world on that tick
+func (h *History) Player(tick uint16, netID uint16) (state ent.Player, ok bool) {
+	if h.count == 0 {
+		return ent.Player{}, false
+	}
+	age := int(int16(h.at(0).tick - tick))
+	if age < 0 || age >= h.count {
+		return ent.Player{}, false
+	}
+	for _, state := range h.at(age).players {
+		if state.NetID == netID {
+			return state, true
+		}
+	}
+	return ent.Player{}, false
+}
+
+// Rewind sets every player back to their state at the start of the earliest tick of the late inputs,
+// then simulates them forward again up to the tick after the newest frame, using the late
+// inputs in place of the ones recorded. The history is updated with the corrected states.
+//
+// Late inputs older than the history are simulated on the oldest tick we have.
+// Players that aren't in the history for a tick aren't simulated on that tick.
+//
+// Players keep the inputs they had before rewinding, as those are for the tick after the newest frame.
+//
+// This returns how many ticks were simulated again, 0 if there was nothing to rewind.
+func (h *History) Rewind(players []*ent.Player, lateInputs []LateInput) int {
+	if h.count == 0 {
+		return 0
+	}
+	oldest := -1
+	for _, lateInput := range lateInputs {
+		age, ok := h.age(lateInput.Tick)
+		if !ok {
+			continue
+		}
+		if age > oldest {
+			oldest = age
+		}
+	}
+	if oldest < 0 {
+		return 0
+	}
+	h.restored = h.restored[:0]
+	for age := oldest; age >= 0; age-- {
+		f := h.at(age)
+		for i := range f.players {
+			state := &f.players[i]
+			entity := findPlayer(players, state.NetID)
+			if entity == nil {
+				// player has left since
+				continue
+			}
+			if !h.hasRestored(state.NetID) {
+				h.restored = append(h.restored, restoredPlayer{
+					netID:  state.NetID,
+					inputs: entity.Inputs,
+				})
+				*entity = *state
+			}
+			entity.Inputs = state.Inputs
+			for _, lateInput := range lateInputs {
+				if lateInput.NetID != state.NetID {
+					continue
+				}
+				if lateAge, ok := h.age(lateInput.Tick); ok && lateAge >= age {
+					entity.Inputs = lateInput.Input
+				}
+			}
+			*state = *entity
+			entity.Update()
+		}
+	}
+	for _, restored := range h.restored {
+		if entity := findPlayer(players, restored.netID); entity != nil {
+			entity.Inputs = restored.inputs
+		}
+	}
+	return oldest + 1
+}
+
+func (h *History) hasRestored(netID uint16) bool {
+	for _, restored := range h.restored {
+		if restored.netID == netID {
+			return true
+		}
+	}
+	return false
+}
+
+func findPlayer(players []*ent.Player, netID uint16) *ent.Player {
+	for _, entity := range players {
+		if entity.NetID == netID {
+			return entity
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package rewind
+
+import (
+	"testing"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+)
+
+func newPlayer(netID uint16) *ent.Player {
+	entity := &ent.Player{NetID: netID, X: 180, Y: 180}
+	entity.Init()
+	return entity
+}
+
+// simulate records and updates players for the given number of ticks, starting at tick
+func simulate(h *History, players []*ent.Player, tick uint16, count int) uint16 {
+	for i := 0; i < count; i++ {
+		h.Record(tick, players)
+		for _, entity := range players {
+			entity.Update()
+		}
+		tick++
+	}
+	return tick
+}
+
+func TestRewindLateInput(t *testing.T) {
+	for _, startTick := range []uint16{0, 65530} {
+		var h History
+		h.Init(10)
+		players := []*ent.Player{newPlayer(1), newPlayer(2)}
+		players[1].Inputs.IsHoldingLeft = true
+		tick := simulate(&h, players, startTick, 20)
+		other := *players[1]
+
+		// player 1 pressed right 4 ticks ago
+		right := ent.PlayerInput{IsHoldingRight: true}
+		players[0].Inputs = right
+		if count := h.Rewind(players, []LateInput{{NetID: 1, Tick: tick - 4, Input: right}}); count != 4 {
+			t.Fatalf("start tick %d: expected to simulate 4 ticks again but simulated %d", startTick, count)
+		}
+
+		// the same as if the input arrived on time
+		expected := newPlayer(1)
+		for i := 0; i < 20; i++ {
+			if i >= 16 {
+				expected.Inputs = right
+			}
+			expected.Update()
+		}
+		if *players[0] != *expected {
+			t.Errorf("start tick %d: expected rewound player to be %+v but got %+v", startTick, *expected, *players[0])
+		}
+		if *players[1] != other {
+			t.Errorf("start tick %d: expected other player to be unchanged, %+v but got %+v", startTick, other, *players[1])
+		}
+
+		// the history has the corrected state, so rewinding again with the
+		// same input changes nothing
+		corrected := *players[0]
+		h.Rewind(players, []LateInput{{NetID: 1, Tick: tick - 4, Input: right}})
+		if *players[0] != corrected {
+			t.Errorf("start tick %d: expected rewinding twice to be the same, %+v but got %+v", startTick, corrected, *players[0])
+		}
+	}
+}
+
+func TestRewindClampsToHistory(t *testing.T) {
+	var h History
+	h.Init(5)
+	players := []*ent.Player{newPlayer(1)}
+	tick := simulate(&h, players, 1, 20)
+	right := ent.PlayerInput{IsHoldingRight: true}
+	if count := h.Rewind(players, []LateInput{{NetID: 1, Tick: tick - 15, Input: right}}); count != 6 {
+		t.Errorf("expected to only rewind the %d ticks of history but rewound %d", 6, count)
+	}
+	// inputs for now or later don't need rewinding
+	if count := h.Rewind(players, []LateInput{{NetID: 1, Tick: tick, Input: right}}); count != 0 {
+		t.Errorf("expected no rewind but rewound %d", count)
+	}
+}
+
+func TestRewindJoinedAndLeftPlayers(t *testing.T) {
+	var h History
+	h.Init(10)
+	players := []*ent.Player{newPlayer(1)}
+	tick := simulate(&h, players, 1, 5)
+	// player 2 joins, then player 1 leaves
+	players = append(players, newPlayer(2))
+	tick = simulate(&h, players, tick, 2)
+	players = players[1:]
+	tick = simulate(&h, players, tick, 2)
+
+	// the input is from before player 2 joined, so it's simulated from when they joined
+	expected := newPlayer(2)
+	expected.Inputs.IsHoldingLeft = true
+	for i := 0; i < 4; i++ {
+		expected.Update()
+	}
+	players[0].Inputs = expected.Inputs
+	h.Rewind(players, []LateInput{{NetID: 2, Tick: tick - 6, Input: expected.Inputs}})
+	if *players[0] != *expected {
+		t.Errorf("expected player to only be simulated since they joined, %+v but got %+v", *expected, *players[0])
+	}
+}
+
+func TestRecordMissedTick(t *testing.T) {
+	var h History
+	h.Init(10)
+	players := []*ent.Player{newPlayer(1)}
+	tick := simulate(&h, players, 1, 5)
+	simulate(&h, players, tick+1, 1)
+	if h.count != 1 {
+		t.Errorf("expected history to be cleared after missing a tick, but had %d ticks", h.count)
+	}
+}
+
+func TestPlayer(t *testing.T) {
+	var h History
+	h.Init(5)
+	players := []*ent.Player{newPlayer(1)}
+	players[0].Inputs.IsHoldingRight = true
+	tick := uint16(65533)
+	var expected []ent.Player
+	for i := 0; i < 10; i++ {
+		expected = append(expected, *players[0])
+		tick = simulate(&h, players, tick, 1)
+	}
+	// the newest 6 ticks are kept
+	for i := 4; i < 10; i++ {
+		state, ok := h.Player(tick-uint16(10-i), 1)
+		if !ok || state != expected[i] {
+			t.Errorf("tick %d: expected %+v but got %+v (ok: %v)", tick-uint16(10-i), expected[i], state, ok)
+		}
+	}
+	if _, ok := h.Player(tick-7, 1); ok {
+		t.Errorf("expected tick older than the history to not be found")
+	}
+	if _, ok := h.Player(tick, 1); ok {
+		t.Errorf("expected tick that hasn't been recorded to not be found")
+	}
+	if _, ok := h.Player(tick-1, 2); ok {
+		t.Errorf("expected missing player to not be found")
+	}
+}
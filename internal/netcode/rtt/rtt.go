@@ -1,7 +1,10 @@
 package rtt
 
 import (
+	"fmt"
 	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
 )
 
 // todo(jae): 2021-04-02
@@ -26,19 +29,133 @@ const (
 
 	maxFramerate = 60
 
-	// roundTripTimeLimitInFrames is used to setup the fixed-size array for storing
-	roundTripTimeLimitInFrames = (maximumRoundTripTimeLimit / 1000) * maxFramerate
+	// DefaultSendRate is the send rate used if Options doesn't set one, the client and
+	// server send up to 2 packets every frame, acknowledgements and then input or world state.
+	DefaultSendRate = 2 * maxFramerate
+
+	// capacityHeadroom is how many times more packets we remember than the send rate says we
+	// need for a second, as the send rate is only an estimate, ie. the client also sends clock
+	// sync requests. Without it, slots would be re-used before their packet could be counted as lost.
+	capacityHeadroom = 2
+
+	// maxCapacity is the most sent packets we can remember, any more and sequence IDs
+	// would wrap around before they expire
+	maxCapacity = 32768
+
+	// rttSampleWindow is how many of the latest round trip times we keep to
+	// work out the min/max round trip time
+	rttSampleWindow = 64
+
+	// smoothingFactor is how much a new sample moves the smoothed latency, jitter and packet loss
+	smoothingFactor = 0.10
 )
 
+// Options configures how many sent packets a RoundTripTracking remembers
+type Options struct {
+	// SendRate is how many packets are sent per second, this determines how many packets
+	// can be waiting on an acknowledgement before they're too old to be useful. We remember
+	// twice this many to leave room for sending faster than expected.
+	//
+	// If 0, this is DefaultSendRate.
+	SendRate int
+	// Capacity is how many sent packets we remember, if 0 this is worked out from the SendRate.
+	//
+	// This is rounded up to a power of 2 so that indexing by sequence ID stays consistent
+	// when the uint16 wraps.
+	Capacity int
+	// Clock is used to time round trips and bandwidth, if nil this is the system clock
+	Clock clock.Clock
+}
+
 type RoundTripTracking struct {
-	packetSequenceID   uint16
-	packetSequenceList [roundTripTimeLimitInFrames]packetSequence
+	packetSequenceID uint16
+	// packetSequenceList is a ring buffer of sent packets indexed by "sequence ID & packetSequenceMask"
+	packetSequenceList []packetSequence
+	packetSequenceMask uint16
+	clock              clock.Clock
 	latency            time.Duration
+	jitter             time.Duration
+	packetLoss         float64
+
+	// rttSamples are the latest round trip times, rttSampleIndex is the next one to overwrite
+	rttSamples     [rttSampleWindow]time.Duration
+	rttSampleCount int
+	rttSampleIndex int
+
+	sent     bandwidth
+	received bandwidth
 }
 
 type packetSequence struct {
 	SequenceID uint16
 	Time       time.Time
+	// IsUsed is false if this slot hasn't stored a sequence yet
+	IsUsed bool
+	// IsAcked is true once the sequence has been acknowledged
+	IsAcked bool
+}
+
+// Init resets tracking and sizes how many sent packets we remember, the zero value
+// of RoundTripTracking is ready to use with the default Options.
+func (rtt *RoundTripTracking) Init(options Options) {
+	capacity := options.Capacity
+	if capacity <= 0 {
+		sendRate := options.SendRate
+		if sendRate <= 0 {
+			sendRate = DefaultSendRate
+		}
+		capacity = (capacityHeadroom * sendRate * maximumRoundTripTimeLimit) / 1000
+	}
+	if capacity > maxCapacity {
+		capacity = maxCapacity
+	}
+	size := 1
+	for size < capacity {
+		size *= 2
+	}
+	*rtt = RoundTripTracking{
+		packetSequenceList: make([]packetSequence, size),
+		packetSequenceMask: uint16(size - 1),
+		clock:              options.Clock,
+	}
+}
+
+// Capacity returns how many sent packets we remember
+func (rtt *RoundTripTracking) Capacity() int {
+	if rtt.packetSequenceList == nil {
+		rtt.Init(Options{})
+	}
+	return len(rtt.packetSequenceList)
+}
+
+func (rtt *RoundTripTracking) now() time.Time {
+	if rtt.clock == nil {
+		return time.Now()
+	}
+	return rtt.clock.Now()
+}
+
+// Stats are the network statistics of a connection
+type Stats struct {
+	// Latency is the smoothed average round trip time
+	Latency time.Duration
+	// Jitter is the smoothed average of how much each round trip time differs from Latency
+	Jitter time.Duration
+	// MinLatency and MaxLatency are the lowest and highest round trip times
+	// of the latest acknowledged packets
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// PacketLoss is the smoothed percentage (0-100) of sent packets that were never acknowledged
+	PacketLoss float64
+	// SentBytesPerSecond and ReceivedBytesPerSecond are the datagram bytes sent and
+	// received over the last second
+	SentBytesPerSecond     int
+	ReceivedBytesPerSecond int
+}
+
+func (stats Stats) String() string {
+	return fmt.Sprintf("rtt: %v (min: %v, max: %v, jitter: %v), loss: %.1f%%, sent: %d B/s, received: %d B/s",
+		stats.Latency, stats.MinLatency, stats.MaxLatency, stats.Jitter, stats.PacketLoss, stats.SentBytesPerSecond, stats.ReceivedBytesPerSecond)
 }
 
 // Latency will return the smoothed average latency based on acknowledged
@@ -47,42 +164,168 @@ func (rtt *RoundTripTracking) Latency() time.Duration {
 	return rtt.latency
 }
 
+// Stats returns the latency, packet loss and bandwidth statistics
+func (rtt *RoundTripTracking) Stats() Stats {
+	return rtt.stats(rtt.now())
+}
+
+func (rtt *RoundTripTracking) stats(now time.Time) Stats {
+	stats := Stats{
+		Latency:                rtt.latency,
+		Jitter:                 rtt.jitter,
+		PacketLoss:             rtt.packetLoss,
+		SentBytesPerSecond:     rtt.sent.perSecond(now),
+		ReceivedBytesPerSecond: rtt.received.perSecond(now),
+	}
+	for i, sample := range rtt.rttSamples[:rtt.rttSampleCount] {
+		if i == 0 || sample < stats.MinLatency {
+			stats.MinLatency = sample
+		}
+		if sample > stats.MaxLatency {
+			stats.MaxLatency = sample
+		}
+	}
+	return stats
+}
+
+// Sent records the size of a datagram sent to the connection
+func (rtt *RoundTripTracking) Sent(size int) {
+	rtt.sent.add(rtt.now(), size)
+}
+
+// Received records the size of a datagram received from the connection
+func (rtt *RoundTripTracking) Received(size int) {
+	rtt.received.add(rtt.now(), size)
+}
+
 func (rtt *RoundTripTracking) Next() uint16 {
+	return rtt.next(rtt.now())
+}
+
+// NextUntracked returns the next sequence ID for a packet that's never acknowledged, ie. an acknowledgement,
+// so it isn't counted as lost or used to measure the round trip time
+func (rtt *RoundTripTracking) NextUntracked() uint16 {
+	seqID := rtt.next(rtt.now())
+	rtt.packetSequenceList[seqID&rtt.packetSequenceMask].IsUsed = false
+	return seqID
+}
+
+func (rtt *RoundTripTracking) next(now time.Time) uint16 {
+	if rtt.packetSequenceList == nil {
+		rtt.Init(Options{})
+	}
 	seqID := rtt.packetSequenceID
 	rtt.packetSequenceID++
 
-	// Store current time in free slot (anything older than 1000ms)
-	now := time.Now()
-	for i, _ := range rtt.packetSequenceList {
-		sequence := &rtt.packetSequenceList[i]
-		if now.Sub(sequence.Time).Milliseconds() > maximumRoundTripTimeLimit {
-			sequence.SequenceID = seqID
-			sequence.Time = now
-			break
-		}
+	sequence := &rtt.packetSequenceList[seqID&rtt.packetSequenceMask]
+	if sequence.IsUsed &&
+		!sequence.IsAcked &&
+		now.Sub(sequence.Time).Milliseconds() > maximumRoundTripTimeLimit {
+		// expired without being acknowledged, so consider it lost.
+		//
+		// note(jae): 2021-04-18
+		// if it hasn't expired, we're sending faster than Options.SendRate and we can no longer
+		// find out if it arrives, so it doesn't count towards packet loss.
+		rtt.packetLoss += smoothingFactor * (100 - rtt.packetLoss)
 	}
+	sequence.SequenceID = seqID
+	sequence.Time = now
+	sequence.IsUsed = true
+	sequence.IsAcked = false
 	return seqID
 }
 
+// SentTime returns when the packet with the sequence ID was sent, ok is false if we no
+// longer remember it, ie. it was sent more than a second ago
+func (rtt *RoundTripTracking) SentTime(seqID uint16) (sentTime time.Time, ok bool) {
+	if rtt.packetSequenceList == nil {
+		return time.Time{}, false
+	}
+	sequence := &rtt.packetSequenceList[seqID&rtt.packetSequenceMask]
+	if !sequence.IsUsed ||
+		sequence.SequenceID != seqID ||
+		rtt.now().Sub(sequence.Time).Milliseconds() > maximumRoundTripTimeLimit {
+		return time.Time{}, false
+	}
+	return sequence.Time, true
+}
+
 func (rtt *RoundTripTracking) Ack(seqID uint16) {
-	var foundSequence *packetSequence
-	for i := range rtt.packetSequenceList {
-		sequence := &rtt.packetSequenceList[i]
-		if sequence.SequenceID == seqID {
-			foundSequence = sequence
-			break
-		}
+	rtt.ack(seqID, rtt.now())
+}
+
+func (rtt *RoundTripTracking) ack(seqID uint16, now time.Time) {
+	if rtt.packetSequenceList == nil {
+		// nothing has been sent yet
+		return
 	}
-	if foundSequence == nil {
-		// If the sequence expired or is too old, we ignore it
+	sequence := &rtt.packetSequenceList[seqID&rtt.packetSequenceMask]
+	if !sequence.IsUsed ||
+		sequence.SequenceID != seqID ||
+		sequence.IsAcked ||
+		now.Sub(sequence.Time).Milliseconds() > maximumRoundTripTimeLimit {
+		// If the sequence was overwritten, expired or was already acknowledged, we ignore it
 		return
 	}
-	timePacketSent := time.Since(foundSequence.Time)
+	sequence.IsAcked = true
+	rtt.packetLoss -= smoothingFactor * rtt.packetLoss
+
+	timePacketSent := now.Sub(sequence.Time)
+	rtt.rttSamples[rtt.rttSampleIndex] = timePacketSent
+	rtt.rttSampleIndex = (rtt.rttSampleIndex + 1) % len(rtt.rttSamples)
+	if rtt.rttSampleCount < len(rtt.rttSamples) {
+		rtt.rttSampleCount++
+	}
 	if rtt.latency == 0 {
 		rtt.latency = timePacketSent
+		rtt.jitter = timePacketSent / 2
+	} else {
+		diff := timePacketSent - rtt.latency
+		if diff < 0 {
+			diff = -diff
+		}
+		rtt.jitter = time.Duration(float64(rtt.jitter) + (smoothingFactor * float64(diff-rtt.jitter)))
+		rtt.latency = time.Duration(float64(rtt.latency) + (smoothingFactor * float64(timePacketSent-rtt.latency)))
+	}
+}
+
+// bandwidth counts bytes in one second intervals
+type bandwidth struct {
+	// start is when the current second started
+	start time.Time
+	// bytes is how many bytes have been counted in the current second
+	bytes int
+	// lastSecondBytes is how many bytes were counted in the previous second
+	lastSecondBytes int
+}
+
+func (b *bandwidth) add(now time.Time, size int) {
+	b.update(now)
+	b.bytes += size
+}
+
+func (b *bandwidth) perSecond(now time.Time) int {
+	b.update(now)
+	return b.lastSecondBytes
+}
+
+func (b *bandwidth) update(now time.Time) {
+	if b.start.IsZero() {
+		b.start = now
+		return
+	}
+	elapsed := now.Sub(b.start)
+	if elapsed < time.Second {
+		return
+	}
+	if elapsed < 2*time.Second {
+		b.lastSecondBytes = b.bytes
 	} else {
-		rtt.latency = time.Duration(float64(rtt.latency) + (0.10 * float64(timePacketSent-rtt.latency)))
+		// nothing was counted in the second before this one
+		b.lastSecondBytes = 0
 	}
+	b.bytes = 0
+	b.start = b.start.Add(elapsed.Truncate(time.Second))
 }
 
 // IsWrappedUInt16GreaterThan checks to see if a is greater than b but accounts
@@ -1,6 +1,11 @@
 package rtt
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
+)
 
 type testCase struct {
 	A      uint16
@@ -45,3 +50,254 @@ func TestIsWrappedUInt16GreaterThan(t *testing.T) {
 		}
 	}
 }
+
+func TestRoundTripTrackingStats(t *testing.T) {
+	var rtt RoundTripTracking
+	start := time.Unix(1000, 0)
+
+	// ack 3 packets with a round trip time of 50ms, 100ms and 150ms
+	for i, rttMs := range []int{50, 100, 150} {
+		sent := start.Add(time.Duration(i) * time.Second / 60)
+		seqID := rtt.next(sent)
+		rtt.ack(seqID, sent.Add(time.Duration(rttMs)*time.Millisecond))
+	}
+	// acknowledging twice should be ignored
+	rtt.ack(0, start.Add(500*time.Millisecond))
+
+	stats := rtt.stats(start.Add(time.Second))
+	if stats.MinLatency != 50*time.Millisecond {
+		t.Errorf("expected min latency of 50ms but got %v", stats.MinLatency)
+	}
+	if stats.MaxLatency != 150*time.Millisecond {
+		t.Errorf("expected max latency of 150ms but got %v", stats.MaxLatency)
+	}
+	if stats.Latency <= 50*time.Millisecond || stats.Latency >= 150*time.Millisecond {
+		t.Errorf("expected latency between 50ms and 150ms but got %v", stats.Latency)
+	}
+	if stats.Jitter == 0 {
+		t.Errorf("expected jitter")
+	}
+	if stats.PacketLoss != 0 {
+		t.Errorf("expected no packet loss but got %v", stats.PacketLoss)
+	}
+
+	// send a packet that's never acknowledged, then wait for it to expire
+	// so its slot is re-used
+	lostAt := start.Add(2 * time.Second)
+	for i := 0; i < len(rtt.packetSequenceList); i++ {
+		rtt.next(lostAt)
+	}
+	rtt.next(lostAt.Add(2 * time.Second))
+	if stats := rtt.stats(lostAt); stats.PacketLoss <= 0 {
+		t.Errorf("expected packet loss but got %v", stats.PacketLoss)
+	}
+}
+
+func TestRoundTripTrackingClock(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	var rtt RoundTripTracking
+	rtt.Init(Options{Clock: clk})
+
+	seqID := rtt.Next()
+	rtt.Sent(100)
+	clk.Advance(80 * time.Millisecond)
+	rtt.Ack(seqID)
+	if latency := rtt.Latency(); latency != 80*time.Millisecond {
+		t.Errorf("expected latency of 80ms but got %v", latency)
+	}
+
+	// acknowledgements that arrive after a packet expired are ignored
+	seqID = rtt.Next()
+	clk.Advance(maximumRoundTripTimeLimit*time.Millisecond + time.Millisecond)
+	rtt.Ack(seqID)
+	if stats := rtt.Stats(); stats.MaxLatency != 80*time.Millisecond {
+		t.Errorf("expected expired acknowledgement to be ignored, got max latency of %v", stats.MaxLatency)
+	}
+	if stats := rtt.Stats(); stats.SentBytesPerSecond != 100 {
+		t.Errorf("expected 100 bytes sent in the last second but got %d", stats.SentBytesPerSecond)
+	}
+}
+
+func TestRoundTripTrackingSentTime(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clock.NewManual(start)
+	var rtt RoundTripTracking
+	rtt.Init(Options{Clock: clk})
+	if _, ok := rtt.SentTime(0); ok {
+		t.Errorf("expected nothing sent")
+	}
+	seqID := rtt.Next()
+	clk.Advance(50 * time.Millisecond)
+	if sentTime, ok := rtt.SentTime(seqID); !ok || !sentTime.Equal(start) {
+		t.Errorf("expected packet sent at %v but got %v (ok: %v)", start, sentTime, ok)
+	}
+	if _, ok := rtt.SentTime(seqID + 1); ok {
+		t.Errorf("expected unsent packet to not be found")
+	}
+	clk.Advance(maximumRoundTripTimeLimit * time.Millisecond)
+	if _, ok := rtt.SentTime(seqID); ok {
+		t.Errorf("expected packet older than a second to be forgotten")
+	}
+}
+
+func TestRoundTripTrackingUntracked(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	var rtt RoundTripTracking
+	rtt.Init(Options{Clock: clk})
+	for i := 0; i < 4*rtt.Capacity(); i++ {
+		rtt.NextUntracked()
+		rtt.Ack(rtt.Next())
+		clk.Advance(time.Second / maxFramerate)
+	}
+	if stats := rtt.Stats(); stats.PacketLoss != 0 {
+		t.Errorf("expected untracked packets to not count as lost but got %v%% packet loss", stats.PacketLoss)
+	}
+	seqID := rtt.NextUntracked()
+	if _, ok := rtt.SentTime(seqID); ok {
+		t.Errorf("expected untracked packet to not be remembered")
+	}
+}
+
+// TestRoundTripTrackingLossAboveSendRate tests that packets are still counted as lost when sending
+// a bit faster than DefaultSendRate, ie. the client sends clock sync requests as well as its
+// acknowledgements and input every frame
+func TestRoundTripTrackingLossAboveSendRate(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	var rtt RoundTripTracking
+	rtt.Init(Options{Clock: clk})
+	for i := 0; i < 4*maxFramerate; i++ {
+		rtt.NextUntracked()
+		// nothing is acknowledged
+		rtt.Next()
+		if i%4 == 0 {
+			rtt.Next()
+		}
+		clk.Advance(time.Second / maxFramerate)
+	}
+	if stats := rtt.Stats(); stats.PacketLoss == 0 {
+		t.Errorf("expected unacknowledged packets to be counted as lost before their slot is re-used")
+	}
+}
+
+func TestRoundTripTrackingBandwidth(t *testing.T) {
+	var b bandwidth
+	start := time.Unix(1000, 0)
+	b.add(start, 100)
+	b.add(start.Add(500*time.Millisecond), 200)
+	if n := b.perSecond(start.Add(900 * time.Millisecond)); n != 0 {
+		t.Errorf("expected 0 bytes per second before the first second ends but got %d", n)
+	}
+	b.add(start.Add(1100*time.Millisecond), 50)
+	if n := b.perSecond(start.Add(1200 * time.Millisecond)); n != 300 {
+		t.Errorf("expected 300 bytes per second but got %d", n)
+	}
+	if n := b.perSecond(start.Add(2100 * time.Millisecond)); n != 50 {
+		t.Errorf("expected 50 bytes per second but got %d", n)
+	}
+	if n := b.perSecond(start.Add(5 * time.Second)); n != 0 {
+		t.Errorf("expected 0 bytes per second after nothing was sent but got %d", n)
+	}
+}
+
+func TestRoundTripTrackingCapacity(t *testing.T) {
+	testCases := []struct {
+		Options  Options
+		Capacity int
+	}{
+		{Options: Options{}, Capacity: 256},
+		{Options: Options{SendRate: 60}, Capacity: 128},
+		{Options: Options{SendRate: 240}, Capacity: 512},
+		{Options: Options{SendRate: 60, Capacity: 1000}, Capacity: 1024},
+		{Options: Options{Capacity: 1 << 20}, Capacity: 32768},
+	}
+	for _, testCase := range testCases {
+		var rtt RoundTripTracking
+		rtt.Init(testCase.Options)
+		if capacity := rtt.Capacity(); capacity != testCase.Capacity {
+			t.Errorf("%+v: expected capacity of %d but got %d", testCase.Options, testCase.Capacity, capacity)
+		}
+	}
+	var rtt RoundTripTracking
+	if capacity := rtt.Capacity(); capacity != 256 {
+		t.Errorf("expected zero value to have capacity of 256 but got %d", capacity)
+	}
+}
+
+func TestRoundTripTrackingWrapAround(t *testing.T) {
+	var rtt RoundTripTracking
+	rtt.Init(Options{SendRate: 60})
+	rtt.packetSequenceID = 65530
+	now := time.Unix(1000, 0)
+
+	// send more packets than we have room for across the wrap, and acknowledge
+	// each one after the next is sent
+	var prevSeqID uint16
+	var prevSentTime time.Time
+	for i := 0; i < rtt.Capacity()*2; i++ {
+		seqID := rtt.next(now)
+		if i > 0 {
+			rtt.ack(prevSeqID, prevSentTime.Add(10*time.Millisecond))
+		}
+		prevSeqID = seqID
+		prevSentTime = now
+		now = now.Add(time.Millisecond)
+	}
+	if rtt.rttSampleCount != rttSampleWindow {
+		t.Fatalf("expected every acknowledgement to be a sample, got %d", rtt.rttSampleCount)
+	}
+	if latency := rtt.Latency(); latency != 10*time.Millisecond {
+		t.Errorf("expected latency of 10ms but got %v", latency)
+	}
+
+	// a sequence ID that was overwritten shouldn't be acknowledged
+	sampleIndex := rtt.rttSampleIndex
+	rtt.ack(prevSeqID-uint16(rtt.Capacity()), now)
+	if rtt.rttSampleIndex != sampleIndex {
+		t.Errorf("expected overwritten sequence to be ignored")
+	}
+}
+
+// BenchmarkRoundTripTracking sends and acknowledges 2 packets a frame for 256 connections
+func BenchmarkRoundTripTracking(b *testing.B) {
+	const connectionCount = 256
+	connections := make([]RoundTripTracking, connectionCount)
+	for i := range connections {
+		connections[i].Init(Options{})
+	}
+	now := time.Unix(1000, 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(time.Second / maxFramerate)
+		for c := range connections {
+			rtt := &connections[c]
+			for p := 0; p < 2; p++ {
+				seqID := rtt.next(now)
+				// acknowledge packets sent ~100ms ago
+				rtt.ack(seqID-12, now)
+			}
+		}
+	}
+}
+
+// BenchmarkRoundTripTrackingStats gets the stats of 256 connections, as the server
+// does when logging them
+func BenchmarkRoundTripTrackingStats(b *testing.B) {
+	const connectionCount = 256
+	connections := make([]RoundTripTracking, connectionCount)
+	now := time.Unix(1000, 0)
+	for c := range connections {
+		rtt := &connections[c]
+		for i := 0; i < rtt.Capacity(); i++ {
+			seqID := rtt.next(now)
+			rtt.ack(seqID, now.Add(50*time.Millisecond))
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for c := range connections {
+			_ = connections[c].stats(now)
+		}
+	}
+}
@@ -2,22 +2,40 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"time"
 
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/capture"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/inputbuffer"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconf"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rewind"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/worldstate"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netdriver/webrtcdriver/webrtcserver"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/world"
 )
 
 const (
 	enableDebugPrintingInputFrameBuffer = false
+
+	// maxInvalidPacketCount is how many packets that fail to read or datagrams that fail
+	// their checksum or decompression a connection can send before we close it. Packets
+	// that read fine but break the rules of their packet type, or that only the server
+	// should send, close the connection straight away.
+	maxInvalidPacketCount = 5
+
+	// maxRewindLimit is the most ticks we'll rewind, every tick rewound is another
+	// update of the world we need to simulate
+	maxRewindLimit = netconst.TickRate
 )
 
 // compile-time assert we implement this interface
@@ -25,6 +43,22 @@ var _ netcode.Controller = new(Controller)
 
 func New(options netconf.Options) *Controller {
 	net := &Controller{}
+	net.clock = clock.OrReal(options.Clock)
+	net.checksum = packs.NewChecksum(netconst.ProtocolID)
+	net.compressor = packs.NewCompressor()
+	net.compress = options.Compress
+	net.statsLogInterval = options.StatsLogInterval
+	net.snapshotRate = clampSnapshotRate(options.SnapshotRate)
+	net.history.Init(maxRewindTicks(options.MaxRewind))
+	if options.Capture != nil {
+		captureWriter, err := capture.NewWriter(options.Capture, net.clock.Now())
+		if err != nil {
+			log.Printf("unable to start capture: %v", err)
+		}
+		net.capture = captureWriter
+	}
+	// clients should never send us packets that only the server sends
+	net.packetReader.Registry = packs.ClientToServer
 	net.server = webrtcserver.New(webrtcserver.Options{
 		PublicIP:      options.PublicIP,
 		ICEServerURLs: []string{"stun:" + options.PublicIP + ":3478"},
@@ -36,11 +70,45 @@ type Controller struct {
 	server          *webrtcserver.Server
 	gameConnections []*gameConnection
 
-	buf        *bytes.Buffer
-	backingBuf [65536]byte
+	// sendBuf is the datagram we send to each connection, re-used every frame
+	sendBuf []byte
+	// readBuf and packetReader are re-used to read every datagram so reading packets doesn't allocate
+	readBuf      bytes.Reader
+	packetReader packs.Reader
+	checksum     packs.Checksum
+	compressor   *packs.Compressor
+	// compress is true if we should compress datagrams we send when it makes them smaller
+	compress bool
+	// capture records every datagram sent and received, if set
+	capture *capture.Writer
+	// clock is used for round trip times, captures and logging stats
+	clock clock.Clock
+	// statsLogInterval is how often we log the stats of every connection, 0 if disabled
+	statsLogInterval time.Duration
+	lastStatsLogTime time.Time
 
-	hasStarted     bool
-	worldSnapshots [][]byte
+	hasStarted bool
+
+	// history is the state of the world for the last few ticks, so we can rewind it and
+	// simulate inputs on the tick the client pressed them
+	history rewind.History
+	// lateInputs are the inputs we're simulating in the past this tick, re-used every tick
+	lateInputs []rewind.LateInput
+
+	// tick is how many times we've updated, this is sent with world states
+	tick uint16
+	// snapshotRate is how many world states per second new connections are sent
+	snapshotRate int
+
+	// stateUpdateList is the state of every player this frame, re-used every frame
+	stateUpdateList []packs.PlayerState
+	// ownerStateList is stateUpdateList with the state of the connections own player
+	// swapped for the one that lines up with their inputs, re-used for each connection
+	ownerStateList []packs.PlayerState
+	// worldStatePacket is re-used every frame when sending the world state
+	worldStatePacket packs.ServerWorldStatePacket
+	// clockSyncPacket is re-used when replying to clock sync requests
+	clockSyncPacket packs.ClockSyncResponsePacket
 }
 
 // gameConnection is data specifically related to game-logic and de-coupled from our network driver
@@ -50,14 +118,301 @@ type gameConnection struct {
 	IsUsed    bool
 	AckPacket packs.AckPacket
 
-	rtt         rtt.RoundTripTracking
-	InputBuffer []packs.ClientFrameInput
+	rtt rtt.RoundTripTracking
+	// inputBuffer holds the inputs the client sent until we simulate them
+	inputBuffer inputbuffer.Buffer
+
+	// receivedSequences is used to ignore packets we've already received
+	// or that arrived after a newer packet of the same type
+	receivedSequences packs.PacketSequences
+
+	// invalidPacketCount is how many packets we've failed to read from this connection
+	invalidPacketCount int
 
 	// LastInputFrameSimulated is the last frame number we've simulated
 	LastInputFrameSimulated uint16
 	// NextInputFrameToBeSimulated is the frame number we erecieved from the client that we're going to
 	// simulate this frame
 	NextInputFrameToBeSimulated uint16
+	// lastInputTick is the tick we started simulating NextInputFrameToBeSimulated from
+	lastInputTick    uint16
+	hasLastInputTick bool
+	// prevInputTick is the tick we started simulating LastInputFrameSimulated from
+	prevInputTick    uint16
+	hasPrevInputTick bool
+
+	// worldStates are the world states we've sent to the client, so we can
+	// delta-encode against the last one they acknowledged
+	worldStates worldstate.History
+	// hasAckedWorldState is true once the client has acknowledged at least one world state
+	hasAckedWorldState bool
+	// lastAckedWorldStateSeqID is the sequence ID of the latest world state packet the client acknowledged
+	lastAckedWorldStateSeqID uint16
+
+	// hasClockSyncRequest is true if we need to reply to the latest clock sync request, which
+	// has the sequence ID clockSyncRequestSeqID and was received at clockSyncRequestTime
+	hasClockSyncRequest   bool
+	clockSyncRequestSeqID uint16
+	clockSyncRequestTime  time.Time
+
+	// snapshotRate is how many world states per second we send this connection
+	snapshotRate int
+	// snapshotAccumulator has snapshotRate added every tick and we send a world state when it
+	// reaches the tick rate, this spreads world states evenly even if the rate doesn't divide the tick rate
+	snapshotAccumulator int
+}
+
+// clampSnapshotRate sends a world state every tick if the rate is 0 or less, as we'd never send one
+// otherwise, and caps the rate to one world state per tick
+func clampSnapshotRate(rate int) int {
+	if rate <= 0 || rate > netconst.TickRate {
+		return netconst.TickRate
+	}
+	return rate
+}
+
+// maxRewindTicks converts how far back in time we can rewind to ticks
+func maxRewindTicks(maxRewind time.Duration) int {
+	if maxRewind == 0 {
+		maxRewind = netconst.DefaultMaxRewind
+	}
+	if maxRewind < 0 {
+		return 0
+	}
+	ticks := int(math.Round(maxRewind.Seconds() * netconst.TickRate))
+	if ticks > maxRewindLimit {
+		return maxRewindLimit
+	}
+	return ticks
+}
+
+// inputTick estimates the tick the client pressed the input we're about to simulate. This is how long
+// it took the input to get to us plus how long it waited in the input buffer, capped by how far we can rewind.
+func (net *Controller) inputTick(gameConn *gameConnection) uint16 {
+	rewindTicks := int(math.Round(gameConn.rtt.Latency().Seconds()/2*netconst.TickRate)) + gameConn.inputBuffer.TargetDepth()
+	if maxRewind := net.history.MaxRewind(); rewindTicks > maxRewind {
+		rewindTicks = maxRewind
+	}
+	tick := net.tick - uint16(rewindTicks)
+	if gameConn.hasLastInputTick &&
+		!rtt.IsWrappedUInt16GreaterThan(tick, gameConn.lastInputTick) {
+		// the latency went up since the last input, don't simulate this input on the same
+		// tick as the last input or it'll replace it
+		tick = gameConn.lastInputTick + 1
+	}
+	return tick
+}
+
+// appendDatagram writes what we're sending the connection this tick to sendBuf, this returns false
+// if there's nothing to send
+func (net *Controller) appendDatagram(gameConn *gameConnection) (bool, error) {
+	gameConn.snapshotAccumulator += gameConn.snapshotRate
+	sendWorldState := gameConn.snapshotAccumulator >= netconst.TickRate
+	if sendWorldState {
+		gameConn.snapshotAccumulator -= netconst.TickRate
+	}
+	// note(jae): 2021-04-18
+	// acknowledgements and clock sync replies are sent every tick, even without a world state. If they
+	// waited for the next world state they'd add to the round trip time, which we rewind by.
+	if !sendWorldState &&
+		len(gameConn.AckPacket.SequenceIDList) == 0 &&
+		!gameConn.hasClockSyncRequest {
+		return false, nil
+	}
+	net.sendBuf = net.checksum.AppendHeader(net.sendBuf[:0])
+	net.sendBuf = net.compressor.AppendHeader(net.sendBuf)
+	if len(gameConn.AckPacket.SequenceIDList) > 0 {
+		var err error
+		net.sendBuf, _, err = packs.AppendPacket(net.sendBuf, &gameConn.rtt, &gameConn.AckPacket)
+		if err != nil {
+			return false, fmt.Errorf("failed to write ack packet: %w", err)
+		}
+		gameConn.AckPacket.SequenceIDList = gameConn.AckPacket.SequenceIDList[:0]
+	}
+	if gameConn.hasClockSyncRequest {
+		// we tell the client how long we held onto the request so it isn't counted
+		// in the round trip time
+		packet := &net.clockSyncPacket
+		packet.RequestSequenceID = gameConn.clockSyncRequestSeqID
+		packet.ServerTick = net.tick
+		held := net.clock.Now().Sub(gameConn.clockSyncRequestTime)
+		if held < 0 {
+			held = 0
+		}
+		if held > time.Second {
+			held = time.Second
+		}
+		packet.HeldMicroseconds = uint32(held / time.Microsecond)
+		var err error
+		net.sendBuf, _, err = packs.AppendPacket(net.sendBuf, &gameConn.rtt, packet)
+		if err != nil {
+			return false, fmt.Errorf("failed to write clock sync packet: %w", err)
+		}
+		gameConn.hasClockSyncRequest = false
+	}
+	if player := gameConn.Player; player != nil && sendWorldState {
+		// Only send what changed since the last world state the client acknowledged.
+		// If we no longer have it in our history, send the full world state.
+		baseline, hasBaseline := gameConn.worldStates.Get(gameConn.lastAckedWorldStateSeqID)
+		hasBaseline = hasBaseline && gameConn.hasAckedWorldState
+		packet := &net.worldStatePacket
+		packet.MyNetID = player.NetID
+		var players []packs.PlayerState
+		packet.LastSimulatedInputFrame, players = net.worldState(gameConn)
+		packet.ServerTick = net.tick
+		packet.DeltaEncode(hasBaseline, gameConn.lastAckedWorldStateSeqID, baseline, players)
+		var seqID uint16
+		var err error
+		net.sendBuf, seqID, err = packs.AppendPacket(net.sendBuf, &gameConn.rtt, packet)
+		if err != nil {
+			return false, fmt.Errorf("failed to write world update packet: %w", err)
+		}
+		gameConn.worldStates.Put(seqID, players)
+	}
+	if net.compress {
+		var err error
+		net.sendBuf, err = net.compressor.Compress(net.sendBuf, packs.ChecksumSize)
+		if err != nil {
+			return false, fmt.Errorf("failed to compress datagram: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// nextInput sets the input the connections player is simulated with this tick. If the client pressed
+// it on an earlier tick, it's added to the late inputs so it's simulated on that tick instead.
+func (net *Controller) nextInput(gameConn *gameConnection) {
+	input, isNew := gameConn.inputBuffer.Next()
+	// if we've run out of inputs, this is the last input so the player keeps doing what they were doing
+	gameConn.Player.Inputs = input.PlayerInput
+	if !isNew {
+		return
+	}
+	inputTick := net.inputTick(gameConn)
+	if enableDebugPrintingInputFrameBuffer {
+		fmt.Printf("Frame: %d, last simulated: %d, rewind ticks: %d, input buffer depth: %d (target: %d)\n", input.Frame, gameConn.LastInputFrameSimulated, net.tick-inputTick, gameConn.inputBuffer.Depth(), gameConn.inputBuffer.TargetDepth())
+	}
+	gameConn.LastInputFrameSimulated = gameConn.NextInputFrameToBeSimulated
+	gameConn.NextInputFrameToBeSimulated = input.Frame
+	gameConn.prevInputTick = gameConn.lastInputTick
+	gameConn.hasPrevInputTick = gameConn.hasLastInputTick
+	gameConn.lastInputTick = inputTick
+	gameConn.hasLastInputTick = true
+	if inputTick == net.tick {
+		return
+	}
+	net.lateInputs = append(net.lateInputs, rewind.LateInput{
+		NetID: gameConn.Player.NetID,
+		Tick:  inputTick,
+		Input: input.PlayerInput,
+	})
+}
+
+// rewindLateInputs simulates late inputs on the tick the client pressed them and gets the state of every
+// player to send this tick. This returns how many ticks were simulated again.
+func (net *Controller) rewindLateInputs(world *world.World) int {
+	// every player is moved to where they are after the late inputs before we send the world state
+	rewindTicks := net.history.Rewind(world.Players, net.lateInputs)
+	net.history.Record(net.tick, world.Players)
+
+	// Get the state of every player once, rather than once per connection
+	net.stateUpdateList = net.stateUpdateList[:0]
+	for _, entity := range world.Players {
+		net.stateUpdateList = append(net.stateUpdateList, playerState(entity))
+	}
+	return rewindTicks
+}
+
+// worldState returns the world state to send the connection this tick, along with the newest
+// input frame their player has simulated in it.
+//
+// The client replays every input after that frame on top of the state of their player, so their
+// player needs to have simulated that frame exactly once. That isn't the case in the present, an input
+// we rewound for has been simulated on every tick since, so we send them their player from the
+// history on the tick after the input was simulated instead.
+func (net *Controller) worldState(gameConn *gameConnection) (lastSimulatedInputFrame uint16, players []packs.PlayerState) {
+	if gameConn.Player == nil {
+		return gameConn.LastInputFrameSimulated, net.stateUpdateList
+	}
+	frame, tick := gameConn.NextInputFrameToBeSimulated, gameConn.lastInputTick+1
+	hasTick := gameConn.hasLastInputTick
+	if hasTick &&
+		gameConn.lastInputTick == net.tick {
+		// the newest input hasn't been simulated yet
+		frame, tick = gameConn.LastInputFrameSimulated, gameConn.prevInputTick+1
+		hasTick = gameConn.hasPrevInputTick
+	}
+	if !hasTick {
+		return frame, net.stateUpdateList
+	}
+	state, ok := net.history.Player(tick, gameConn.Player.NetID)
+	if !ok {
+		// the input is older than the history, the present is as close as we can get
+		return frame, net.stateUpdateList
+	}
+	net.ownerStateList = append(net.ownerStateList[:0], net.stateUpdateList...)
+	for i := range net.ownerStateList {
+		if net.ownerStateList[i].NetID == state.NetID {
+			net.ownerStateList[i] = playerState(&state)
+		}
+	}
+	return frame, net.ownerStateList
+}
+
+func playerState(entity *ent.Player) packs.PlayerState {
+	return packs.PlayerState{
+		NetID:   entity.NetID,
+		X:       entity.X,
+		Y:       entity.Y,
+		Hspeed:  entity.Hspeed,
+		Vspeed:  entity.Vspeed,
+		DirLeft: entity.DirLeft,
+	}
+}
+
+// SetSnapshotRate changes how many world states per second are sent to a connection, ie. to
+// send less to clients with a poor connection. This returns false if the connection isn't in use.
+func (net *Controller) SetSnapshotRate(connID uint16, rate int) bool {
+	for _, gameConn := range net.gameConnections {
+		if gameConn.ID == connID && gameConn.IsUsed {
+			gameConn.snapshotRate = clampSnapshotRate(rate)
+			return true
+		}
+	}
+	return false
+}
+
+// rejectPacket handles a packet that couldn't be read or broke the rules of its packet type,
+// it returns true if the connection was closed
+func rejectPacket(conn *webrtcserver.Connection, gameConn *gameConnection, err error) bool {
+	var invalidID *packs.InvalidPacketID
+	var validationErr *packs.ValidationError
+	if errors.As(err, &invalidID) ||
+		errors.As(err, &validationErr) {
+		// a client following the protocol never sends these, so don't give them another chance
+		log.Printf("closing connection %d, invalid packet: %v", gameConn.ID, err)
+		conn.CloseButDontFree()
+		return true
+	}
+	gameConn.invalidPacketCount++
+	if gameConn.invalidPacketCount >= maxInvalidPacketCount {
+		log.Printf("closing connection %d, sent %d packets that couldn't be read, last error: %v", gameConn.ID, gameConn.invalidPacketCount, err)
+		conn.CloseButDontFree()
+		return true
+	}
+	log.Printf("unable to read packet from connection %d: %v", gameConn.ID, err)
+	return false
+}
+
+// record adds the datagram to the capture, if capturing
+func (net *Controller) record(dir capture.Direction, connID uint16, datagram []byte) {
+	if net.capture == nil {
+		return
+	}
+	if err := net.capture.Record(net.clock.Now(), dir, connID, datagram); err != nil {
+		log.Printf("stopping capture, unable to record datagram: %v", err)
+		net.capture = nil
+	}
 }
 
 func (net *Controller) init(world *world.World) {
@@ -66,9 +421,10 @@ func (net *Controller) init(world *world.World) {
 		gameConn := &gameConnection{}
 		// note: ID should never be 0
 		gameConn.ID = uint16(i) + 1
+		gameConn.rtt.Init(rtt.Options{Clock: net.clock})
 		net.gameConnections[i] = gameConn
 	}
-	net.buf = bytes.NewBuffer(net.backingBuf[:])
+	net.sendBuf = make([]byte, 0, 65536)
 
 	log.Printf("starting server...")
 	net.server.Start()
@@ -85,18 +441,6 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 		net.hasStarted = true
 	}
 
-	// Take a snapshot of world state so we can rewind the universe and
-	// playback a players actions when we receive inputs
-	/* const maxSnapshotCount = 10
-	if len(net.worldSnapshots) >= maxSnapshotCount {
-		for i := 1; i < len(net.worldSnapshots); i++ {
-			net.worldSnapshots[i-1] = net.worldSnapshots[i]
-		}
-		net.worldSnapshots[len(net.worldSnapshots)-1] = world.Snapshot()
-	} else {
-		net.worldSnapshots = append(net.worldSnapshots, world.Snapshot())
-	} */
-
 	for i, conn := range net.server.Connections() {
 		gameConn := net.gameConnections[i]
 		if !conn.IsConnected() {
@@ -108,6 +452,7 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 				*gameConn = gameConnection{}
 				// note: ID should never be 0
 				gameConn.ID = id
+				gameConn.rtt.Init(rtt.Options{Clock: net.clock})
 				conn.Free()
 			}
 			continue
@@ -120,6 +465,9 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 				panic("developer mistake, ID should never be 0")
 			}
 			gameConn.IsUsed = true
+			gameConn.snapshotRate = net.snapshotRate
+			// send a world state straight away
+			gameConn.snapshotAccumulator = netconst.TickRate
 		}
 
 		// read packets
@@ -129,15 +477,36 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 			if !ok {
 				break
 			}
-			var buf bytes.Reader
-			buf.Reset(byteData)
+			net.record(capture.Received, gameConn.ID, byteData)
+			gameConn.rtt.Received(len(byteData))
+			payload, err := net.checksum.Open(byteData)
+			if err == nil {
+				payload, err = net.compressor.Decompress(payload)
+			}
+			if err != nil {
+				if rejectPacket(conn, gameConn, err) {
+					break MainReadLoop
+				}
+				continue
+			}
+			net.readBuf.Reset(payload)
 			for {
-				sequenceID, packet, err := packs.Read(&buf)
+				sequenceID, packet, err := net.packetReader.Read(&net.readBuf)
+				if err == nil {
+					err = packs.Validate(packet)
+				}
 				if err != nil {
 					if err == io.EOF {
 						break
 					}
-					log.Printf("unable to read packet: %v", err)
+					if rejectPacket(conn, gameConn, err) {
+						break MainReadLoop
+					}
+					// the rest of the datagram can't be trusted if we failed to read a packet
+					break
+				}
+				seqStatus := gameConn.receivedSequences.Receive(packet.ID(), sequenceID)
+				if seqStatus == packs.SequenceDuplicate {
 					continue
 				}
 				if _, ok := packet.(*packs.AckPacket); !ok {
@@ -148,28 +517,29 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 				case *packs.AckPacket:
 					for _, seqID := range packet.SequenceIDList {
 						gameConn.rtt.Ack(seqID)
+						if _, ok := gameConn.worldStates.Get(seqID); ok {
+							if !gameConn.hasAckedWorldState ||
+								rtt.IsWrappedUInt16GreaterThan(seqID, gameConn.lastAckedWorldStateSeqID) {
+								gameConn.lastAckedWorldStateSeqID = seqID
+								gameConn.hasAckedWorldState = true
+							}
+						}
 					}
 				case *packs.ClientPlayerPacket:
-					if len(packet.InputBuffer) > netconst.MaxServerInputBuffer {
-						fmt.Printf("disconnecting client, they sent %d input packets when the limit is %d", len(packet.InputBuffer), netconst.MaxServerInputBuffer)
-						conn.CloseButDontFree()
-						break MainReadLoop
+					if seqStatus == packs.SequenceStale {
+						// a newer packet has already given us these inputs
+						break
 					}
-					if len(packet.InputBuffer) == 0 {
-						// do nothing
-					} else {
-						if len(gameConn.InputBuffer) > 0 {
-							// We only use the given input buffer if the last item
-							// is on a later frame than the current input buffer
-							prevInputBuffer := gameConn.InputBuffer[len(gameConn.InputBuffer)-1]
-							nextInputBuffer := packet.InputBuffer[len(packet.InputBuffer)-1]
-							if rtt.IsWrappedUInt16GreaterThan(nextInputBuffer.Frame, prevInputBuffer.Frame) {
-								gameConn.InputBuffer = packet.InputBuffer
-							}
-						} else {
-							gameConn.InputBuffer = packet.InputBuffer
-						}
+					// inputs are copied as the packet is re-used when reading the next packet
+					gameConn.inputBuffer.Add(packet.InputBuffer)
+				case *packs.ClockSyncRequestPacket:
+					if seqStatus == packs.SequenceStale {
+						// we only reply to the latest request
+						break
 					}
+					gameConn.hasClockSyncRequest = true
+					gameConn.clockSyncRequestSeqID = sequenceID
+					gameConn.clockSyncRequestTime = net.clock.Now()
 				default:
 					log.Printf("unhandled packet type: %T", packet)
 				}
@@ -178,6 +548,7 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 	}
 
 	// note(jae): 2021-04-03
+	net.lateInputs = net.lateInputs[:0]
 	for i, conn := range net.server.Connections() {
 		if !conn.IsConnected() {
 			continue
@@ -190,8 +561,6 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 		}
 		// Get the next un-simulated input from the clients buffer of inputs
 		// and set the client entity to use that when simulating this frame
-		var inputBuffer packs.ClientFrameInput
-		var foundCount int
 
 		// note(jae): 2021-04-05
 		// Consider replacing this with generous world rewinding... ie.
@@ -216,68 +585,22 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 		//
 		// People on good network connections could potentially figure out how to abuse this in other creative ways without hacking
 		// too.
-		if enableDebugPrintingInputFrameBuffer {
-			fmt.Printf("------ Frame --------\n")
-			fmt.Printf("Last simulated: %d\n", gameConn.LastInputFrameSimulated)
-		}
-		for _, otherInputBuffer := range gameConn.InputBuffer {
-			if otherInputBuffer.Frame == 0 {
-				log.Printf("invalid packet data or developer mistake, frame should never be 0. closing")
-				conn.CloseButDontFree()
-				break
-			}
-			if rtt.IsWrappedUInt16GreaterThan(otherInputBuffer.Frame, gameConn.LastInputFrameSimulated) {
-				if foundCount == 0 {
-					if enableDebugPrintingInputFrameBuffer {
-						fmt.Printf("\n- Frame (found): %d\n", otherInputBuffer.Frame)
-					}
-					inputBuffer = otherInputBuffer
-				} else if enableDebugPrintingInputFrameBuffer {
-					fmt.Printf("%d, ", otherInputBuffer.Frame)
-				}
-				foundCount++
-				continue
-			}
-			if enableDebugPrintingInputFrameBuffer {
-				fmt.Printf("%d, ", otherInputBuffer.Frame)
-			}
-		}
-		if enableDebugPrintingInputFrameBuffer {
-			fmt.Printf("\n------ End Frame --------\n")
-			fmt.Printf("Net RTT: %v\n", gameConn.rtt.Latency())
-		}
-		// note(jae): 2021-04-05
-		// we might want to adjust this later so we can handle
-		// input more smoothly with jitter
-		// ie. foundCount > 1 or foundCount > 2
 		//
-		// We want to smooth out input packets because they can sometimes arrive inconsistently
+		// This is done now with rewind.History, MaxRewind limits how far back we go to make abuse harder.
+		//
+		// We hold onto a few inputs and simulate one per tick, as packets can arrive inconsistently
 		// ie. frame 1 - get 1 input packet
 		//	   frame 2 - no input packet
 		//	   frame 3 - get 2 input packets
 		//
-		// Because packets don't necessarily arrive together, a jitter buffer ensures that groups of inputs
-		// are processed together such as a combo in a fighting game, or a precise platforming manuver.
-		// Without this, a gap in the inputs being processed could lead to a combo/manuver being broken.
-		//
-		// The problem with using an input jitter buffer though is that we end up processing frames later.
-		// (Which might be a non-problem if we implement the rewinding world system mentioned above in a big comment)
-		if foundCount > 0 {
-			if inputBuffer.Frame == 0 {
-				log.Printf("invalid packet data or developer mistake, frame should never be 0. closing")
-				conn.CloseButDontFree()
-				break
-			}
-			gameConn.Player.Inputs = inputBuffer.PlayerInput
-			gameConn.LastInputFrameSimulated = gameConn.NextInputFrameToBeSimulated
-			gameConn.NextInputFrameToBeSimulated = inputBuffer.Frame
-		} else {
-			// reset to all zero values
-			gameConn.Player.Inputs = ent.PlayerInput{}
-		}
+		// The problem with using an input jitter buffer though is that we end up processing frames later,
+		// so we rewind the time the input spent in the buffer too.
+		net.nextInput(gameConn)
 	}
 
-	// Send player data to everybody on every frame
+	net.rewindLateInputs(world)
+
+	// Send player data to everybody at their snapshot rate
 	// (this is not good engineering, this isnt even OK engineering)
 	for i, conn := range net.server.Connections() {
 		if !conn.IsConnected() {
@@ -288,53 +611,65 @@ func (net *Controller) BeforeUpdate(world *world.World) {
 			// skip if not used
 			continue
 		}
-		net.buf.Reset()
-		if len(gameConn.AckPacket.SequenceIDList) > 0 {
-			if err := packs.Write(net.buf, &gameConn.rtt, &gameConn.AckPacket); err != nil {
-				log.Printf("failed to write ack packet: %v, closing connection", err)
-				conn.CloseButDontFree()
-				continue
-			}
-			gameConn.AckPacket.SequenceIDList = gameConn.AckPacket.SequenceIDList[:0]
+		hasDatagram, err := net.appendDatagram(gameConn)
+		if err != nil {
+			log.Printf("%v, closing connection", err)
+			conn.CloseButDontFree()
+			continue
 		}
-		if player := gameConn.Player; player != nil {
-			stateUpdateList := make([]packs.PlayerState, 0, len(world.Players))
-			for _, entity := range world.Players {
-				stateUpdateList = append(stateUpdateList, packs.PlayerState{
-					NetID:   entity.NetID,
-					X:       entity.X,
-					Y:       entity.Y,
-					Hspeed:  entity.Hspeed,
-					Vspeed:  entity.Vspeed,
-					DirLeft: entity.DirLeft,
-				})
-			}
-			if err := packs.Write(net.buf, &gameConn.rtt, &packs.ServerWorldStatePacket{
-				MyNetID:                 player.NetID,
-				LastSimulatedInputFrame: gameConn.LastInputFrameSimulated,
-				Players:                 stateUpdateList,
-			}); err != nil {
-				log.Printf("failed to write world update packet: %v", err)
-				conn.CloseButDontFree()
-				continue
-			}
+		if !hasDatagram {
+			continue
 		}
 		// Upper limit of packets in gamedev are generally: "something like 1000 to 1200 bytes of payload data"
 		// source: https://www.gafferongames.com/post/packet_fragmentation_and_reassembly/
-		if net.buf.Len() > 1000 {
+		if len(net.sendBuf) > 1000 {
 			// note(jae): 2021-04-02
 			// when i looked at raw packet data in Wireshark, packets were about ~100 bytes, even if i was sending ~20 bytes
 			// of data. DTLS v1.2 / WebRTC / DataChannels may have a 100 byte overhead that I need to consider
 			// when printing this kind of warning logic
-			log.Printf("warning: size of packet is %d, should be conservative and fit between 1000-1200", net.buf.Len())
+			log.Printf("warning: size of packet is %d, should be conservative and fit between 1000-1200", len(net.sendBuf))
 		}
 		// DEBUG: uncomment to debug packet size
-		//log.Printf("note: size of packet is %d (rtt latency: %v)", net.buf.Len(), gameConn.rtt.Latency())
+		//log.Printf("note: size of packet is %d (rtt latency: %v)", len(net.sendBuf), gameConn.rtt.Latency())
 
-		if err := conn.Send(net.buf.Bytes()); err != nil {
+		net.checksum.Seal(net.sendBuf)
+		net.record(capture.Sent, gameConn.ID, net.sendBuf)
+		if err := conn.Send(net.sendBuf); err != nil {
 			log.Printf("failed to send: %v", err)
 			conn.CloseButDontFree()
 			continue
 		}
+		gameConn.rtt.Sent(len(net.sendBuf))
+	}
+	// the world is updated after this
+	net.tick++
+
+	if net.statsLogInterval > 0 &&
+		net.clock.Now().Sub(net.lastStatsLogTime) >= net.statsLogInterval {
+		net.lastStatsLogTime = net.clock.Now()
+		for _, stats := range net.Stats() {
+			log.Printf("connection %d: %v, input buffer: %d (target: %d)", stats.ConnID, stats.Stats, stats.InputBufferDepth, stats.InputBufferTargetDepth)
+		}
+	}
+}
+
+// Stats returns the network statistics of every connected client
+func (net *Controller) Stats() []netcode.ConnectionStats {
+	var statsList []netcode.ConnectionStats
+	for i, conn := range net.server.Connections() {
+		if !conn.IsConnected() {
+			continue
+		}
+		gameConn := net.gameConnections[i]
+		if !gameConn.IsUsed {
+			continue
+		}
+		statsList = append(statsList, netcode.ConnectionStats{
+			ConnID:                 gameConn.ID,
+			Stats:                  gameConn.rtt.Stats(),
+			InputBufferDepth:       gameConn.inputBuffer.Depth(),
+			InputBufferTargetDepth: gameConn.inputBuffer.TargetDepth(),
+		})
 	}
+	return statsList
 }
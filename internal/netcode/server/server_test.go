@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/ent"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/correction"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/netconst"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/rtt"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/world"
+)
+
+// latencyTicks is how many ticks packets take to get between the test client and server
+const latencyTicks = 3
+
+type testWorldState struct {
+	lastSimulatedInputFrame uint16
+	players                 []packs.PlayerState
+}
+
+// testClient predicts its own player and reconciles with world states the same way the client does
+type testClient struct {
+	player     ent.Player
+	frame      uint16
+	inputs     []packs.ClientFrameInput
+	correction correction.Smoother
+}
+
+func (client *testClient) reconcile(state testWorldState) {
+	predictedX, predictedY := client.player.X, client.player.Y
+	for _, playerState := range state.players {
+		if playerState.NetID == client.player.NetID {
+			client.player.X = playerState.X
+			client.player.Y = playerState.Y
+			client.player.Hspeed = playerState.Hspeed
+			client.player.Vspeed = playerState.Vspeed
+			client.player.DirLeft = playerState.DirLeft
+		}
+	}
+	prevInput := client.player.Inputs
+	for _, input := range client.inputs {
+		if !rtt.IsWrappedUInt16GreaterThan(input.Frame, state.lastSimulatedInputFrame) {
+			continue
+		}
+		if input.Frame == client.frame {
+			break
+		}
+		client.player.Inputs = input.PlayerInput
+		client.player.Update()
+	}
+	client.player.Inputs = prevInput
+	client.correction.Correct(predictedX, predictedY, client.player.X, client.player.Y)
+}
+
+// testInput is what the test client presses on each frame
+func testInput(frame uint16) ent.PlayerInput {
+	switch {
+	case frame > 60 && frame <= 100:
+		return ent.PlayerInput{IsHoldingRight: true}
+	case frame > 120 && frame <= 125:
+		return ent.PlayerInput{IsHoldingLeft: true, IsHoldingJump: true}
+	}
+	return ent.PlayerInput{}
+}
+
+func TestLateInputsReconcileWithClient(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	net := &Controller{clock: clk}
+	net.history.Init(maxRewindTicks(0))
+	var w world.World
+	gameConn := &gameConnection{ID: 1, IsUsed: true}
+	gameConn.rtt.Init(rtt.Options{Clock: clk})
+	gameConn.Player = w.CreatePlayer()
+	gameConn.Player.NetID = gameConn.ID
+	// the round trip time is what we rewind by, so make it line up with the test latency
+	for i := 0; i < 10; i++ {
+		seqID := gameConn.rtt.Next()
+		clk.Advance(2 * latencyTicks * time.Second / netconst.TickRate)
+		gameConn.rtt.Ack(seqID)
+	}
+
+	client := &testClient{player: ent.Player{NetID: gameConn.ID, X: gameConn.Player.X, Y: gameConn.Player.Y}}
+	client.player.Init()
+
+	const warmupFrames = 60
+	inputPackets := make(map[int][]packs.ClientFrameInput)
+	worldStates := make(map[int]testWorldState)
+	rewindCount := 0
+	var warmupStats correction.Stats
+	for i := 0; i < 200; i++ {
+		// client
+		client.frame++
+		client.player.Inputs = testInput(client.frame)
+		client.inputs = append(client.inputs, packs.ClientFrameInput{
+			Frame:       client.frame,
+			PlayerInput: client.player.Inputs,
+		})
+		if state, ok := worldStates[i]; ok {
+			client.reconcile(state)
+		}
+		inputs := client.inputs
+		if len(inputs) > netconst.MaxServerInputBuffer {
+			inputs = inputs[len(inputs)-netconst.MaxServerInputBuffer:]
+		}
+		inputPackets[i+latencyTicks] = append([]packs.ClientFrameInput(nil), inputs...)
+		client.player.Update()
+		if client.frame == warmupFrames {
+			warmupStats = client.correction.Stats()
+		}
+
+		// server, in the same order as BeforeUpdate
+		if inputs, ok := inputPackets[i]; ok {
+			gameConn.inputBuffer.Add(inputs)
+		}
+		net.lateInputs = net.lateInputs[:0]
+		net.nextInput(gameConn)
+		if net.rewindLateInputs(&w) > 0 {
+			rewindCount++
+		}
+		frame, players := net.worldState(gameConn)
+		worldStates[i+latencyTicks] = testWorldState{
+			lastSimulatedInputFrame: frame,
+			players:                 append([]packs.PlayerState(nil), players...),
+		}
+		net.tick++
+		w.Update()
+	}
+
+	if rewindCount == 0 {
+		t.Fatalf("expected inputs to be simulated late by rewinding")
+	}
+	if gameConn.Player.X == 180 {
+		t.Fatalf("expected player to have moved but is at %v", gameConn.Player.X)
+	}
+	stats := client.correction.Stats()
+	if mispredictions := stats.Mispredictions - warmupStats.Mispredictions; mispredictions != 0 {
+		t.Errorf("expected no mispredictions while holding an input across rewinds, but got %d: %v", mispredictions, stats)
+	}
+}
+
+func TestAcksAreSentEveryTick(t *testing.T) {
+	net := &Controller{
+		checksum:   packs.NewChecksum(netconst.ProtocolID),
+		compressor: packs.NewCompressor(),
+	}
+	var w world.World
+	gameConn := &gameConnection{ID: 1, IsUsed: true, snapshotRate: netconst.TickRate / 3}
+	gameConn.Player = w.CreatePlayer()
+	gameConn.Player.NetID = gameConn.ID
+
+	worldStateCount := 0
+	for i := 0; i < 6; i++ {
+		gameConn.AckPacket.SequenceIDList = append(gameConn.AckPacket.SequenceIDList, uint16(i))
+		hasDatagram, err := net.appendDatagram(gameConn)
+		if err != nil {
+			t.Fatalf("tick %d: failed to write datagram: %v", i, err)
+		}
+		if !hasDatagram {
+			t.Fatalf("tick %d: expected acknowledgement to be sent without waiting for a world state", i)
+		}
+		hasAck := false
+		r := openDatagram(t, net)
+		for r.Len() > 0 {
+			_, packet, err := packs.ServerToClient.Read(r)
+			if err != nil {
+				t.Fatalf("tick %d: failed to read packet: %v", i, err)
+			}
+			switch packet := packet.(type) {
+			case *packs.AckPacket:
+				hasAck = len(packet.SequenceIDList) == 1 && packet.SequenceIDList[0] == uint16(i)
+			case *packs.ServerWorldStatePacket:
+				worldStateCount++
+			}
+		}
+		if !hasAck {
+			t.Errorf("tick %d: expected acknowledgement of %d", i, i)
+		}
+		net.tick++
+	}
+	if worldStateCount != 2 {
+		t.Errorf("expected a world state every 3 ticks but sent %d in 6 ticks", worldStateCount)
+	}
+}
+
+// openDatagram returns the packets in the datagram written by appendDatagram
+func openDatagram(t *testing.T, net *Controller) *bytes.Reader {
+	net.checksum.Seal(net.sendBuf)
+	payload, err := net.checksum.Open(net.sendBuf)
+	if err == nil {
+		payload, err = net.compressor.Decompress(payload)
+	}
+	if err != nil {
+		t.Fatalf("failed to open datagram: %v", err)
+	}
+	return bytes.NewReader(payload)
+}
+
+func TestClockSyncReplyHeldTime(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1000, 0))
+	net := &Controller{
+		clock:      clk,
+		checksum:   packs.NewChecksum(netconst.ProtocolID),
+		compressor: packs.NewCompressor(),
+	}
+	gameConn := &gameConnection{ID: 1, IsUsed: true, snapshotRate: netconst.TickRate}
+	gameConn.rtt.Init(rtt.Options{Clock: clk})
+
+	for _, held := range []time.Duration{0, 3500 * time.Microsecond, 2 * time.Second} {
+		// the request is read at the start of the tick and we reply at the end of it, ie. after
+		// rewinding the world to simulate late inputs
+		gameConn.hasClockSyncRequest = true
+		gameConn.clockSyncRequestSeqID = 7
+		gameConn.clockSyncRequestTime = clk.Now()
+		clk.Advance(held)
+		if _, err := net.appendDatagram(gameConn); err != nil {
+			t.Fatalf("failed to write datagram: %v", err)
+		}
+		expected := held
+		if expected > time.Second {
+			expected = time.Second
+		}
+		var reply *packs.ClockSyncResponsePacket
+		r := openDatagram(t, net)
+		for r.Len() > 0 {
+			_, packet, err := packs.ServerToClient.Read(r)
+			if err != nil {
+				t.Fatalf("failed to read packet: %v", err)
+			}
+			if packet, ok := packet.(*packs.ClockSyncResponsePacket); ok {
+				reply = packet
+			}
+		}
+		if reply == nil {
+			t.Fatalf("expected a clock sync reply")
+		}
+		if reply.RequestSequenceID != 7 ||
+			time.Duration(reply.HeldMicroseconds)*time.Microsecond != expected {
+			t.Errorf("expected reply to request 7 held for %v but got %+v", expected, reply)
+		}
+	}
+}
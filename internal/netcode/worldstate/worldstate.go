@@ -0,0 +1,47 @@
+// worldstate is a package that keeps a history of world states sent to or received
+// from the server so that world state packets can be delta-encoded against them
+package worldstate
+
+import (
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/netcode/packs"
+)
+
+// historySize is how many world states we keep around.
+//
+// We send at most one world state every frame at 60 frames per second and packets that haven't been
+// acknowledged after a second aren't useful (see rtt package), so this needs to be at least 60.
+// We keep it a power of 2 so that indexing by sequence ID stays consistent when the uint16 wraps.
+const historySize = 64
+
+// History is a fixed-size ring buffer of player states, indexed by the sequence ID of the
+// world state packet they were sent or received with.
+type History struct {
+	entries [historySize]historyEntry
+}
+
+type historyEntry struct {
+	SequenceID uint16
+	IsUsed     bool
+	Players    []packs.PlayerState
+}
+
+// Put stores a copy of the player states for the given sequence ID, overwriting
+// whatever was stored in its slot previously.
+func (history *History) Put(seqID uint16, players []packs.PlayerState) {
+	entry := &history.entries[seqID%historySize]
+	entry.SequenceID = seqID
+	entry.IsUsed = true
+	// reuse the backing array of the slot so we don't allocate every frame
+	entry.Players = append(entry.Players[:0], players...)
+}
+
+// Get returns the player states stored for the given sequence ID. If it was
+// overwritten or never stored, this will return false.
+func (history *History) Get(seqID uint16) ([]packs.PlayerState, bool) {
+	entry := &history.entries[seqID%historySize]
+	if !entry.IsUsed ||
+		entry.SequenceID != seqID {
+		return nil, false
+	}
+	return entry.Players, true
+}
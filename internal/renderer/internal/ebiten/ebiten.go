@@ -4,6 +4,7 @@ import (
 	"image"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/renderer/internal/rendereriface"
 )
 
@@ -34,6 +35,14 @@ func (app *App) SetWindowTitle(title string) {
 	ebiten.SetWindowTitle(title)
 }
 
+func (app *App) SetClock(clock clock.Clock) {
+	// n/a for ebiten, it has its own clock
+}
+
+func (app *App) SetTickRate(tps int) {
+	ebiten.SetMaxTPS(tps)
+}
+
 func (app *App) NewImageFromImage(img image.Image) rendereriface.Image {
 	return ebiten.NewImageFromImage(img)
 }
@@ -4,14 +4,30 @@ package headless
 
 import (
 	"image"
+	"log"
 	"time"
 
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
 	"github.com/silbinarywolf/toy-webrtc-mmo/internal/renderer/internal/rendereriface"
 )
 
 var _ rendereriface.App = new(App)
 
+const (
+	// defaultTickRate is used if SetTickRate isn't called, this matches Ebiten
+	defaultTickRate = 60
+
+	// maxCatchUpTicks is how many updates we run back-to-back when we've fallen behind,
+	// ticks past this are dropped so a slow server doesn't spend forever catching up
+	maxCatchUpTicks = 5
+
+	// overrunReportInterval is how often we log updates that took longer than a tick
+	overrunReportInterval = time.Second
+)
+
 type App struct {
+	clock    clock.Clock
+	tickRate int
 }
 
 func (app *App) SetRunnableOnUnfocused(v bool) {
@@ -26,20 +42,87 @@ func (app *App) SetWindowTitle(title string) {
 	// n/a for headless
 }
 
+// SetClock sets the clock used to time updates, if not set this is the system clock.
+// A clock.Manual will run updates as fast as possible.
+func (app *App) SetClock(clock clock.Clock) {
+	app.clock = clock
+}
+
+// SetTickRate sets how many times per second Update is called
+func (app *App) SetTickRate(tps int) {
+	app.tickRate = tps
+}
+
+// RunGame calls Update at a fixed rate until it returns an error.
+//
+// Time that passes is added to an accumulator and we run an update for every tick
+// that fits into it, so the game runs at the same rate on average even if we're
+// woken up late or an update takes too long.
+//
+// Source: https://gafferongames.com/post/fix_your_timestep/
 func (app *App) RunGame(game rendereriface.Game) error {
-	// note(jae): 2021-03-18
-	// this should probably align with how the Ebiten clock works
-	// but I'm going to take a lazy shortcut.
-	tick := time.NewTicker(16 * time.Millisecond)
+	clk := clock.OrReal(app.clock)
+	tickRate := app.tickRate
+	if tickRate <= 0 {
+		tickRate = defaultTickRate
+	}
+	tickDuration := time.Second / time.Duration(tickRate)
+
+	var accumulator time.Duration
+	lastTime := clk.Now()
+	overruns := overrunReport{lastReportTime: lastTime}
 	for {
-		select {
-		case <-tick.C:
+		now := clk.Now()
+		accumulator += now.Sub(lastTime)
+		lastTime = now
+		for steps := 0; accumulator >= tickDuration; steps++ {
+			if steps == maxCatchUpTicks {
+				droppedTicks := accumulator / tickDuration
+				overruns.droppedTicks += int(droppedTicks)
+				accumulator -= droppedTicks * tickDuration
+				break
+			}
+			updateStartTime := clk.Now()
 			if err := game.Update(); err != nil {
 				return err
 			}
+			if took := clk.Now().Sub(updateStartTime); took > tickDuration {
+				overruns.add(took)
+			}
+			accumulator -= tickDuration
 		}
+		overruns.logIfDue(clk.Now(), tickDuration)
+		clk.Sleep(tickDuration - accumulator)
 	}
-	return nil
+}
+
+// overrunReport counts updates that took longer than a tick and ticks we dropped, these
+// are logged at most once per overrunReportInterval so a struggling server doesn't spam logs
+type overrunReport struct {
+	lastReportTime time.Time
+	// count is how many updates took longer than a tick
+	count int
+	// slowest is the longest an update took
+	slowest time.Duration
+	// droppedTicks is how many ticks were skipped because we couldn't catch up
+	droppedTicks int
+}
+
+func (report *overrunReport) add(took time.Duration) {
+	report.count++
+	if took > report.slowest {
+		report.slowest = took
+	}
+}
+
+func (report *overrunReport) logIfDue(now time.Time, tickDuration time.Duration) {
+	if now.Sub(report.lastReportTime) < overrunReportInterval {
+		return
+	}
+	if report.count > 0 || report.droppedTicks > 0 {
+		log.Printf("warning: %d updates took longer than %v (slowest: %v), dropped %d ticks to catch up", report.count, tickDuration, report.slowest, report.droppedTicks)
+	}
+	*report = overrunReport{lastReportTime: now}
 }
 
 func (app *App) NewImageFromImage(img image.Image) rendereriface.Image {
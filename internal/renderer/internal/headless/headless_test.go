@@ -0,0 +1,151 @@
+package headless
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/renderer/internal/rendereriface"
+)
+
+const tickDuration = time.Second / defaultTickRate
+
+var errTestGameStopped = errors.New("stopped after the last update")
+
+// testGame records when each update happened and stops the loop after maxUpdates
+type testGame struct {
+	clock      *clock.Manual
+	maxUpdates int
+	updates    []time.Time
+	// onUpdate is called during each update with how many updates there have been
+	onUpdate func(count int)
+}
+
+func (game *testGame) Update() error {
+	game.updates = append(game.updates, game.clock.Now())
+	if game.onUpdate != nil {
+		game.onUpdate(len(game.updates))
+	}
+	if len(game.updates) >= game.maxUpdates {
+		return errTestGameStopped
+	}
+	return nil
+}
+
+func (game *testGame) Draw(screen rendereriface.Screen) {
+}
+
+func (game *testGame) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+	return outsideWidth, outsideHeight
+}
+
+func runTestGame(t *testing.T, game *testGame) {
+	app := &App{}
+	app.SetClock(game.clock)
+	if err := app.RunGame(game); err != errTestGameStopped {
+		t.Fatalf("expected RunGame to return the error from Update but got %v", err)
+	}
+}
+
+// captureLog returns what's logged while running f
+func captureLog(f func()) string {
+	var buf bytes.Buffer
+	output, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+	}()
+	f()
+	return buf.String()
+}
+
+func TestRunGameFixedStep(t *testing.T) {
+	start := time.Unix(1000, 0)
+	game := &testGame{clock: clock.NewManual(start), maxUpdates: 10}
+	runTestGame(t, game)
+	for i, updateTime := range game.updates {
+		if expected := start.Add(time.Duration(i+1) * tickDuration); !updateTime.Equal(expected) {
+			t.Errorf("update %d: expected to run at %v but ran at %v", i, expected.Sub(start), updateTime.Sub(start))
+		}
+	}
+}
+
+func TestRunGameCatchUpCap(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clock.NewManual(start)
+	game := &testGame{clock: clk, maxUpdates: 100}
+	game.onUpdate = func(count int) {
+		if count == 1 {
+			// the clock jumps forward a second, ie. the process was suspended
+			clk.Advance(time.Second)
+		}
+	}
+	output := captureLog(func() {
+		runTestGame(t, game)
+	})
+
+	// we only catch up on a few ticks back-to-back, the rest are dropped
+	catchUpTime := game.updates[1]
+	catchUpCount := 0
+	for _, updateTime := range game.updates[1:] {
+		if updateTime.Equal(catchUpTime) {
+			catchUpCount++
+		}
+	}
+	if catchUpCount != maxCatchUpTicks {
+		t.Errorf("expected %d updates to catch up but got %d", maxCatchUpTicks, catchUpCount)
+	}
+	// then we're back to one update per tick, the first one can be a little sooner as
+	// what's left of a tick isn't dropped
+	for i := 1 + maxCatchUpTicks; i < len(game.updates); i++ {
+		took := game.updates[i].Sub(game.updates[i-1])
+		if took > tickDuration ||
+			(took != tickDuration && i > 1+maxCatchUpTicks) {
+			t.Fatalf("update %d: expected to run a tick after the last update but ran %v after", i, took)
+		}
+	}
+
+	// the update that jumped the clock is reported as an overrun, then the ticks we dropped
+	// when the next report is due
+	if !strings.Contains(output, "1 updates took longer than "+tickDuration.String()+" (slowest: 1s), dropped 0 ticks") {
+		t.Errorf("expected slow update to be reported but got: %q", output)
+	}
+	if !strings.Contains(output, "0 updates took longer than "+tickDuration.String()+" (slowest: 0s), dropped 56 ticks") {
+		t.Errorf("expected dropped ticks to be reported but got: %q", output)
+	}
+}
+
+func TestOverrunReport(t *testing.T) {
+	start := time.Unix(1000, 0)
+	report := overrunReport{lastReportTime: start}
+	report.add(20 * time.Millisecond)
+	report.add(50 * time.Millisecond)
+	report.add(30 * time.Millisecond)
+	report.droppedTicks += 3
+
+	// nothing is logged until the report is due
+	if output := captureLog(func() {
+		report.logIfDue(start.Add(overrunReportInterval-time.Millisecond), tickDuration)
+	}); output != "" {
+		t.Errorf("expected nothing to be logged before the report is due but got: %q", output)
+	}
+	output := captureLog(func() {
+		report.logIfDue(start.Add(overrunReportInterval), tickDuration)
+	})
+	if expected := "warning: 3 updates took longer than " + tickDuration.String() + " (slowest: 50ms), dropped 3 ticks to catch up\n"; output != expected {
+		t.Errorf("expected report of %q but got %q", expected, output)
+	}
+
+	// the report starts again after logging and isn't logged if there's nothing to report
+	if output := captureLog(func() {
+		report.logIfDue(start.Add(2*overrunReportInterval), tickDuration)
+	}); output != "" {
+		t.Errorf("expected nothing to be logged without overruns but got: %q", output)
+	}
+}
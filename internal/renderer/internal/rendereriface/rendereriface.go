@@ -1,6 +1,10 @@
 package rendereriface
 
-import "image"
+import (
+	"image"
+
+	"github.com/silbinarywolf/toy-webrtc-mmo/internal/clock"
+)
 
 type ImageOptions struct {
 	X, Y           float32
@@ -21,6 +25,11 @@ type App interface {
 	SetRunnableOnUnfocused(v bool)
 	SetWindowSize(screenWidth, screenHeight int)
 	SetWindowTitle(title string)
+	// SetClock sets the clock used to time updates, this is only used by headless as
+	// Ebiten has its own
+	SetClock(clock clock.Clock)
+	// SetTickRate sets how many times per second Update is called
+	SetTickRate(tps int)
 	RunGame(game Game) error
 	NewImageFromImage(img image.Image) Image
 }
@@ -65,6 +65,9 @@ func (world *World) RemovePlayer(player *ent.Player) {
 func (world *World) Update() {
 	for i := range world.Players {
 		entity := world.Players[i]
+		if entity.IsInterpolated {
+			continue
+		}
 		entity.Update()
 	}
 }